@@ -0,0 +1,89 @@
+package haversine
+
+import (
+	"math"
+	"math/big"
+	"sort"
+)
+
+// DistanceFunc computes the distance between two points, like Haversine.
+type DistanceFunc func(lat1, lon1, lat2, lon2 float64) float64
+
+// PairError is the error of a candidate distance for one pair.
+type PairError struct {
+	Index int
+	Pair  Pair
+	Got   float64
+	Want  float64 // The precise distance rounded to float64.
+	ULP   float64 // Error in units in the last place of Want.
+	Abs   float64 // Error in km.
+}
+
+// ErrorReport summarises the error of a candidate over a set of pairs.
+type ErrorReport struct {
+	Pairs   int
+	Rounded int // Results equal to the correctly rounded distance.
+	MaxULP  float64
+	MeanULP float64
+	MaxAbs  float64     // In km.
+	MeanAbs float64     // In km.
+	Worst   []PairError // The pairs with the largest ULP error, largest first.
+}
+
+// MeasureError compares candidate with the precise distances refs of pairs,
+// as returned by Precise.Distances, keeping the worst pairs.
+func MeasureError(pairs []Pair, refs []*big.Float, candidate DistanceFunc, worst int) ErrorReport {
+	r := ErrorReport{Pairs: len(pairs)}
+	var ulpSum, absSum float64
+	for i, pair := range pairs {
+		e := pairError(i, pair, refs[i], candidate(pair.Y1, pair.X1, pair.Y2, pair.X2))
+		if e.Got == e.Want {
+			r.Rounded++
+		}
+		ulpSum += e.ULP
+		absSum += e.Abs
+		r.MaxULP = math.Max(r.MaxULP, e.ULP)
+		r.MaxAbs = math.Max(r.MaxAbs, e.Abs)
+		r.Worst = keepWorst(r.Worst, e, worst)
+	}
+	if len(pairs) > 0 {
+		r.MeanULP = ulpSum / float64(len(pairs))
+		r.MeanAbs = absSum / float64(len(pairs))
+	}
+	return r
+}
+
+func pairError(i int, pair Pair, ref *big.Float, got float64) PairError {
+	want, _ := ref.Float64()
+	e := PairError{Index: i, Pair: pair, Got: got, Want: want}
+	if math.IsNaN(got) || math.IsInf(got, 0) {
+		e.ULP, e.Abs = math.Inf(1), math.Inf(1)
+		return e
+	}
+	diff := new(big.Float).SetPrec(ref.Prec()).SetFloat64(got)
+	diff.Sub(diff, ref).Abs(diff)
+	e.Abs, _ = diff.Float64()
+	e.ULP = e.Abs / ulp(want)
+	return e
+}
+
+// ulp returns the spacing of float64 values at x.
+func ulp(x float64) float64 {
+	x = math.Abs(x)
+	return math.Nextafter(x, math.Inf(1)) - x
+}
+
+// keepWorst adds e to worst, sorted by decreasing ULP error, if it is among
+// the n largest.
+func keepWorst(worst []PairError, e PairError, n int) []PairError {
+	if n == 0 || (len(worst) == n && e.ULP <= worst[n-1].ULP) {
+		return worst
+	}
+	i := sort.Search(len(worst), func(i int) bool { return worst[i].ULP < e.ULP })
+	if len(worst) < n {
+		worst = append(worst, PairError{})
+	}
+	copy(worst[i+1:], worst[i:])
+	worst[i] = e
+	return worst
+}
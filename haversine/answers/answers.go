@@ -0,0 +1,250 @@
+// Package answers reads and writes the reference answer files of the
+// haversine generator: the distance of every generated pair, so that a
+// processor can check its results pair by pair.
+//
+// All values are little endian:
+//
+//	offset  size    field
+//	0       4       magic "HAVA"
+//	4       4       version, currently 1
+//	8       8       pair count n
+//	16      8*n     distance of every pair in kilometres, as float64
+//	16+8n   8       average distance, as float64
+//	24+8n   4       CRC-32 (IEEE) of all preceding bytes
+package answers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	// Magic starts every answer file.
+	Magic = "HAVA"
+	// Version is the format version this package reads and writes.
+	Version = 1
+
+	// HeaderSize is the size of the magic, version and pair count.
+	HeaderSize = 16
+	// TrailerSize is the size of the average and checksum.
+	TrailerSize = 12
+)
+
+// ErrChecksum reports an answer file whose contents do not match its
+// checksum.
+var ErrChecksum = errors.New("answers: checksum mismatch")
+
+// Writer streams the distances of an answer file.
+type Writer struct {
+	w     io.Writer
+	crc   hash.Hash32
+	count uint64 // Pairs declared in the header.
+	n     uint64 // Pairs written so far.
+	sum   float64
+	buf   [8]byte
+}
+
+// NewWriter writes the header of an answer file for count pairs to w.
+func NewWriter(w io.Writer, count uint64) (*Writer, error) {
+	aw := &Writer{crc: crc32.NewIEEE(), count: count}
+	aw.w = io.MultiWriter(w, aw.crc)
+
+	var header [HeaderSize]byte
+	copy(header[:], Magic)
+	binary.LittleEndian.PutUint32(header[4:], Version)
+	binary.LittleEndian.PutUint64(header[8:], count)
+	if _, err := aw.w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Add appends the distance of the next pair.
+func (aw *Writer) Add(distance float64) error {
+	if aw.n == aw.count {
+		return fmt.Errorf("answers: more than the %d declared pairs", aw.count)
+	}
+	aw.n++
+	aw.sum += distance
+	return aw.writeFloat(distance)
+}
+
+// Sum returns the total of the distances added so far, in the order they
+// were added.
+func (aw *Writer) Sum() float64 {
+	return aw.sum
+}
+
+// Close writes the average and checksum. It fails if fewer pairs were
+// added than declared. It does not close the underlying writer.
+func (aw *Writer) Close() error {
+	if aw.n != aw.count {
+		return fmt.Errorf("answers: %d of the %d declared pairs written", aw.n, aw.count)
+	}
+	if err := aw.writeFloat(average(aw.sum, aw.count)); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(aw.buf[:4], aw.crc.Sum32())
+	_, err := aw.w.Write(aw.buf[:4])
+	return err
+}
+
+func (aw *Writer) writeFloat(v float64) error {
+	binary.LittleEndian.PutUint64(aw.buf[:], math.Float64bits(v))
+	_, err := aw.w.Write(aw.buf[:])
+	return err
+}
+
+// average returns sum / count, or 0 for no pairs.
+func average(sum float64, count uint64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// File is a decoded answer file.
+type File struct {
+	Version   uint32
+	Distances []float64
+	Average   float64
+	Checksum  uint32
+}
+
+// Reader streams the distances of an answer file without holding them, and
+// verifies the checksum after the last one. Like bufio.Scanner, Next
+// advances to each distance in turn and Err reports why it stopped. The
+// distances are only known to be intact once Next has returned false and
+// Err is nil.
+type Reader struct {
+	r        io.Reader // Everything read passes through crc.
+	crc      hash.Hash32
+	version  uint32
+	count    uint64 // Pairs declared in the header.
+	n        uint64 // Distances read so far.
+	distance float64
+	average  float64
+	checksum uint32
+	done     bool
+	err      error
+	buf      [8]byte
+}
+
+// NewReader reads and checks the header of the answer file in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	ar := &Reader{crc: crc32.NewIEEE()}
+	ar.r = io.TeeReader(bufio.NewReader(r), ar.crc)
+
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(ar.r, header[:]); err != nil {
+		return nil, fmt.Errorf("answers: reading header: %w", err)
+	}
+	if string(header[:4]) != Magic {
+		return nil, fmt.Errorf("answers: bad magic %q, not an answer file", header[:4])
+	}
+	ar.version = binary.LittleEndian.Uint32(header[4:])
+	if ar.version != Version {
+		return nil, fmt.Errorf("answers: unsupported version %d", ar.version)
+	}
+	ar.count = binary.LittleEndian.Uint64(header[8:])
+	return ar, nil
+}
+
+// Version returns the format version of the file.
+func (ar *Reader) Version() uint32 { return ar.version }
+
+// Count returns the number of pairs the header declares.
+func (ar *Reader) Count() uint64 { return ar.count }
+
+// Next advances to the next distance. After the last one it reads the
+// trailer and verifies the checksum, and returns false.
+func (ar *Reader) Next() bool {
+	if ar.done {
+		return false
+	}
+	if ar.n == ar.count {
+		ar.done = true
+		ar.err = ar.readTrailer()
+		return false
+	}
+	if _, err := io.ReadFull(ar.r, ar.buf[:]); err != nil {
+		ar.done = true
+		ar.err = fmt.Errorf("answers: reading pair %d of %d: %w", ar.n, ar.count, err)
+		return false
+	}
+	ar.n++
+	ar.distance = math.Float64frombits(binary.LittleEndian.Uint64(ar.buf[:]))
+	return true
+}
+
+// Distance returns the distance Next advanced to.
+func (ar *Reader) Distance() float64 { return ar.distance }
+
+// Err returns the error that stopped Next, or nil once every distance has
+// been read and the checksum matches.
+func (ar *Reader) Err() error { return ar.err }
+
+// Average returns the stored average distance, valid once Next has returned
+// false with a nil Err.
+func (ar *Reader) Average() float64 { return ar.average }
+
+// Checksum returns the verified checksum, valid once Next has returned false
+// with a nil Err.
+func (ar *Reader) Checksum() uint32 { return ar.checksum }
+
+func (ar *Reader) readTrailer() error {
+	if _, err := io.ReadFull(ar.r, ar.buf[:]); err != nil {
+		return fmt.Errorf("answers: reading average: %w", err)
+	}
+	ar.average = math.Float64frombits(binary.LittleEndian.Uint64(ar.buf[:]))
+
+	sum := ar.crc.Sum32()
+	if _, err := io.ReadFull(ar.r, ar.buf[:4]); err != nil {
+		return fmt.Errorf("answers: reading checksum: %w", err)
+	}
+	ar.checksum = binary.LittleEndian.Uint32(ar.buf[:4])
+	if ar.checksum != sum {
+		return ErrChecksum
+	}
+	if n, _ := ar.r.Read(ar.buf[:1]); n != 0 {
+		return errors.New("answers: trailing bytes after the checksum")
+	}
+	return nil
+}
+
+// Read decodes and verifies an answer file, holding every distance in
+// memory. Use a Reader to stream them instead.
+func Read(r io.Reader) (*File, error) {
+	ar, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	// Grow the slice as values arrive rather than trusting the count with
+	// an allocation.
+	f := &File{Version: ar.Version()}
+	for ar.Next() {
+		f.Distances = append(f.Distances, ar.Distance())
+	}
+	if err := ar.Err(); err != nil {
+		return nil, err
+	}
+	f.Average, f.Checksum = ar.Average(), ar.Checksum()
+	return f, nil
+}
+
+// ReadFile decodes and verifies the answer file at path.
+func ReadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
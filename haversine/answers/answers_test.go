@@ -0,0 +1,137 @@
+package answers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func writeAnswers(t *testing.T, distances ...float64) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, uint64(len(distances)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range distances {
+		if err := w.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	b := writeAnswers(t, 1.5, 2.5, 5)
+	if len(b) != HeaderSize+3*8+TrailerSize {
+		t.Fatalf("file is %d bytes", len(b))
+	}
+	f, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Distances) != 3 || f.Distances[0] != 1.5 || f.Distances[2] != 5 || f.Average != 3 {
+		t.Errorf("read %+v", f)
+	}
+
+	empty, err := Read(bytes.NewReader(writeAnswers(t)))
+	if err != nil || len(empty.Distances) != 0 || empty.Average != 0 {
+		t.Errorf("empty file read as %+v, %v", empty, err)
+	}
+}
+
+func TestReadRejectsDamage(t *testing.T) {
+	good := writeAnswers(t, 1.5, 2.5)
+
+	flipped := append([]byte(nil), good...)
+	flipped[HeaderSize+3] ^= 1
+	if _, err := Read(bytes.NewReader(flipped)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("flipped bit: %v, want ErrChecksum", err)
+	}
+
+	tests := map[string][]byte{
+		"magic":     append([]byte("HAVB"), good[4:]...),
+		"truncated": good[:len(good)-1],
+		"trailing":  append(append([]byte(nil), good...), 0),
+		"version":   append(append([]byte("HAVA"), 2, 0, 0, 0), good[8:]...),
+	}
+	for name, b := range tests {
+		if _, err := Read(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriterEnforcesCount(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close with a missing pair succeeded")
+	}
+	if err := w.Add(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(2); err == nil {
+		t.Error("Add past the declared count succeeded")
+	}
+}
+
+func TestReaderStreams(t *testing.T) {
+	want := []float64{1.5, 2.5, 5}
+	ar, err := NewReader(bytes.NewReader(writeAnswers(t, want...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Count() != 3 || ar.Version() != Version {
+		t.Fatalf("count, version = %d, %d", ar.Count(), ar.Version())
+	}
+	var got []float64
+	for ar.Next() {
+		got = append(got, ar.Distance())
+	}
+	if err := ar.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] != 1.5 || got[1] != 2.5 || got[2] != 5 || ar.Average() != 3 {
+		t.Errorf("streamed %v, average %v", got, ar.Average())
+	}
+	if ar.Next() {
+		t.Error("Next succeeded after the last distance")
+	}
+}
+
+func TestReaderVerifiesChecksumAtEnd(t *testing.T) {
+	b := writeAnswers(t, 1.5, 2.5)
+	b[HeaderSize+3] ^= 1
+	ar, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for ar.Next() {
+		n++
+	}
+	if n != 2 || !errors.Is(ar.Err(), ErrChecksum) {
+		t.Errorf("streamed %d distances, err %v, want 2 and ErrChecksum", n, ar.Err())
+	}
+
+	// A header declaring more pairs than the file holds fails when the
+	// data runs out, without allocating for the declared count.
+	b = writeAnswers(t, 1.5)
+	binary.LittleEndian.PutUint64(b[8:], 1<<60)
+	if ar, err = NewReader(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	for ar.Next() {
+	}
+	if ar.Err() == nil {
+		t.Error("truncated file streamed without error")
+	}
+}
@@ -0,0 +1,191 @@
+package haversine
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// SampleType names a distribution.
+type SampleType string
+
+const (
+	Uniform       SampleType = "uniform"
+	Clustered     SampleType = "clustered"
+	Sphere        SampleType = "sphere"
+	Gaussian      SampleType = "gaussian"
+	Antipodal     SampleType = "antipodal"
+	Polar         SampleType = "polar"
+	Dateline      SampleType = "dateline"
+	NearIdentical SampleType = "near-identical"
+)
+
+// Distribution is a named way of sampling pairs.
+type Distribution struct {
+	Name     SampleType
+	Domain   string // The points the distribution produces, for the usage.
+	Generate func(pairsOut, binOut io.Writer, opts Options) (float64, error)
+}
+
+// Distributions lists every distribution the generator offers. Apart from
+// uniform and clustered, they target the inputs where fast approximations of
+// the haversine formula lose precision.
+var Distributions = []Distribution{
+	{Uniform, "longitude and latitude uniform in [-180, 180) and [-90, 90)", uniform},
+	{Sphere, "uniform over the surface of the sphere: longitude uniform, latitude the arcsine of a value uniform in [-1, 1)", sphere},
+	{Clustered, "uniform in a box of ±radius degrees around a random center per cluster, clipped to valid coordinates", clustered},
+	{Gaussian, "normal with standard deviation sigma around a random center per cluster; longitude wraps, latitude is clamped to ±90", gaussian},
+	{Antipodal, "first point uniform, second its antipode moved by up to ±delta degrees in each coordinate: distances near half the circumference", antipodal},
+	{Polar, "both points within 5 degrees of latitude of the same pole, any longitude", polar},
+	{Dateline, "longitudes within 5 degrees either side of ±180, one point on each side; first latitude uniform, second within ±5 degrees of it: longitudes about 360 degrees apart for a short distance", dateline},
+	{NearIdentical, "first point uniform, second moved by up to ±delta degrees in each coordinate: distances near zero", nearIdentical},
+}
+
+// LookupDistribution returns the distribution called name.
+func LookupDistribution(name SampleType) (Distribution, bool) {
+	for _, d := range Distributions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Distribution{}, false
+}
+
+// DistributionUsage lists the distributions and their domains.
+func DistributionUsage() string {
+	var b strings.Builder
+	for _, d := range Distributions {
+		b.WriteString("  " + string(d.Name) + "\n    \t" + d.Domain + "\n")
+	}
+	return b.String()
+}
+
+const (
+	// ClusterSigma is the default standard deviation of a gaussian cluster,
+	// in degrees.
+	ClusterSigma float64 = 8
+	// PairDelta is the default largest offset of the second point of a
+	// near-antipodal or near-identical pair, in degrees: about 111 m.
+	PairDelta float64 = 1e-3
+
+	// PolarBand is how far from a pole, in degrees of latitude, polar
+	// points lie.
+	PolarBand float64 = 5
+	// DatelineBand is how far from the ±180 longitude line, in degrees,
+	// dateline points lie, and how far apart their latitudes are.
+	DatelineBand float64 = 5
+)
+
+// everyCluster returns sample for each of the clusters.
+func everyCluster(opts Options, sample pairSampler) []pairSampler {
+	samplers := make([]pairSampler, opts.Clusters)
+	for i := range samplers {
+		samplers[i] = sample
+	}
+	return samplers
+}
+
+// sphere generates points uniformly distributed over the sphere's area.
+// Sampling the latitude uniformly, as uniform does, puts as many points in
+// the thin bands near the poles as in those of equal height at the equator;
+// the arcsine of a uniform sine gives every band points in proportion to its
+// area instead.
+func sphere(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	return generate(pairsOut, binOut, opts, everyCluster(opts, func(lr *rand.Rand) (Point, Point) {
+		return spherePoint(lr), spherePoint(lr)
+	}))
+}
+
+func spherePoint(lr *rand.Rand) Point {
+	return Point{lr.Float64()*360 - 180, math.Asin(lr.Float64()*2-1) * 180 / math.Pi}
+}
+
+// gaussian generates clusters of normally distributed points.
+func gaussian(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	samplers := make([]pairSampler, opts.Clusters)
+	for i, center := range clusterCenters(opts) {
+		point := func(lr *rand.Rand) Point {
+			return Point{
+				X: wrapLongitude(center.X + lr.NormFloat64()*opts.Sigma),
+				Y: clampLatitude(center.Y + lr.NormFloat64()*opts.Sigma),
+			}
+		}
+		samplers[i] = func(lr *rand.Rand) (Point, Point) {
+			return point(lr), point(lr)
+		}
+	}
+	return generate(pairsOut, binOut, opts, samplers)
+}
+
+// antipodal generates pairs of nearly opposite points, where the haversine
+// argument to asin is close to 1.
+func antipodal(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	return generate(pairsOut, binOut, opts, everyCluster(opts, func(lr *rand.Rand) (Point, Point) {
+		p1 := Point{lr.Float64()*360 - 180, lr.Float64()*180 - 90}
+		p2 := Point{
+			X: wrapLongitude(p1.X + 180 + offset(lr, opts.Delta)),
+			Y: clampLatitude(-p1.Y + offset(lr, opts.Delta)),
+		}
+		return p1, p2
+	}))
+}
+
+// polar generates pairs near a pole, where the cosine of the latitude is
+// close to 0 and longitudes are nearly meaningless.
+func polar(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	return generate(pairsOut, binOut, opts, everyCluster(opts, func(lr *rand.Rand) (Point, Point) {
+		pole := 1.0
+		if lr.Intn(2) == 0 {
+			pole = -1
+		}
+		p1 := Point{lr.Float64()*360 - 180, pole * (90 - lr.Float64()*PolarBand)}
+		p2 := Point{lr.Float64()*360 - 180, pole * (90 - lr.Float64()*PolarBand)}
+		return p1, p2
+	}))
+}
+
+// dateline generates pairs on either side of the ±180 longitude line at
+// nearby latitudes, whose longitude difference is close to 360 degrees for a
+// short distance.
+func dateline(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	return generate(pairsOut, binOut, opts, everyCluster(opts, func(lr *rand.Rand) (Point, Point) {
+		p1 := Point{180 - lr.Float64()*DatelineBand, lr.Float64()*180 - 90}
+		p2 := Point{-180 + lr.Float64()*DatelineBand, clampLatitude(p1.Y + offset(lr, DatelineBand))}
+		if lr.Intn(2) == 0 {
+			p1, p2 = p2, p1
+		}
+		return p1, p2
+	}))
+}
+
+// nearIdentical generates pairs of points a tiny distance apart.
+func nearIdentical(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	return generate(pairsOut, binOut, opts, everyCluster(opts, func(lr *rand.Rand) (Point, Point) {
+		p1 := Point{lr.Float64()*360 - 180, lr.Float64()*180 - 90}
+		p2 := Point{
+			X: wrapLongitude(p1.X + offset(lr, opts.Delta)),
+			Y: clampLatitude(p1.Y + offset(lr, opts.Delta)),
+		}
+		return p1, p2
+	}))
+}
+
+// offset returns a uniform offset in [-delta, delta).
+func offset(lr *rand.Rand, delta float64) float64 {
+	return (lr.Float64()*2 - 1) * delta
+}
+
+// wrapLongitude wraps x into [-180, 180).
+func wrapLongitude(x float64) float64 {
+	x = math.Mod(x+180, 360)
+	if x < 0 {
+		x += 360
+	}
+	return x - 180
+}
+
+// clampLatitude clamps y to [-90, 90].
+func clampLatitude(y float64) float64 {
+	return math.Max(-90, math.Min(90, y))
+}
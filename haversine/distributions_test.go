@@ -0,0 +1,102 @@
+package haversine
+
+import (
+	"math"
+	"testing"
+)
+
+// TestDistributionDomains checks that every distribution stays within its
+// documented domain.
+func TestDistributionDomains(t *testing.T) {
+	halfCircumference := math.Pi * EarthRadius
+	// Moving each coordinate of a point by up to delta degrees moves it by at
+	// most sqrt(2)*delta degrees of arc.
+	deltaKm := math.Sqrt2 * PairDelta * math.Pi / 180 * EarthRadius
+	// Dateline points are at most 2 bands apart in longitude and 1 in
+	// latitude.
+	datelineKm := 3 * DatelineBand * math.Pi / 180 * EarthRadius
+
+	checks := map[SampleType]func(p Pair, d float64) bool{
+		Uniform:   func(p Pair, d float64) bool { return true },
+		Sphere:    func(p Pair, d float64) bool { return true },
+		Clustered: func(p Pair, d float64) bool { return true },
+		Gaussian:  func(p Pair, d float64) bool { return true },
+		Antipodal: func(p Pair, d float64) bool { return d >= halfCircumference-deltaKm },
+		Polar: func(p Pair, d float64) bool {
+			return math.Abs(p.Y1) >= 90-PolarBand && math.Abs(p.Y2) >= 90-PolarBand && p.Y1*p.Y2 > 0
+		},
+		Dateline: func(p Pair, d float64) bool {
+			east, west := math.Max(p.X1, p.X2), math.Min(p.X1, p.X2)
+			return east >= 180-DatelineBand && west < -180+DatelineBand && math.Abs(p.Y1-p.Y2) <= DatelineBand && d <= datelineKm
+		},
+		NearIdentical: func(p Pair, d float64) bool { return d <= deltaKm },
+	}
+	if len(checks) != len(Distributions) {
+		t.Fatalf("%d checks for %d Distributions", len(checks), len(Distributions))
+	}
+
+	for _, dist := range Distributions {
+		pairs := generateChecked(t, dist.Generate, DefaultOptions(11, 2000))
+		for i, p := range pairs {
+			if p.X1 < -180 || p.X1 >= 180 || p.X2 < -180 || p.X2 >= 180 || math.Abs(p.Y1) > 90 || math.Abs(p.Y2) > 90 {
+				t.Fatalf("%s: pair %d %+v outside the valid coordinates", dist.Name, i, p)
+			}
+			if d := Haversine(p.Y1, p.X1, p.Y2, p.X2); !checks[dist.Name](p, d) {
+				t.Fatalf("%s: pair %d %+v at %g km outside the domain", dist.Name, i, p, d)
+			}
+		}
+	}
+}
+
+func TestGaussianSigma(t *testing.T) {
+	opts := DefaultOptions(4, 20000)
+	opts.Clusters, opts.Sigma = 1, 0.5
+	pairs := generateChecked(t, gaussian, opts)
+	center := clusterCenters(opts)[0]
+	var sq float64
+	for _, p := range pairs {
+		sq += (p.Y1-center.Y)*(p.Y1-center.Y) + (p.Y2-center.Y)*(p.Y2-center.Y)
+	}
+	// Unless the center is within a few sigma of a pole, the latitudes have
+	// the requested deviation.
+	if math.Abs(center.Y) < 85 {
+		if got := math.Sqrt(sq / float64(2*len(pairs))); math.Abs(got-opts.Sigma) > 0.02 {
+			t.Errorf("latitude deviation %g, want %g", got, opts.Sigma)
+		}
+	}
+}
+
+// TestSphereIsAreaUniform checks that a latitude band holds a share of the
+// points proportional to its area, unlike with uniform.
+func TestSphereIsAreaUniform(t *testing.T) {
+	highShare := func(pairs []Pair) float64 {
+		var high int
+		for _, p := range pairs {
+			for _, y := range []float64{p.Y1, p.Y2} {
+				if math.Abs(y) > 60 {
+					high++
+				}
+			}
+		}
+		return float64(high) / float64(2*len(pairs))
+	}
+	// The caps above 60 degrees hold 1 - sin(60°) of the sphere's area but
+	// a third of the range of latitudes.
+	if got, want := highShare(generateChecked(t, sphere, DefaultOptions(2, 20000))), 1-math.Sqrt(3)/2; math.Abs(got-want) > 0.01 {
+		t.Errorf("sphere: %.3f of the points above 60 degrees, want %.3f", got, want)
+	}
+	if got := highShare(generateChecked(t, uniform, DefaultOptions(2, 20000))); math.Abs(got-1.0/3) > 0.01 {
+		t.Errorf("uniform: %.3f of the points above 60 degrees, want 0.333", got)
+	}
+}
+
+func TestWrapLongitude(t *testing.T) {
+	tests := []struct{ in, want float64 }{
+		{0, 0}, {179.5, 179.5}, {180, -180}, {181, -179}, {-181, 179}, {540, -180}, {-180, -180},
+	}
+	for _, tt := range tests {
+		if got := wrapLongitude(tt.in); got != tt.want {
+			t.Errorf("wrapLongitude(%g) = %g, want %g", tt.in, got, tt.want)
+		}
+	}
+}
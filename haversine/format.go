@@ -0,0 +1,404 @@
+package haversine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format is an encoding of the generated pairs. Every format writes the
+// coordinates exactly: text formats print the shortest decimal that parses
+// back to the same float64, so one seed gives the same values in all of them,
+// and ReadPairs decodes them back.
+type Format struct {
+	Name string
+	Ext  string // Extension of the output file.
+	Doc  string
+
+	// Text formats write header, then the pairs, each appended by
+	// appendPair and separated by separator, then footer.
+	header, separator, footer string
+	appendPair                func(b []byte, p1, p2 Point) []byte
+
+	// read decodes a whole file of the format.
+	read func(r io.Reader) ([]Pair, error)
+
+	// SoA marks the raw binary format: the little-endian float64 arrays X1,
+	// Y1, X2 and Y2 of every pair, one after the other, with no header. The
+	// pair count is the file size / 32.
+	SoA bool
+}
+
+// JSONFormat is the default format.
+const JSONFormat = "json"
+
+// Formats lists the output formats of the generator.
+var Formats = []Format{
+	{
+		Name: JSONFormat, Ext: ".json", Doc: "one pair per line in a JSON object",
+		header: "{\"pairs\": [\n", separator: ",\n", footer: "\n]}\n",
+		appendPair: appendPairJSON,
+		read:       readPairsJSON,
+	},
+	{
+		Name: "compact", Ext: ".json", Doc: "JSON without whitespace",
+		header: `{"pairs":[`, separator: ",", footer: "]}",
+		appendPair: appendPairCompact,
+		read:       readPairsJSON,
+	},
+	{
+		Name: "pretty", Ext: ".json", Doc: "indented JSON, one coordinate per line",
+		header: "{\n  \"pairs\": [\n", separator: ",\n", footer: "\n  ]\n}\n",
+		appendPair: appendPairPretty,
+		read:       readPairsJSON,
+	},
+	{
+		Name: "exp", Ext: ".json", Doc: "JSON with numbers in exponent notation",
+		header: "{\"pairs\": [\n", separator: ",\n", footer: "\n]}\n",
+		appendPair: appendPairExp,
+		read:       readPairsJSON,
+	},
+	{
+		Name: "ndjson", Ext: ".ndjson", Doc: "one compact JSON object per line",
+		appendPair: func(b []byte, p1, p2 Point) []byte {
+			return append(appendPairCompact(b, p1, p2), '\n')
+		},
+		read: readPairsNDJSON,
+	},
+	{
+		Name: "csv", Ext: ".csv", Doc: "X1,Y1,X2,Y2 with a header line",
+		header: "X1,Y1,X2,Y2\n",
+		appendPair: func(b []byte, p1, p2 Point) []byte {
+			for i, v := range [4]float64{p1.X, p1.Y, p2.X, p2.Y} {
+				if i > 0 {
+					b = append(b, ',')
+				}
+				b = strconv.AppendFloat(b, v, 'f', -1, 64)
+			}
+			return append(b, '\n')
+		},
+		read: readPairsCSV,
+	},
+	{
+		Name: "binary", Ext: ".f64", Doc: "raw little-endian float64 arrays X1, Y1, X2, Y2 of n values each",
+		SoA:  true,
+		read: readPairsSoA,
+	},
+}
+
+// LookupFormat returns the format called name.
+func LookupFormat(name string) (*Format, bool) {
+	for i := range Formats {
+		if Formats[i].Name == name {
+			return &Formats[i], true
+		}
+	}
+	return nil, false
+}
+
+// FormatForPath returns the format of the file at path, chosen by its
+// extension. Every JSON variant reads the same way, so .json files get the
+// default JSON format.
+func FormatForPath(path string) (*Format, bool) {
+	ext := filepath.Ext(path)
+	for i := range Formats {
+		if Formats[i].Ext == ext {
+			return &Formats[i], true
+		}
+	}
+	return nil, false
+}
+
+// FormatUsage lists the formats.
+func FormatUsage() string {
+	var b strings.Builder
+	for _, f := range Formats {
+		b.WriteString("  " + f.Name + "\n    \t" + f.Doc + "\n")
+	}
+	return b.String()
+}
+
+// ReadPairs decodes every pair of a file in format f from r.
+func (f *Format) ReadPairs(r io.Reader) ([]Pair, error) {
+	return f.read(r)
+}
+
+// ReadPairsFile reads the pairs of the file at path in the format its
+// extension names, as FormatForPath picks it.
+func ReadPairsFile(path string) ([]Pair, error) {
+	f, ok := FormatForPath(path)
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown pairs format %q", path, filepath.Ext(path))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pairs, err := f.ReadPairs(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pairs, nil
+}
+
+// readPairsJSON decodes the pairs array of a JSON object one pair at a
+// time, which reads any of the JSON formats without holding the document.
+func readPairsJSON(r io.Reader) ([]Pair, error) {
+	dec := json.NewDecoder(r)
+	expect := func(want json.Delim) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != want {
+			return fmt.Errorf("got %v, want %v", tok, want)
+		}
+		return nil
+	}
+
+	if err := expect('{'); err != nil {
+		return nil, err
+	}
+	pairs := []Pair{}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "pairs" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expect('['); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			var p Pair
+			if err := dec.Decode(&p); err != nil {
+				return nil, fmt.Errorf("pair %d: %w", len(pairs), err)
+			}
+			pairs = append(pairs, p)
+		}
+		if err := expect(']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := expect('}'); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// readPairsNDJSON decodes one JSON object per line.
+func readPairsNDJSON(r io.Reader) ([]Pair, error) {
+	dec := json.NewDecoder(r)
+	pairs := []Pair{}
+	for {
+		var p Pair
+		if err := dec.Decode(&p); err == io.EOF {
+			return pairs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("pair %d: %w", len(pairs), err)
+		}
+		pairs = append(pairs, p)
+	}
+}
+
+// readPairsCSV decodes the header line and one X1,Y1,X2,Y2 record per pair.
+func readPairsCSV(r io.Reader) ([]Pair, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if strings.Join(header, ",") != "X1,Y1,X2,Y2" {
+		return nil, fmt.Errorf("header %q, want X1,Y1,X2,Y2", strings.Join(header, ","))
+	}
+
+	pairs := []Pair{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var v [4]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(rec[i], 64); err != nil {
+				return nil, fmt.Errorf("pair %d: %w", len(pairs), err)
+			}
+		}
+		pairs = append(pairs, Pair{X1: v[0], Y1: v[1], X2: v[2], Y2: v[3]})
+	}
+}
+
+// readPairsSoA decodes the four float64 arrays of the binary format. The
+// arrays are consecutive, so the whole file is read before the first pair is
+// complete.
+func readPairsSoA(r io.Reader) ([]Pair, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%32 != 0 {
+		return nil, fmt.Errorf("binary pairs of %d bytes, not a whole number of pairs", len(data))
+	}
+	n := len(data) / 32
+	value := func(k, i int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[(k*n+i)*8:]))
+	}
+	pairs := make([]Pair, n)
+	for i := range pairs {
+		pairs[i] = Pair{X1: value(0, i), Y1: value(1, i), X2: value(2, i), Y2: value(3, i)}
+	}
+	return pairs, nil
+}
+
+// appendPairJSON appends the JSON object of the pair p1, p2 to b.
+func appendPairJSON(b []byte, p1, p2 Point) []byte {
+	b = append(b, `{"X1": `...)
+	b = strconv.AppendFloat(b, p1.X, 'f', -1, 64)
+	b = append(b, `, "Y1": `...)
+	b = strconv.AppendFloat(b, p1.Y, 'f', -1, 64)
+	b = append(b, `, "X2": `...)
+	b = strconv.AppendFloat(b, p2.X, 'f', -1, 64)
+	b = append(b, `, "Y2": `...)
+	b = strconv.AppendFloat(b, p2.Y, 'f', -1, 64)
+	return append(b, '}')
+}
+
+func appendPairCompact(b []byte, p1, p2 Point) []byte {
+	b = append(b, `{"X1":`...)
+	b = strconv.AppendFloat(b, p1.X, 'f', -1, 64)
+	b = append(b, `,"Y1":`...)
+	b = strconv.AppendFloat(b, p1.Y, 'f', -1, 64)
+	b = append(b, `,"X2":`...)
+	b = strconv.AppendFloat(b, p2.X, 'f', -1, 64)
+	b = append(b, `,"Y2":`...)
+	b = strconv.AppendFloat(b, p2.Y, 'f', -1, 64)
+	return append(b, '}')
+}
+
+func appendPairPretty(b []byte, p1, p2 Point) []byte {
+	b = append(b, "    {\n      \"X1\": "...)
+	b = strconv.AppendFloat(b, p1.X, 'f', -1, 64)
+	b = append(b, ",\n      \"Y1\": "...)
+	b = strconv.AppendFloat(b, p1.Y, 'f', -1, 64)
+	b = append(b, ",\n      \"X2\": "...)
+	b = strconv.AppendFloat(b, p2.X, 'f', -1, 64)
+	b = append(b, ",\n      \"Y2\": "...)
+	b = strconv.AppendFloat(b, p2.Y, 'f', -1, 64)
+	return append(b, "\n    }"...)
+}
+
+func appendPairExp(b []byte, p1, p2 Point) []byte {
+	b = append(b, `{"X1": `...)
+	b = strconv.AppendFloat(b, p1.X, 'e', -1, 64)
+	b = append(b, `, "Y1": `...)
+	b = strconv.AppendFloat(b, p1.Y, 'e', -1, 64)
+	b = append(b, `, "X2": `...)
+	b = strconv.AppendFloat(b, p2.X, 'e', -1, 64)
+	b = append(b, `, "Y2": `...)
+	b = strconv.AppendFloat(b, p2.Y, 'e', -1, 64)
+	return append(b, '}')
+}
+
+// putPairSoA stores pair j of a chunk of n pairs in b, which holds the
+// chunk's four arrays one after the other.
+func putPairSoA(b []byte, j, n int, p1, p2 Point) {
+	for k, v := range [4]float64{p1.X, p1.Y, p2.X, p2.Y} {
+		binary.LittleEndian.PutUint64(b[(k*n+j)*8:], math.Float64bits(v))
+	}
+}
+
+// encoder writes formatted chunks to the pairs output.
+type encoder interface {
+	begin() error
+	write(c *chunk) error
+	end() error
+}
+
+// newEncoder returns an encoder of pairs pairs in format f to w. The binary
+// format writes its four arrays at their offsets, so w must be an
+// io.WriterAt.
+func newEncoder(w io.Writer, f *Format, pairs int) (encoder, error) {
+	if !f.SoA {
+		return &textEncoder{w: w, f: f}, nil
+	}
+	wa, ok := w.(io.WriterAt)
+	if !ok {
+		return nil, errors.New("the binary format needs a seekable output file")
+	}
+	e := &soaEncoder{}
+	for k := range e.arrays {
+		e.arrays[k] = bufio.NewWriterSize(io.NewOffsetWriter(wa, int64(k*pairs*8)), soaBufferSize)
+	}
+	return e, nil
+}
+
+type textEncoder struct {
+	w io.Writer
+	f *Format
+}
+
+func (e *textEncoder) begin() error {
+	_, err := io.WriteString(e.w, e.f.header)
+	return err
+}
+
+func (e *textEncoder) write(c *chunk) error {
+	_, err := e.w.Write(c.data)
+	return err
+}
+
+func (e *textEncoder) end() error {
+	_, err := io.WriteString(e.w, e.f.footer)
+	return err
+}
+
+// soaBufferSize is the size of the buffer in front of each binary array.
+const soaBufferSize = 256 << 10
+
+// soaEncoder buffers each of the four arrays on its way to its section of
+// the file.
+type soaEncoder struct {
+	arrays [4]*bufio.Writer
+}
+
+func (e *soaEncoder) begin() error { return nil }
+
+func (e *soaEncoder) write(c *chunk) error {
+	n := c.pairs * 8
+	for k, w := range e.arrays {
+		if _, err := w.Write(c.data[k*n : (k+1)*n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *soaEncoder) end() error {
+	for _, w := range e.arrays {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package haversine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+// parsePairs decodes the pairs output of format f.
+func parsePairs(t *testing.T, f *Format, data []byte) []Pair {
+	t.Helper()
+	var pairs []Pair
+	switch f.Name {
+	case "ndjson":
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			var p Pair
+			if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+				t.Fatalf("%s: %v in line %q", f.Name, err, sc.Text())
+			}
+			pairs = append(pairs, p)
+		}
+	case "csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records[1:] {
+			var v [4]float64
+			for i := range v {
+				if v[i], err = strconv.ParseFloat(rec[i], 64); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pairs = append(pairs, Pair{v[0], v[1], v[2], v[3]})
+		}
+	case "binary":
+		if len(data)%32 != 0 {
+			t.Fatalf("binary output of %d bytes, not a whole number of pairs", len(data))
+		}
+		n := len(data) / 32
+		value := func(k, i int) float64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(data[(k*n+i)*8:]))
+		}
+		for i := 0; i < n; i++ {
+			pairs = append(pairs, Pair{value(0, i), value(1, i), value(2, i), value(3, i)})
+		}
+	default:
+		var doc struct{ Pairs []Pair }
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		pairs = doc.Pairs
+	}
+	return pairs
+}
+
+// TestFormatsHoldTheSameValues generates the same pairs in every format,
+// over several chunks, and checks that they decode to the same values.
+func TestFormatsHoldTheSameValues(t *testing.T) {
+	opts := DefaultOptions(21, 2*chunkPairs+5)
+	opts.Clusters = 2
+	want := generateChecked(t, clustered, opts)
+
+	for i := range Formats {
+		f := &Formats[i]
+		opts.Format = f
+		var pairsOut io.Writer
+		var buf bytes.Buffer
+		var file *os.File
+		if f.SoA {
+			var err error
+			if file, err = os.Create(filepath.Join(t.TempDir(), "data"+f.Ext)); err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			pairsOut = file
+		} else {
+			pairsOut = &buf
+		}
+
+		var binOut bytes.Buffer
+		if _, err := clustered(pairsOut, &binOut, opts); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		data := buf.Bytes()
+		if file != nil {
+			var err error
+			if data, err = os.ReadFile(file.Name()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := answers.Read(&binOut); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+
+		checkPairs(t, f.Name+" parsed", parsePairs(t, f, data), want)
+
+		// The package's own reader must give back exactly what was written.
+		got, err := f.ReadPairs(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: ReadPairs: %v", f.Name, err)
+		}
+		checkPairs(t, f.Name+" ReadPairs", got, want)
+	}
+}
+
+func checkPairs(t *testing.T, name string, got, want []Pair) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d pairs, want %d", name, len(got), len(want))
+	}
+	for j := range got {
+		if got[j] != want[j] {
+			t.Fatalf("%s: pair %d = %+v, want %+v", name, j, got[j], want[j])
+		}
+	}
+}
+
+func TestReadPairsFile(t *testing.T) {
+	want := []Pair{{1.5, -2.25, 179.999, -89.5}, {-0.1, 0.2, 1e-7, 45}}
+	for i := range Formats {
+		f := &Formats[i]
+		path := filepath.Join(t.TempDir(), "pairs"+f.Ext)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.SoA {
+			data := make([]byte, 32*len(want))
+			for j, p := range want {
+				putPairSoA(data, j, len(want), Point{p.X1, p.Y1}, Point{p.X2, p.Y2})
+			}
+			_, err = file.Write(data)
+		} else {
+			b := []byte(f.header)
+			for j, p := range want {
+				if j > 0 {
+					b = append(b, f.separator...)
+				}
+				b = f.appendPair(b, Point{p.X1, p.Y1}, Point{p.X2, p.Y2})
+			}
+			_, err = file.Write(append(b, f.footer...))
+		}
+		if err := errors.Join(err, file.Close()); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadPairsFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		checkPairs(t, f.Name, got, want)
+	}
+}
+
+func TestReadPairsRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		format, data string
+	}{
+		{"json", `{"pairs": [{"X1": 1, "Y1": 2, "X2": 3, "Y2": 4}`},
+		{"json", `[{"X1": 1}]`},
+		{"compact", `{"pairs":[{"X1":"a"}]}`},
+		{"ndjson", "{\"X1\":1,\"Y1\":2,\"X2\":3,\"Y2\":4}\n{\"X1\":"},
+		{"csv", "X1,Y1,X2\n1,2,3\n"},
+		{"csv", "Y1,X1,X2,Y2\n1,2,3,4\n"},
+		{"csv", "X1,Y1,X2,Y2\n1,2,3,x\n"},
+		{"binary", string(make([]byte, 33))},
+	}
+
+	for _, tt := range tests {
+		f, _ := LookupFormat(tt.format)
+		if _, err := f.ReadPairs(strings.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: read %q without error", tt.format, tt.data)
+		}
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"data_10.json", "json"},
+		{"out/data.ndjson", "ndjson"},
+		{"data.csv", "csv"},
+		{"data.f64", "binary"},
+		{"data.txt", ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if f, ok := FormatForPath(tt.path); ok {
+			got = f.Name
+		}
+		if got != tt.want {
+			t.Errorf("FormatForPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyOutputs(t *testing.T) {
+	for i := range Formats {
+		f := &Formats[i]
+		if f.SoA {
+			continue
+		}
+		opts := DefaultOptions(1, 0)
+		opts.Format = f
+		var pairsOut, binOut bytes.Buffer
+		if _, err := uniform(&pairsOut, &binOut, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := parsePairs(t, f, pairsOut.Bytes()); len(got) != 0 {
+			t.Errorf("%s: %d pairs, want none", f.Name, len(got))
+		}
+		if got, err := f.ReadPairs(&pairsOut); err != nil || len(got) != 0 {
+			t.Errorf("%s: ReadPairs = %d pairs, %v, want none", f.Name, len(got), err)
+		}
+	}
+}
+
+func TestBinaryNeedsAFile(t *testing.T) {
+	opts := DefaultOptions(1, 10)
+	opts.Format, _ = LookupFormat("binary")
+	var pairsOut, binOut bytes.Buffer
+	if _, err := uniform(&pairsOut, &binOut, opts); err == nil {
+		t.Error("binary output to a buffer succeeded")
+	}
+}
@@ -0,0 +1,294 @@
+package haversine
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+// Options controls what the generator produces.
+type Options struct {
+	Seed     int
+	Pairs    int     // Exact number of pairs to generate.
+	Clusters int     // Number of clusters, each generated by its own goroutine.
+	Radius   float64 // Half the side of a clustered cluster, in degrees.
+	Sigma    float64 // Standard deviation of a gaussian cluster, in degrees.
+	Delta    float64 // Largest offset of a near-antipodal or near-identical point, in degrees.
+	Format   *Format // Encoding of the pairs output.
+
+	Histogram *Histogram // If set, collects the distance of every pair.
+}
+
+// DefaultOptions returns the options the generator command uses by default
+// for seed and pairs.
+func DefaultOptions(seed, pairs int) Options {
+	f, _ := LookupFormat(JSONFormat)
+	return Options{
+		Seed:     seed,
+		Pairs:    pairs,
+		Clusters: NumClusters,
+		Radius:   ClusterSize,
+		Sigma:    ClusterSigma,
+		Delta:    PairDelta,
+		Format:   f,
+	}
+}
+
+// Validate reports options the generator cannot honour.
+func (o Options) Validate() error {
+	switch {
+	case o.Pairs < 0:
+		return fmt.Errorf("negative pair count %d", o.Pairs)
+	case o.Clusters < 1:
+		return fmt.Errorf("cluster count %d, need at least 1", o.Clusters)
+	case !(o.Radius > 0 && o.Radius <= 180):
+		return fmt.Errorf("cluster radius %g, want more than 0 and at most 180 degrees", o.Radius)
+	case !(o.Sigma > 0 && o.Sigma <= 180):
+		return fmt.Errorf("cluster sigma %g, want more than 0 and at most 180 degrees", o.Sigma)
+	case !(o.Delta >= 0 && o.Delta <= 90):
+		return fmt.Errorf("pair delta %g, want 0 to 90 degrees", o.Delta)
+	case o.Format == nil:
+		return fmt.Errorf("no output format")
+	}
+	return nil
+}
+
+// clusterPairs splits pairs across clusters. The first pairs % clusters
+// clusters take one extra pair, so that the counts add up to pairs exactly.
+func clusterPairs(pairs, clusters int) []int {
+	counts := make([]int, clusters)
+	for i := range counts {
+		counts[i] = pairs / clusters
+		if i < pairs%clusters {
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// NumClusters is the default number of clusters.
+const NumClusters int = 32
+
+// pairSampler draws the two points of a pair from a cluster's random source.
+type pairSampler func(r *rand.Rand) (Point, Point)
+
+// uniform generates a uniform distribution of haversine distances.
+func uniform(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	return generate(pairsOut, binOut, opts, everyCluster(opts, func(lr *rand.Rand) (Point, Point) {
+		p1 := Point{lr.Float64()*360 - 180, lr.Float64()*180 - 90}
+		p2 := Point{lr.Float64()*360 - 180, lr.Float64()*180 - 90}
+		return p1, p2
+	}))
+}
+
+// ClusterSize is the default cluster radius in degrees.
+// The cluster will be a square with sides of length 2*ClusterSize around its
+// center, clipped to the valid coordinates.
+const ClusterSize float64 = 32
+
+type Cluster struct {
+	Xmin, Xmax float64
+	Ymin, Ymax float64
+}
+
+// clustered generates a clustered distribution of haversine distances.
+func clustered(pairsOut, binOut io.Writer, opts Options) (float64, error) {
+	// Generate a clustered distribution of points.
+	samplers := make([]pairSampler, opts.Clusters)
+	for i, center := range clusterCenters(opts) {
+		// Create a bounding box around the center.
+		c := Cluster{
+			Xmin: math.Max(center.X-opts.Radius, -180),
+			Xmax: math.Min(center.X+opts.Radius, 180),
+			Ymin: math.Max(center.Y-opts.Radius, -90),
+			Ymax: math.Min(center.Y+opts.Radius, 90),
+		}
+		samplers[i] = func(lr *rand.Rand) (Point, Point) {
+			p1 := Point{X: lr.Float64()*(c.Xmax-c.Xmin) + c.Xmin, Y: lr.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
+			p2 := Point{X: lr.Float64()*(c.Xmax-c.Xmin) + c.Xmin, Y: lr.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
+			return p1, p2
+		}
+	}
+	return generate(pairsOut, binOut, opts, samplers)
+}
+
+// clusterCenters returns a random center for each cluster, drawn from a
+// source seeded with the seed itself.
+func clusterCenters(opts Options) []Point {
+	r := rand.New(rand.NewSource(int64(opts.Seed)))
+	centers := make([]Point, opts.Clusters)
+	for i := range centers {
+		centers[i].X = r.Float64()*360 - 180 // Longitude between -180 and 180
+		centers[i].Y = r.Float64()*180 - 90  // Latitude between -90 and 90
+	}
+	return centers
+}
+
+// chunkPairs is the number of pairs generated as one unit of work.
+//
+// Chunk k of cluster idx draws from its own source seeded with
+// seed + idx + k*clusters, so the output depends on the seed and options but
+// not on the number of workers or the order they finish in. A cluster of at
+// most chunkPairs pairs is a single chunk seeded with seed + idx.
+const chunkPairs = 4096
+
+// pairJSONSize is the usual length of one pair and its separator in the
+// default format.
+// Chunk buffers are sized for it, so they only grow for the rare coordinate
+// with a long decimal expansion.
+const pairJSONSize = 2 + len(`{"X1": , "Y1": , "X2": , "Y2": }`) + 4*20
+
+// chunk is a run of pairs of one cluster and its formatted output. Chunks
+// are recycled, so a generation holds a fixed number of them however many
+// pairs it writes.
+type chunk struct {
+	seed   int64
+	pairs  int
+	sample pairSampler
+	format *Format
+	first  bool // The first chunk of the output, written without a separator.
+
+	data      []byte
+	distances []float64
+	done      chan struct{} // Receives once the chunk is filled.
+}
+
+func newChunk() *chunk {
+	return &chunk{
+		data:      make([]byte, 0, chunkPairs*pairJSONSize),
+		distances: make([]float64, 0, chunkPairs),
+		done:      make(chan struct{}, 1),
+	}
+}
+
+// fill generates the pairs of the chunk with r, reseeded for the chunk.
+func (c *chunk) fill(r *rand.Rand) {
+	r.Seed(c.seed)
+	c.data, c.distances = c.data[:0], c.distances[:0]
+	if c.format.SoA {
+		c.data = slices.Grow(c.data, 32*c.pairs)[:32*c.pairs]
+	}
+	for j := 0; j < c.pairs; j++ {
+		p1, p2 := c.sample(r)
+		c.distances = append(c.distances, Haversine(p1.Y, p1.X, p2.Y, p2.X))
+		if c.format.SoA {
+			putPairSoA(c.data, j, c.pairs, p1, p2)
+			continue
+		}
+		if j > 0 || !c.first {
+			c.data = append(c.data, c.format.separator...)
+		}
+		c.data = c.format.appendPair(c.data, p1, p2)
+	}
+}
+
+// generate generates the pairs of every cluster, clusterPairs(opts.Pairs,
+// opts.Clusters)[idx] of them with samplers[idx], and streams them to pairsOut
+// in opts.Format and their reference distances to binOut as an answer file,
+// in cluster order. It returns the total distance.
+//
+// A dispatcher hands chunks in output order to one worker per CPU and queues
+// them for the writer, which drains them in the same order. A chunk can only
+// be dispatched once the writer has returned a buffer, so at most 2 chunks
+// per worker are in memory and generation waits for a slow writer.
+func generate(pairsOut, binOut io.Writer, opts Options, samplers []pairSampler) (float64, error) {
+	enc, err := newEncoder(pairsOut, opts.Format, opts.Pairs)
+	if err != nil {
+		return 0, err
+	}
+	aw, err := answers.NewWriter(binOut, uint64(opts.Pairs))
+	if err != nil {
+		return 0, err
+	}
+	if err := enc.begin(); err != nil {
+		return 0, err
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	free := make(chan *chunk, 2*workers)
+	for i := 0; i < cap(free); i++ {
+		free <- newChunk()
+	}
+	// ordered never blocks: it has room for every buffer.
+	ordered := make(chan *chunk, cap(free))
+	jobs := make(chan *chunk)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(0))
+			for c := range jobs {
+				c.fill(r)
+				c.done <- struct{}{}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		first := true
+		for idx, count := range clusterPairs(opts.Pairs, opts.Clusters) {
+			for k := 0; k*chunkPairs < count; k++ {
+				var c *chunk
+				select {
+				case c = <-free:
+				case <-stop:
+					return
+				}
+				c.seed = int64(opts.Seed) + int64(idx) + int64(k)*int64(opts.Clusters)
+				c.pairs = min(chunkPairs, count-k*chunkPairs)
+				c.sample = samplers[idx]
+				c.format = opts.Format
+				c.first, first = first, false
+				ordered <- c
+				jobs <- c
+			}
+		}
+	}()
+
+	// After an error, keep draining so that the dispatcher and workers exit,
+	// but write nothing more.
+	var werr error
+	for c := range ordered {
+		<-c.done
+		if werr == nil {
+			werr = writeChunk(enc, aw, c)
+			opts.Histogram.add(c.distances...)
+			if werr != nil {
+				close(stop)
+			}
+		}
+		free <- c
+	}
+	wg.Wait()
+	if werr != nil {
+		return 0, werr
+	}
+
+	if err := enc.end(); err != nil {
+		return 0, err
+	}
+	return aw.Sum(), aw.Close()
+}
+
+func writeChunk(enc encoder, aw *answers.Writer, c *chunk) error {
+	if err := enc.write(c); err != nil {
+		return err
+	}
+	for _, dist := range c.distances {
+		if err := aw.Add(dist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
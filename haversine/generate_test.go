@@ -0,0 +1,214 @@
+package haversine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+type generator func(pairsOut, binOut io.Writer, opts Options) (float64, error)
+
+// outputHash generates seed and numPoints and returns the hash of the JSON
+// and binary output together.
+func outputHash(t *testing.T, generate generator, seed, numPoints int) [sha256.Size]byte {
+	t.Helper()
+	var pairsOut, binOut bytes.Buffer
+	if _, err := generate(&pairsOut, &binOut, DefaultOptions(seed, numPoints)); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(pairsOut.Bytes()) {
+		t.Fatalf("invalid JSON output:\n%.200s", pairsOut.String())
+	}
+	return sha256.Sum256(append(pairsOut.Bytes(), binOut.Bytes()...))
+}
+
+func TestOutputIsDeterministic(t *testing.T) {
+	generators := map[string]generator{"uniform": uniform, "clustered": clustered}
+	for name, generate := range generators {
+		want := outputHash(t, generate, 42, 10000)
+		for run := 0; run < 10; run++ {
+			// Vary the parallelism so that the goroutines finish in a
+			// different order.
+			prev := runtime.GOMAXPROCS(1 + run%4)
+			got := outputHash(t, generate, 42, 10000)
+			runtime.GOMAXPROCS(prev)
+			if got != want {
+				t.Fatalf("%s: run %d hashes to %x, want %x", name, run, got, want)
+			}
+		}
+		if outputHash(t, generate, 43, 10000) == want {
+			t.Errorf("%s: seeds 42 and 43 produce the same output", name)
+		}
+	}
+}
+
+// TestChunksAreDeterministic generates clusters of several chunks each, which
+// the workers fill out of order.
+func TestChunksAreDeterministic(t *testing.T) {
+	opts := DefaultOptions(9, 3*chunkPairs+17)
+	opts.Clusters = 2
+	hash := func() [sha256.Size]byte {
+		var pairsOut, binOut bytes.Buffer
+		if _, err := clustered(&pairsOut, &binOut, opts); err != nil {
+			t.Fatal(err)
+		}
+		return sha256.Sum256(append(pairsOut.Bytes(), binOut.Bytes()...))
+	}
+	want := hash()
+	for run := 0; run < 5; run++ {
+		prev := runtime.GOMAXPROCS(1 + run%4)
+		got := hash()
+		runtime.GOMAXPROCS(prev)
+		if got != want {
+			t.Fatalf("run %d hashes to %x, want %x", run, got, want)
+		}
+	}
+	pairs := generateChecked(t, clustered, opts)
+	if pairs[0] == pairs[chunkPairs] {
+		t.Error("the first two chunks of a cluster start with the same pair")
+	}
+}
+
+// failingWriter fails every write after the first.
+type failingWriter struct{ writes int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes++; w.writes > 1 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestWriteErrorStopsGeneration(t *testing.T) {
+	var binOut bytes.Buffer
+	_, err := uniform(&failingWriter{}, &binOut, DefaultOptions(1, 20*chunkPairs))
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("err = %v, want disk full", err)
+	}
+}
+
+// TestGenerationMemoryIsBounded streams many chunks to io.Discard: the
+// recycled chunk buffers, not the output, decide what generation allocates.
+func TestGenerationMemoryIsBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates 200 chunks")
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if _, err := uniform(io.Discard, io.Discard, DefaultOptions(5, 200*chunkPairs)); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	// The JSON alone is about 90 MB; the 8 chunk buffers take about 4 MB.
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("generating allocated %d bytes", alloc)
+	}
+	if inuse := after.HeapInuse; inuse > before.HeapInuse+16<<20 {
+		t.Errorf("heap in use grew from %d to %d bytes", before.HeapInuse, inuse)
+	}
+}
+
+func TestFewerPointsThanClusters(t *testing.T) {
+	for _, generate := range []generator{uniform, clustered} {
+		outputHash(t, generate, 1, 0)
+	}
+}
+
+// generateChecked generates pairs with opts, checks that the JSON and the
+// answer file agree on every pair and returns the pairs.
+func generateChecked(t *testing.T, generate generator, opts Options) []Pair {
+	t.Helper()
+	var pairsOut, binOut bytes.Buffer
+	sum, err := generate(&pairsOut, &binOut, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := answers.Read(&binOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct{ Pairs []Pair }
+	if err := json.Unmarshal(pairsOut.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Pairs) != opts.Pairs || len(f.Distances) != len(doc.Pairs) {
+		t.Fatalf("data.json holds %d pairs and data.bin %d, want %d", len(doc.Pairs), len(f.Distances), opts.Pairs)
+	}
+	for i, p := range doc.Pairs {
+		if d := Haversine(p.Y1, p.X1, p.Y2, p.X2); d != f.Distances[i] {
+			t.Fatalf("pair %d: distance %v, answer %v", i, d, f.Distances[i])
+		}
+	}
+	if opts.Pairs > 0 && f.Average != sum/float64(opts.Pairs) {
+		t.Errorf("average = %v, want %v", f.Average, sum/float64(opts.Pairs))
+	}
+	return doc.Pairs
+}
+
+func TestAnswersMatchPairs(t *testing.T) {
+	generateChecked(t, clustered, DefaultOptions(7, 3200))
+}
+
+func TestExactPairCount(t *testing.T) {
+	for _, pairs := range []int{0, 1, 31, 33, 1000} {
+		for _, clusters := range []int{1, 7, 32} {
+			opts := DefaultOptions(3, pairs)
+			opts.Clusters = clusters
+			generateChecked(t, uniform, opts)
+			generateChecked(t, clustered, opts)
+		}
+	}
+}
+
+func TestClusterPairs(t *testing.T) {
+	counts := clusterPairs(10, 4)
+	if len(counts) != 4 || counts[0] != 3 || counts[1] != 3 || counts[2] != 2 || counts[3] != 2 {
+		t.Errorf("clusterPairs(10, 4) = %v, want [3 3 2 2]", counts)
+	}
+}
+
+func TestClusterRadius(t *testing.T) {
+	opts := DefaultOptions(5, 500)
+	opts.Clusters, opts.Radius = 1, 2
+	pairs := generateChecked(t, clustered, opts)
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, p := range pairs {
+		minX, maxX = math.Min(minX, math.Min(p.X1, p.X2)), math.Max(maxX, math.Max(p.X1, p.X2))
+		minY, maxY = math.Min(minY, math.Min(p.Y1, p.Y2)), math.Max(maxY, math.Max(p.Y1, p.Y2))
+	}
+	if maxX-minX > 4 || maxY-minY > 4 {
+		t.Errorf("points span %g by %g degrees, want at most 4 by 4", maxX-minX, maxY-minY)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	bad := []Options{
+		{Pairs: -1, Clusters: 1, Radius: 1, Sigma: 1},
+		{Pairs: 1, Clusters: 0, Radius: 1, Sigma: 1},
+		{Pairs: 1, Clusters: 1, Radius: 0, Sigma: 1},
+		{Pairs: 1, Clusters: 1, Radius: 181, Sigma: 1},
+		{Pairs: 1, Clusters: 1, Radius: 1, Sigma: 0, Delta: 1},
+		{Pairs: 1, Clusters: 1, Radius: 1, Sigma: 1, Delta: -1},
+		{Pairs: 1, Clusters: 1, Radius: 1, Sigma: 1},
+	}
+	for _, opts := range bad {
+		if opts.Validate() == nil {
+			t.Errorf("%+v validates", opts)
+		}
+	}
+	if err := DefaultOptions(1, 10).Validate(); err != nil {
+		t.Error(err)
+	}
+}
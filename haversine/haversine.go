@@ -0,0 +1,56 @@
+// Package haversine is the reference implementation shared by the haversine
+// generator and processor: the distance formula, the point and pair types,
+// the generator of test inputs and, in package answers, the reference answer
+// files.
+//
+// Every program that computes or checks distances uses Haversine from here,
+// so that they agree to the last bit.
+package haversine
+
+import "math"
+
+// EarthRadius is the radius of the earth in kilometers.
+const EarthRadius = 6372.8 // km
+
+// Point represents a point in 2D space: X is the longitude and Y the latitude,
+// in degrees.
+type Point struct {
+	X, Y float64
+}
+
+// Pair is a pair of points as the generator writes and the processor reads
+// them.
+type Pair struct {
+	X1 float64 `json:"X1"`
+	Y1 float64 `json:"Y1"`
+	X2 float64 `json:"X2"`
+	Y2 float64 `json:"Y2"`
+}
+
+// Distance returns the reference distance between the points of the pair.
+func (p Pair) Distance() float64 {
+	return Haversine(p.Y1, p.X1, p.Y2, p.X2)
+}
+
+// Square returns the square of the input.
+func Square(x float64) float64 {
+	return x * x
+}
+
+// Radians converts degrees to radians. It multiplies by π before dividing by
+// 180, and a different order can change the last bit of the result.
+func Radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+// Haversine computes the great circle distance between two points on the Earth.
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := Radians(lat2 - lat1)
+	dLon := Radians(lon2 - lon1)
+	lat1 = Radians(lat1)
+	lat2 = Radians(lat2)
+
+	a := Square(math.Sin(dLat/2)) + math.Cos(lat1)*math.Cos(lat2)*Square(math.Sin(dLon/2))
+	c := 2 * math.Asin(math.Sqrt(a))
+	return EarthRadius * c
+}
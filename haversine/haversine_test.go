@@ -0,0 +1,89 @@
+package haversine
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// TestHaversineReferenceValues pins the reference distances to the bit. A
+// change here changes every answer file, so it must be deliberate.
+func TestHaversineReferenceValues(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		// The Nashville to Los Angeles example used to check haversine
+		// implementations with this radius.
+		{"BNA-LAX", 36.12, -86.67, 33.94, -118.40, 2887.2599506071106},
+		{"London-Paris", 51.5074, -0.1278, 48.8566, 2.3522, 343.6531253086471},
+		{"Sydney-New York", -33.8688, 151.2093, 40.7128, -74.006, 15993.272813571206},
+		{"half equator", 0, 0, 0, 180, 20020.741662797034},
+		{"pole to pole", 90, 0, -90, 0, 20020.741662797034},
+		{"identical", 10, 20, 10, 20, 0},
+		{"across the dateline near the pole", 89.9, -179.9, 89.9, 179.9, 0.038825278433516214},
+		{"nanodegree", 0, 0, 1e-9, 0, 1.1122634257109463e-07},
+	}
+	for _, tt := range tests {
+		if got := Haversine(tt.lat1, tt.lon1, tt.lat2, tt.lon2); got != tt.want {
+			t.Errorf("%s: Haversine = %v (%#x), want %v", tt.name, got, math.Float64bits(got), tt.want)
+		}
+	}
+	if half := math.Pi * EarthRadius; math.Abs(Haversine(0, 0, 0, 180)-half) > 1e-9 {
+		t.Errorf("half the equator is not π·r = %v", half)
+	}
+}
+
+func TestPairDistance(t *testing.T) {
+	p := Pair{X1: -86.67, Y1: 36.12, X2: -118.40, Y2: 33.94}
+	if got := p.Distance(); got != 2887.2599506071106 {
+		t.Errorf("Distance = %v, want the BNA-LAX distance", got)
+	}
+}
+
+// TestSquare pins Square to the correctly rounded square, which IEEE
+// multiplication guarantees on every platform, including values whose last
+// bit rounds up and a subnormal result.
+func TestSquare(t *testing.T) {
+	tests := []struct {
+		x    float64
+		want uint64 // Bits of the result.
+	}{
+		{0.1, 0x3f847ae147ae147c},
+		{1.0 / 3, 0x3fbc71c71c71c71c},
+		{0.8414709848078965, 0x3fe6a88995d4dc81}, // sin 1
+		{0.7071067811865476, 0x3fe0000000000001}, // √½ rounded up
+		{1e-160, 0x7e8},
+		{-2.5, 0x4019000000000000},
+	}
+	for _, tt := range tests {
+		if got := Square(tt.x); math.Float64bits(got) != tt.want {
+			t.Errorf("Square(%v) = %v (%#x), want %v", tt.x, got, math.Float64bits(got), math.Float64frombits(tt.want))
+		}
+	}
+
+	// Over random haversine terms, Square must equal the exact product
+	// rounded once, and stay within an ulp of the math.Pow(x, 2) earlier
+	// answer files were generated with, whose accuracy Go does not promise
+	// to the last bit.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		x := math.Sin(r.Float64() * 2 * math.Pi)
+		exact := new(big.Float).SetPrec(106).SetFloat64(x)
+		want, _ := exact.Mul(exact, exact).Float64()
+		if got := Square(x); got != want {
+			t.Fatalf("Square(%v) = %v, want %v", x, got, want)
+		}
+		if pow := math.Pow(x, 2); math.Abs(Square(x)-pow) > ulp(pow) {
+			t.Fatalf("Square(%v) = %v is more than an ulp from math.Pow = %v", x, Square(x), pow)
+		}
+	}
+}
+
+func TestRadians(t *testing.T) {
+	if Radians(180) != math.Pi || Radians(-90) != -math.Pi/2 || Radians(0) != 0 {
+		t.Errorf("Radians(180, -90, 0) = %v, %v, %v", Radians(180), Radians(-90), Radians(0))
+	}
+}
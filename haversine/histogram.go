@@ -0,0 +1,100 @@
+package haversine
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+)
+
+// histogramBuckets is the number of equal-width distance buckets between 0
+// and half the circumference of the earth.
+const histogramBuckets = 20
+
+// Histogram counts distances in equal-width buckets. Its methods do nothing
+// on a nil Histogram, so that generation need not check for one.
+type Histogram struct {
+	counts [histogramBuckets]int
+	total  int
+}
+
+// NewHistogram returns an empty histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// maxDistance is the longest haversine distance, between antipodes.
+const maxDistance = math.Pi * EarthRadius
+
+// bucketWidth is the width of a histogram bucket in km.
+const bucketWidth = maxDistance / histogramBuckets
+
+func (h *Histogram) add(distances ...float64) {
+	if h == nil {
+		return
+	}
+	for _, d := range distances {
+		b := int(d / bucketWidth)
+		// Distances of exactly, or by rounding just over, maxDistance go in
+		// the last bucket.
+		b = max(0, min(b, histogramBuckets-1))
+		h.counts[b]++
+		h.total++
+	}
+}
+
+// share returns the fraction of the distances in bucket b.
+func (h *Histogram) share(b int) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return float64(h.counts[b]) / float64(h.total)
+}
+
+// CompareHistograms prints the histogram collected while generating dist
+// next to those of the uniform and sphere distributions for the same
+// options, generated again without output. The comparison shows how the
+// input distribution shifts the distances, and with them the inputs the
+// math functions see.
+func CompareHistograms(w io.Writer, dist Distribution, opts Options) error {
+	names := []SampleType{dist.Name}
+	hists := []*Histogram{opts.Histogram}
+	for _, name := range []SampleType{Uniform, Sphere} {
+		if name == dist.Name {
+			continue
+		}
+		other, _ := LookupDistribution(name)
+		// Only the distances matter, and the binary format cannot write to
+		// io.Discard.
+		o := opts
+		o.Histogram = NewHistogram()
+		o.Format, _ = LookupFormat(JSONFormat)
+		if _, err := other.Generate(io.Discard, io.Discard, o); err != nil {
+			return err
+		}
+		names = append(names, name)
+		hists = append(hists, o.Histogram)
+	}
+	writeHistograms(w, names, hists)
+	return nil
+}
+
+// writeHistograms prints one row per bucket with the share of every
+// histogram in it.
+func writeHistograms(w io.Writer, names []SampleType, hists []*Histogram) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "km\t")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t", name)
+	}
+	fmt.Fprintln(tw)
+	for b := 0; b < histogramBuckets; b++ {
+		fmt.Fprintf(tw, "%.0f-%.0f\t", float64(b)*bucketWidth, float64(b+1)*bucketWidth)
+		for _, h := range hists {
+			fmt.Fprintf(tw, "%5.2f%% %-20s\t", 100*h.share(b), strings.Repeat("#", int(math.Round(100*h.share(b)))))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,52 @@
+package haversine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	h.add(0, bucketWidth/2, bucketWidth, maxDistance, maxDistance*1.0000001)
+	if h.total != 5 || h.counts[0] != 2 || h.counts[1] != 1 || h.counts[histogramBuckets-1] != 2 {
+		t.Errorf("counts = %v, total %d", h.counts, h.total)
+	}
+	if got := h.share(0); got != 0.4 {
+		t.Errorf("share(0) = %g, want 0.4", got)
+	}
+
+	// A nil histogram ignores distances.
+	var none *Histogram
+	none.add(1, 2, 3)
+}
+
+func TestCompareHistograms(t *testing.T) {
+	opts := DefaultOptions(8, 5000)
+	opts.Histogram = NewHistogram()
+	dist, _ := LookupDistribution(Polar)
+	var pairsOut, binOut bytes.Buffer
+	if _, err := dist.Generate(&pairsOut, &binOut, opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Histogram.total != 5000 {
+		t.Fatalf("histogram holds %d distances, want 5000", opts.Histogram.total)
+	}
+	// Polar points are at most 10 degrees of arc apart.
+	if opts.Histogram.share(0)+opts.Histogram.share(1) != 1 {
+		t.Errorf("polar distances outside the first two buckets: %v", opts.Histogram.counts)
+	}
+
+	var out strings.Builder
+	if err := CompareHistograms(&out, dist, opts); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != histogramBuckets+1 {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), histogramBuckets+1, out.String())
+	}
+	header := strings.Fields(lines[0])
+	if len(header) != 4 || header[1] != "polar" || header[2] != "uniform" || header[3] != "sphere" {
+		t.Errorf("header = %q", lines[0])
+	}
+}
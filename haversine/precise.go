@@ -0,0 +1,246 @@
+package haversine
+
+import (
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+)
+
+// guardBits is the extra precision Precise works with, so that the rounding
+// errors of its series and reductions stay below the requested precision.
+const guardBits = 32
+
+// Precise evaluates the haversine formula in big.Float arithmetic, as ground
+// truth for the float64 Haversine and its approximations.
+//
+// It computes the exact function of its float64 inputs and of the float64
+// EarthRadius: coordinate differences are taken exactly and every operation,
+// including its own sine, cosine, arcsine and square root, is carried out at
+// the requested precision plus guard bits. The methods of a Precise may be
+// called concurrently.
+type Precise struct {
+	prec   uint       // Working precision in bits, guard bits included.
+	pi     *big.Float // π at the working precision.
+	radius *big.Float
+}
+
+// NewPrecise returns a Precise that evaluates distances to prec bits.
+func NewPrecise(prec uint) *Precise {
+	p := &Precise{prec: prec + guardBits}
+	p.pi = p.computePi()
+	p.radius = p.float(EarthRadius)
+	return p
+}
+
+// Prec returns the precision the distances are evaluated to.
+func (p *Precise) Prec() uint {
+	return p.prec - guardBits
+}
+
+// Haversine returns the distance between the points at the precision of p.
+func (p *Precise) Haversine(lat1, lon1, lat2, lon2 float64) *big.Float {
+	dLat := p.radians(p.sub(p.float(lat2), p.float(lat1)))
+	dLon := p.radians(p.sub(p.float(lon2), p.float(lon1)))
+	rLat1 := p.radians(p.float(lat1))
+	rLat2 := p.radians(p.float(lat2))
+
+	sLat := p.sin(p.half(dLat))
+	sLon := p.sin(p.half(dLon))
+	a := p.add(p.mul(sLat, sLat), p.mul(p.mul(p.cos(rLat1), p.cos(rLat2)), p.mul(sLon, sLon)))
+	// Rounding can take a just past 1 for antipodal points.
+	if a.Cmp(p.float(1)) > 0 {
+		a = p.float(1)
+	}
+	c := p.mul(p.float(2), p.asin(p.sqrt(a)))
+	return p.mul(p.radius, c)
+}
+
+// Distances returns the precise distance of every pair, spreading the work
+// over every CPU.
+func (p *Precise) Distances(pairs []Pair) []*big.Float {
+	refs := make([]*big.Float, len(pairs))
+	workers := runtime.GOMAXPROCS(0)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(pairs); i += workers {
+				pair := pairs[i]
+				refs[i] = p.Haversine(pair.Y1, pair.X1, pair.Y2, pair.X2)
+			}
+		}(w)
+	}
+	wg.Wait()
+	return refs
+}
+
+func (p *Precise) float(x float64) *big.Float {
+	return new(big.Float).SetPrec(p.prec).SetFloat64(x)
+}
+
+func (p *Precise) add(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(p.prec).Add(x, y)
+}
+
+func (p *Precise) sub(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(p.prec).Sub(x, y)
+}
+
+func (p *Precise) mul(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(p.prec).Mul(x, y)
+}
+
+func (p *Precise) quo(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(p.prec).Quo(x, y)
+}
+
+// half returns x / 2, which is exact.
+func (p *Precise) half(x *big.Float) *big.Float {
+	return new(big.Float).SetPrec(p.prec).SetMantExp(x, -1)
+}
+
+func (p *Precise) radians(d *big.Float) *big.Float {
+	return p.quo(p.mul(d, p.pi), p.float(180))
+}
+
+// negligible reports whether term no longer changes sum at the working
+// precision.
+func (p *Precise) negligible(term, sum *big.Float) bool {
+	return term.Sign() == 0 || (sum.Sign() != 0 && term.MantExp(nil) < sum.MantExp(nil)-int(p.prec))
+}
+
+// computePi computes π with Machin's formula, 16·atan(1/5) − 4·atan(1/239).
+func (p *Precise) computePi() *big.Float {
+	a := p.atanSeries(p.quo(p.float(1), p.float(5)))
+	b := p.atanSeries(p.quo(p.float(1), p.float(239)))
+	return p.sub(p.mul(p.float(16), a), p.mul(p.float(4), b))
+}
+
+// atanSeries sums x − x³/3 + x⁵/5 − …, which converges quickly for small |x|.
+func (p *Precise) atanSeries(x *big.Float) *big.Float {
+	x2 := p.mul(x, x)
+	sum := p.float(0).Set(x)
+	pow := p.float(0).Set(x)
+	for k := 1; ; k++ {
+		pow = p.mul(pow, x2)
+		pow.Neg(pow)
+		term := p.quo(pow, p.float(float64(2*k+1)))
+		if p.negligible(term, sum) {
+			return sum
+		}
+		sum = p.add(sum, term)
+	}
+}
+
+// atan halves the angle with atan(x) = 2·atan(x / (1 + √(1 + x²))) until
+// |x| < 1/8, then sums the series.
+func (p *Precise) atan(x *big.Float) *big.Float {
+	doublings := 0
+	limit := p.float(0.125)
+	for new(big.Float).Abs(x).Cmp(limit) >= 0 {
+		x = p.quo(x, p.add(p.float(1), p.sqrt(p.add(p.float(1), p.mul(x, x)))))
+		doublings++
+	}
+	sum := p.atanSeries(x)
+	return sum.SetMantExp(sum, doublings)
+}
+
+// asin returns asin(x) = atan(x / √(1 − x²)) for x in [-1, 1].
+func (p *Precise) asin(x *big.Float) *big.Float {
+	one := p.float(1)
+	if abs := new(big.Float).Abs(x); abs.Cmp(one) >= 0 {
+		halfPi := p.half(p.pi)
+		if x.Sign() < 0 {
+			halfPi.Neg(halfPi)
+		}
+		return halfPi
+	}
+	return p.atan(p.quo(x, p.sqrt(p.sub(one, p.mul(x, x)))))
+}
+
+// sinSeries sums x − x³/3! + x⁵/5! − … for |x| ≤ π/4.
+func (p *Precise) sinSeries(x *big.Float) *big.Float {
+	x2 := p.mul(x, x)
+	sum := p.float(0).Set(x)
+	term := p.float(0).Set(x)
+	for k := 1; ; k++ {
+		term = p.quo(p.mul(term, x2), p.float(float64((2*k)*(2*k+1))))
+		term.Neg(term)
+		if p.negligible(term, sum) {
+			return sum
+		}
+		sum = p.add(sum, term)
+	}
+}
+
+// cosSeries sums 1 − x²/2! + x⁴/4! − … for |x| ≤ π/4.
+func (p *Precise) cosSeries(x *big.Float) *big.Float {
+	x2 := p.mul(x, x)
+	sum := p.float(1)
+	term := p.float(1)
+	for k := 1; ; k++ {
+		term = p.quo(p.mul(term, x2), p.float(float64((2*k-1)*(2*k))))
+		term.Neg(term)
+		if p.negligible(term, sum) {
+			return sum
+		}
+		sum = p.add(sum, term)
+	}
+}
+
+// reduce returns r and the quadrant q such that x = q·π/2 + r, |r| ≤ π/4.
+func (p *Precise) reduce(x *big.Float) (*big.Float, int) {
+	halfPi := p.half(p.pi)
+	k, _ := p.quo(x, halfPi).Float64()
+	q := math.Round(k)
+	r := p.sub(x, p.mul(p.float(q), halfPi))
+	return r, int(q) & 3
+}
+
+func (p *Precise) sin(x *big.Float) *big.Float {
+	r, q := p.reduce(x)
+	return p.quadrant(r, q)
+}
+
+func (p *Precise) cos(x *big.Float) *big.Float {
+	r, q := p.reduce(x)
+	return p.quadrant(r, (q+1)&3)
+}
+
+// quadrant returns sin(q·π/2 + r).
+func (p *Precise) quadrant(r *big.Float, q int) *big.Float {
+	var v *big.Float
+	if q&1 == 0 {
+		v = p.sinSeries(r)
+	} else {
+		v = p.cosSeries(r)
+	}
+	if q >= 2 {
+		v.Neg(v)
+	}
+	return v
+}
+
+// sqrt returns √x for x ≥ 0 with Newton's method, starting from the float64
+// square root and doubling the correct bits with every step.
+func (p *Precise) sqrt(x *big.Float) *big.Float {
+	if x.Sign() == 0 {
+		return p.float(0)
+	}
+	// Take the float64 root of the mantissa, so that any exponent works.
+	mant := new(big.Float)
+	exp := x.MantExp(mant)
+	if exp%2 != 0 {
+		mant.SetMantExp(mant, 1)
+		exp--
+	}
+	m, _ := mant.Float64()
+	y := p.float(math.Sqrt(m))
+	y.SetMantExp(y, exp/2)
+	for correct := 50; correct < 2*int(p.prec); correct *= 2 {
+		y = p.half(p.add(y, p.quo(x, y)))
+	}
+	return y
+}
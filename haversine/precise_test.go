@@ -0,0 +1,130 @@
+package haversine
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestPrecisePi(t *testing.T) {
+	const digits = "3.14159265358979323846264338327950288419716939937510582097494459230781640628620899862803482534211706798214808651"
+	want, _, err := big.ParseFloat(digits, 10, 300, big.ToNearestEven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPrecise(256)
+	diff := new(big.Float).Sub(p.pi, want)
+	if diff.Sign() != 0 && diff.MantExp(nil) > -256 {
+		t.Errorf("π = %s, off by %s", p.pi.Text('g', 80), diff.Text('g', 5))
+	}
+}
+
+// TestPreciseFunctions checks the series against the float64 math functions,
+// which are accurate to about one ulp.
+func TestPreciseFunctions(t *testing.T) {
+	p := NewPrecise(128)
+	r := rand.New(rand.NewSource(1))
+	near := func(name string, x float64, got *big.Float, want float64) {
+		t.Helper()
+		g, _ := got.Float64()
+		if math.Abs(g-want) > 2*ulp(want) {
+			t.Errorf("%s(%v) = %v, math gives %v", name, x, g, want)
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		x := (r.Float64()*2 - 1) * 2 * math.Pi
+		near("sin", x, p.sin(p.float(x)), math.Sin(x))
+		near("cos", x, p.cos(p.float(x)), math.Cos(x))
+		// math.Asin loses a few bits close to ±1, so compare it only on
+		// the middle of the range and check the rest through the sine.
+		u := r.Float64()*2 - 1
+		if math.Abs(u) < 0.5 {
+			near("asin", u, p.asin(p.float(u)), math.Asin(u))
+		}
+		if s, _ := p.sin(p.asin(p.float(u))).Float64(); s != u {
+			t.Errorf("sin(asin(%v)) = %v", u, s)
+		}
+		near("sqrt", math.Abs(x), p.sqrt(p.float(math.Abs(x))), math.Sqrt(math.Abs(x)))
+	}
+	for _, x := range []float64{0, 1, -1, 0.25} {
+		near("asin", x, p.asin(p.float(x)), math.Asin(x))
+	}
+	for _, x := range []float64{0, 1e-300, 0.25, 2, 4, 1e300} {
+		near("sqrt", x, p.sqrt(p.float(x)), math.Sqrt(x))
+	}
+}
+
+// TestPreciseConverges checks that distances at two precisions agree to the
+// lower one, so the series and reductions do not lose bits.
+func TestPreciseConverges(t *testing.T) {
+	low, high := NewPrecise(128), NewPrecise(256)
+	for _, c := range [][4]float64{
+		{36.12, -86.67, 33.94, -118.40},
+		{0, 0, 0, 180},
+		{87.2604283075207, -4.051064203786808, -87.26043124598021, 175.94886304828037},
+		{-88.17150231771576, -85.32933355582378, -88.17150606708796, -85.32866407061627},
+		{10, 20, 10, 20},
+	} {
+		a, b := low.Haversine(c[0], c[1], c[2], c[3]), high.Haversine(c[0], c[1], c[2], c[3])
+		diff := new(big.Float).Sub(a, b)
+		if diff.Sign() != 0 && diff.MantExp(nil) > b.MantExp(nil)-126 {
+			t.Errorf("%v: %s at 128 bits, %s at 256", c, a.Text('g', 45), b.Text('g', 45))
+		}
+	}
+	const bnaLax = "2887.259950607110861926086128976116820809"
+	if got := high.Haversine(36.12, -86.67, 33.94, -118.40).Text('f', 36); got != bnaLax {
+		t.Errorf("BNA-LAX = %s, want %s", got, bnaLax)
+	}
+	if got := high.Prec(); got != 256 {
+		t.Errorf("Prec = %d, want 256", got)
+	}
+}
+
+func TestMeasureError(t *testing.T) {
+	pairs := []Pair{
+		{X1: -86.67, Y1: 36.12, X2: -118.40, Y2: 33.94},
+		{X1: 0, Y1: 0, X2: 180, Y2: 0},
+		{X1: 1, Y1: 2, X2: 3, Y2: 4},
+	}
+	refs := NewPrecise(128).Distances(pairs)
+
+	exact := MeasureError(pairs, refs, func(lat1, lon1, lat2, lon2 float64) float64 {
+		for i, p := range pairs {
+			if p.Y1 == lat1 && p.X1 == lon1 {
+				d, _ := refs[i].Float64()
+				return d
+			}
+		}
+		return math.NaN()
+	}, 2)
+	if exact.Rounded != 3 || exact.MaxULP > 0.5 || len(exact.Worst) != 2 {
+		t.Errorf("correctly rounded candidate: %+v", exact)
+	}
+
+	// A candidate one km off has its worst error on the shortest distance.
+	off := MeasureError(pairs, refs, func(lat1, lon1, lat2, lon2 float64) float64 {
+		return Haversine(lat1, lon1, lat2, lon2) + 1
+	}, 1)
+	if off.Rounded != 0 || math.Abs(off.MaxAbs-1) > 1e-9 || math.Abs(off.MeanAbs-1) > 1e-9 {
+		t.Errorf("candidate 1 km off: %+v", off)
+	}
+	if len(off.Worst) != 1 || off.Worst[0].Index != 2 || off.Worst[0].ULP != off.MaxULP {
+		t.Errorf("worst pairs = %+v", off.Worst)
+	}
+
+	nan := MeasureError(pairs[:1], refs[:1], func(float64, float64, float64, float64) float64 { return math.NaN() }, 1)
+	if !math.IsInf(nan.MaxULP, 1) {
+		t.Errorf("NaN candidate: max ulp %v", nan.MaxULP)
+	}
+}
+
+func TestKeepWorst(t *testing.T) {
+	var worst []PairError
+	for i, u := range []float64{3, 1, 4, 1, 5, 9, 2, 6} {
+		worst = keepWorst(worst, PairError{Index: i, ULP: u}, 3)
+	}
+	if len(worst) != 3 || worst[0].ULP != 9 || worst[1].ULP != 6 || worst[2].ULP != 5 {
+		t.Errorf("worst = %+v", worst)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// pcClockMHz is the clock of the IBM PC's 8088, the usual yardstick.
+const pcClockMHz = 4.77
+
+// benchResult totals the runs of a benchmark.
+type benchResult struct {
+	runs          int
+	steps         uint64 // Instructions executed over all runs.
+	clocks        uint64 // Simulated clocks over all runs.
+	elapsed       time.Duration
+	invalidations uint64 // Cached instructions discarded by self-modifying code.
+}
+
+// benchmark runs c without logging until it halts or executes maxSteps
+// instructions, restarting it from its initial state until minDuration has
+// passed. Only the simulation is timed, not the restarts, which dominate
+// for short programs. c is left in its state after the last run.
+func benchmark(c *cpu, maxSteps uint64, minDuration time.Duration) (benchResult, error) {
+	var initial bytes.Buffer
+	if err := writeSnapshot(&initial, c); err != nil {
+		return benchResult{}, err
+	}
+	model := c.model
+
+	var res benchResult
+	for begin := time.Now(); res.runs == 0 || time.Since(begin) < minDuration; {
+		run, err := readSnapshot(bytes.NewReader(initial.Bytes()))
+		if err != nil {
+			return res, err
+		}
+		run.model = model
+		startSteps, startClocks := run.steps, run.clocks
+
+		start := time.Now()
+		for !run.halted() && (maxSteps == 0 || run.steps < maxSteps) {
+			if _, err := run.step(); err != nil {
+				return res, err
+			}
+		}
+		res.elapsed += time.Since(start)
+
+		res.runs++
+		res.steps += run.steps - startSteps
+		res.clocks += run.clocks - startClocks
+		res.invalidations += run.decoded.invalidations
+		*c = *run
+	}
+	return res, nil
+}
+
+// write prints the throughput of the benchmark.
+func (r benchResult) write(out io.Writer) {
+	seconds := r.elapsed.Seconds()
+	fmt.Fprintf(out, "\nBenchmark: %d runs, %d instructions, %d clocks in %v\n", r.runs, r.steps, r.clocks, r.elapsed.Round(time.Microsecond))
+	if seconds == 0 {
+		return
+	}
+	mhz := float64(r.clocks) / seconds / 1e6
+	fmt.Fprintf(out, "  %.2f million instructions/s\n", float64(r.steps)/seconds/1e6)
+	fmt.Fprintf(out, "  %.2f simulated MHz, %.1fx a %.2f MHz 8088\n", mhz, mhz/pcClockMHz, pcClockMHz)
+	if r.invalidations != 0 {
+		fmt.Fprintf(out, "  %d cached instructions invalidated by self-modifying code\n", r.invalidations)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+// decodePageSize is the number of addresses covered by one decodePage.
+const decodePageSize = 256
+
+// maxInstructionSize is the longest encoding the decoder accepts, such as an
+// immediate word stored through a 16 bit displacement.
+const maxInstructionSize = 6
+
+// cachedInstruction is a decoded instruction and the cache generation it
+// was decoded in.
+type cachedInstruction struct {
+	decodedInstruction
+	generation uint32
+}
+
+// decodePage holds the instructions decoded at each address of a page.
+type decodePage [decodePageSize]cachedInstruction
+
+// decodeCache holds decoded instructions by linear address so that loops are
+// decoded once rather than on every iteration. Pages are only allocated once
+// code in them runs. Writes to memory invalidate every cached instruction
+// that overlaps the written byte, so self-modifying code executes what it
+// wrote.
+type decodeCache struct {
+	model cpuModel // Instruction set the cached instructions were decoded for.
+	pages [memorySize / decodePageSize]*decodePage
+
+	// generation marks the entries that are current. Resetting the cache
+	// moves to a new generation instead of clearing the pages, and 0 marks
+	// a cache that has never been reset and so holds nothing.
+	generation uint32
+
+	// invalidations counts cached instructions discarded by memory writes,
+	// each one an instance of code modifying itself.
+	invalidations uint64
+}
+
+// lookup returns the instruction cached at addr, if any.
+func (dc *decodeCache) lookup(addr uint32) (decodedInstruction, bool) {
+	p := dc.pages[addr/decodePageSize]
+	if p == nil {
+		return decodedInstruction{}, false
+	}
+	e := &p[addr%decodePageSize]
+	return e.decodedInstruction, e.generation == dc.generation
+}
+
+// store caches d as the instruction at addr.
+func (dc *decodeCache) store(addr uint32, d decodedInstruction) {
+	p := dc.pages[addr/decodePageSize]
+	if p == nil {
+		p = new(decodePage)
+		dc.pages[addr/decodePageSize] = p
+	}
+	p[addr%decodePageSize] = cachedInstruction{d, dc.generation}
+}
+
+// reset empties the cache and readies it for model.
+func (dc *decodeCache) reset(model cpuModel) {
+	dc.generation++
+	if dc.generation == 0 {
+		// Wrapped around: entries from the first generation would look current.
+		*dc = decodeCache{generation: 1}
+	}
+	dc.model = model
+	dc.invalidations = 0
+}
+
+// invalidate discards the cached instructions whose bytes include addr.
+func (dc *decodeCache) invalidate(addr uint32) {
+	start := uint32(0)
+	if addr >= maxInstructionSize-1 {
+		start = addr - (maxInstructionSize - 1)
+	}
+	for a := start; a <= addr; a++ {
+		p := dc.pages[a/decodePageSize]
+		if p == nil {
+			continue
+		}
+		if e := &p[a%decodePageSize]; e.generation == dc.generation && a+uint32(e.size) > addr {
+			e.generation = 0
+			dc.invalidations++
+		}
+	}
+}
+
+// decode returns the instruction at addr, decoding and caching it on first
+// use.
+func (c *cpu) decode(addr uint32) (decodedInstruction, error) {
+	if c.decoded.generation == 0 || c.decoded.model != c.model {
+		c.decoded.reset(c.model)
+	}
+	if d, ok := c.decoded.lookup(addr); ok {
+		return d, nil
+	}
+	d, err := decodeAt(c.mem, int(addr), c.model)
+	if err != nil {
+		return d, err
+	}
+	c.decoded.store(addr, d)
+	return d, nil
+}
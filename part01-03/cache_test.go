@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelfModifyingCode(t *testing.T) {
+	program := []byte{
+		0xb9, 0x02, 0x00, // mov cx, 2
+		0xb8, 0x01, 0x00, // mov ax, 1
+		0x01, 0xc3, // add bx, ax
+		0xc6, 0x06, 0x04, 0x00, 0x05, // mov [4], byte 5
+		0xe2, 0xf4, // loop $+2-12
+	}
+
+	c := newCPU()
+	c.load(program)
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second pass must run mov ax, 5, not the cached mov ax, 1.
+	if got := c.regs[regBX]; got != 6 {
+		t.Errorf("bx = %d, want 6", got)
+	}
+	if got := c.decoded.invalidations; got != 2 {
+		t.Errorf("invalidations = %d, want 2", got)
+	}
+}
+
+func TestBenchmarkRestartsFromInitialState(t *testing.T) {
+	c := newCPU()
+	c.load(loopProgram)
+	res, err := benchmark(c, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.runs == 0 || res.steps != uint64(res.runs)*loopProgramSteps {
+		t.Errorf("%d runs executed %d instructions, want %d per run", res.runs, res.steps, loopProgramSteps)
+	}
+	if c.regs[regCX] != 0 || !c.halted() {
+		t.Errorf("cpu not left in its final state: cx = %d", c.regs[regCX])
+	}
+}
+
+// loopProgram runs a five instruction loop 65536 times.
+var loopProgram = []byte{
+	0xb9, 0x00, 0x00, // mov cx, 0
+	0xbb, 0x00, 0x10, // mov bx, 4096
+	0x01, 0xd8, // add ax, bx
+	0x89, 0x40, 0x04, // mov [bx + si + 4], ax
+	0x2b, 0x50, 0x04, // sub dx, [bx + si + 4]
+	0x83, 0xf8, 0x07, // cmp ax, 7
+	0xe2, 0xf3, // loop $+2-13
+}
+
+const loopProgramSteps = 2 + 5*65536
+
+// BenchmarkSimulate reports the simulator's throughput on a loop, the case
+// the decode cache is for.
+func BenchmarkSimulate(b *testing.B) {
+	c := newCPU()
+	c.load(loopProgram)
+	var clocks uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c.halted() {
+			clocks += c.clocks
+			c.load(loopProgram)
+			c.clocks = 0
+		}
+		if _, err := c.step(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	clocks += c.clocks
+	seconds := b.Elapsed().Seconds()
+	b.ReportMetric(float64(b.N)/seconds/1e6, "Minstr/s")
+	b.ReportMetric(float64(clocks)/seconds/1e6, "MHz")
+}
+
+// BenchmarkDecode reports the cost of decoding without the cache.
+func BenchmarkDecode(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeAt(loopProgram, 8, cpu8086); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Edge kinds of the control-flow graph.
+const (
+	edgeTaken       = "taken"
+	edgeNotTaken    = "not taken"
+	edgeJump        = "jump"
+	edgeCall        = "call"
+	edgeFallthrough = "fallthrough"
+)
+
+// cfgInstruction is an instruction of a basic block as exported in JSON.
+type cfgInstruction struct {
+	Addr   int    `json:"addr"`
+	Bytes  string `json:"bytes"`
+	Text   string `json:"text"`
+	Clocks int    `json:"clocks"`
+}
+
+// basicBlock is a straight-line run of instructions with a single entry at
+// its first instruction and transfers only at its last.
+//
+// Clocks is the estimated cost of the block when its final transfer is taken
+// (or for blocks that do not end in a conditional transfer). NotTakenClocks
+// is only set for blocks ending in a conditional transfer. Neither includes
+// odd-address penalties, which depend on runtime addresses.
+type basicBlock struct {
+	Name           string           `json:"name"`
+	Start          int              `json:"start"`
+	End            int              `json:"end"` // Offset one past the last byte.
+	Instructions   []cfgInstruction `json:"instructions"`
+	Clocks         int              `json:"clocks"`
+	NotTakenClocks int              `json:"not_taken_clocks,omitempty"`
+
+	instrs []decodedInstruction
+}
+
+// cfgEdge is a directed edge between two basic blocks.
+type cfgEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// unresolvedTarget is a transfer target that starts no basic block: it falls
+// inside another instruction, past the end of the image or on bytes that do
+// not decode. Edges to it end at a node of its name.
+type unresolvedTarget struct {
+	Name   string `json:"name"`
+	Addr   int    `json:"addr"`
+	Reason string `json:"reason"`
+}
+
+// controlFlowGraph is the set of basic blocks reachable from the entry points.
+type controlFlowGraph struct {
+	Entries    []int              `json:"entries"`
+	Blocks     []*basicBlock      `json:"blocks"`
+	Edges      []cfgEdge          `json:"edges"`
+	Unresolved []unresolvedTarget `json:"unresolved,omitempty"`
+
+	byStart    map[int]*basicBlock
+	unresolved map[int]bool
+	cm         *codeMap
+}
+
+// blockName returns the name of the block starting at off.
+func blockName(off int) string {
+	return fmt.Sprintf("block_%04x", off)
+}
+
+// unresolvedName returns the name of the node for the unresolved target off.
+func unresolvedName(off int) string {
+	return fmt.Sprintf("unresolved_%04x", off)
+}
+
+// isConditional reports whether instr is a conditional transfer.
+func isConditional(instr instruction) bool {
+	_, ok := instr.(*jumpOrLoop)
+	return ok
+}
+
+// buildCFG splits the code reachable from entries into basic blocks and
+// connects them.
+func buildCFG(b []byte, entries []int, model cpuModel) *controlFlowGraph {
+	cm := traverse(b, entries, model, nil)
+	offsets := cm.offsets()
+
+	// Leaders start a block: entry points, transfer targets, instructions
+	// following a transfer and instructions not contiguous with the previous one.
+	leaders := make(map[int]bool)
+	for _, e := range entries {
+		leaders[e] = true
+	}
+	for t := range cm.targets {
+		leaders[t] = true
+	}
+	for i, off := range offsets {
+		d := cm.instrs[off]
+		if i == 0 || offsets[i-1]+cm.instrs[offsets[i-1]].size != off {
+			leaders[off] = true
+		}
+		if targets, fallsThrough := controlFlow(d.instr, uint16(d.next())); len(targets) > 0 || !fallsThrough {
+			leaders[d.next()] = true
+		}
+	}
+
+	g := &controlFlowGraph{Entries: entries, byStart: make(map[int]*basicBlock), unresolved: make(map[int]bool), cm: cm}
+	var cur *basicBlock
+	for _, off := range offsets {
+		if cur == nil || leaders[off] {
+			cur = &basicBlock{Name: blockName(off), Start: off}
+			g.Blocks = append(g.Blocks, cur)
+			g.byStart[off] = cur
+		}
+		d := cm.instrs[off]
+		cur.instrs = append(cur.instrs, d)
+		cur.End = d.next()
+	}
+
+	labels := make(map[int]string, len(g.Blocks))
+	for _, blk := range g.Blocks {
+		labels[blk.Start] = blk.Name
+	}
+	for _, blk := range g.Blocks {
+		for i, d := range blk.instrs {
+			clocks := instructionClocks(d.instr, true)
+			blk.Instructions = append(blk.Instructions, cfgInstruction{
+				Addr:   d.offset,
+				Bytes:  hex.EncodeToString(b[d.offset:d.next()]),
+				Text:   cm.text(nasm, d, labels),
+				Clocks: clocks,
+			})
+			blk.Clocks += clocks
+			if i == len(blk.instrs)-1 && isConditional(d.instr) {
+				blk.NotTakenClocks = blk.Clocks - clocks + instructionClocks(d.instr, false)
+			}
+		}
+		g.Edges = append(g.Edges, g.blockEdges(blk)...)
+	}
+	return g
+}
+
+// blockEdges returns the outgoing edges of blk.
+func (g *controlFlowGraph) blockEdges(blk *basicBlock) []cfgEdge {
+	last := blk.instrs[len(blk.instrs)-1]
+	targets, fallsThrough := controlFlow(last.instr, uint16(last.next()))
+
+	var edges []cfgEdge
+	add := func(to int, kind string) {
+		if dst, ok := g.byStart[to]; ok {
+			edges = append(edges, cfgEdge{From: blk.Name, To: dst.Name, Kind: kind})
+			return
+		}
+		edges = append(edges, cfgEdge{From: blk.Name, To: g.unresolvedTarget(to), Kind: kind})
+	}
+
+	for _, t := range targets {
+		switch in := last.instr.(type) {
+		case *branch:
+			if in.op == opCallNear {
+				add(int(t), edgeCall)
+			} else {
+				add(int(t), edgeJump)
+			}
+		default:
+			add(int(t), edgeTaken)
+		}
+	}
+	if fallsThrough {
+		if isConditional(last.instr) {
+			add(last.next(), edgeNotTaken)
+		} else {
+			add(last.next(), edgeFallthrough)
+		}
+	}
+	return edges
+}
+
+// unresolvedTarget records off, which starts no block, as an unresolved
+// target and returns the name of its node.
+func (g *controlFlowGraph) unresolvedTarget(off int) string {
+	name := unresolvedName(off)
+	if g.unresolved[off] {
+		return name
+	}
+	g.unresolved[off] = true
+
+	reason := "does not decode"
+	switch {
+	case off >= len(g.cm.image):
+		reason = "past the end of the image"
+	case g.cm.covered[off]:
+		for start, d := range g.cm.instrs {
+			if start < off && off < d.next() {
+				reason = fmt.Sprintf("inside the instruction at %04x", start)
+				break
+			}
+		}
+	}
+	g.Unresolved = append(g.Unresolved, unresolvedTarget{Name: name, Addr: off, Reason: reason})
+	return name
+}
+
+// writeDOT writes the graph in Graphviz DOT format.
+func (g *controlFlowGraph) writeDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph cfg {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, blk := range g.Blocks {
+		header := fmt.Sprintf("%s  clocks: %d", blk.Name, blk.Clocks)
+		if blk.NotTakenClocks != 0 {
+			header = fmt.Sprintf("%s  clocks: %d taken / %d not taken", blk.Name, blk.Clocks, blk.NotTakenClocks)
+		}
+		label := dotEscape(header) + `\l`
+		for _, in := range blk.Instructions {
+			label += dotEscape(fmt.Sprintf("%04x  %s", in.Addr, in.Text)) + `\l`
+		}
+		fmt.Fprintf(&sb, "\t%s [label=\"%s\"];\n", blk.Name, label)
+	}
+	for _, u := range g.Unresolved {
+		label := dotEscape(fmt.Sprintf("%s  %s", u.Name, u.Reason)) + `\l`
+		fmt.Fprintf(&sb, "\t%s [label=\"%s\", style=dashed];\n", u.Name, label)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "\t%s -> %s [label=\"%s\"];\n", e.From, e.To, e.Kind)
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// dotEscape escapes s for use inside a quoted DOT label.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// writeJSON writes the graph as an indented JSON document.
+func (g *controlFlowGraph) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// runCFG implements the cfg command.
+func runCFG(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("cfg", flag.ExitOnError)
+	format := fs.String("format", "dot", "output `format`: dot or json")
+	var entries entryList
+	fs.Var(&entries, "entry", "extra comma separated entry point `offsets`, in addition to 0")
+	model := cpu8086
+	fs.Var(&model, "cpu", "instruction set to decode: 8086, 80186 or 80286-real")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: cfg [-format dot|json] [-entry offsets] [-cpu model] <binary>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected 1 binary, got %d", fs.NArg())
+	}
+
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	allEntries := append([]int{0}, entries...)
+	if err := checkEntries(b, allEntries); err != nil {
+		return err
+	}
+
+	g := buildCFG(b, allEntries, model)
+	switch *format {
+	case "dot":
+		return g.writeDOT(out)
+	case "json":
+		return g.writeJSON(out)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
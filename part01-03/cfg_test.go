@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildCFG(t *testing.T) {
+	input := []byte{
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0xeb, 0x04, // jmp short block_0009
+		0x12, 0x34, 0x56, 0x78, // data
+		0x05, 0x02, 0x00, // block_0009: add ax, strict word 2
+		0xe2, 0xfb, // loop block_0009
+		0xe8, 0x01, 0x00, // call block_0012
+		0xc3,       // ret
+		0x01, 0xd8, // block_0012: add ax, bx
+		0xc3, // ret
+	}
+
+	g := buildCFG(input, []int{0}, cpu8086)
+
+	type blockSummary struct {
+		name                   string
+		start, end             int
+		clocks, notTakenClocks int
+	}
+	var blocks []blockSummary
+	for _, blk := range g.Blocks {
+		blocks = append(blocks, blockSummary{blk.Name, blk.Start, blk.End, blk.Clocks, blk.NotTakenClocks})
+	}
+	wantBlocks := []blockSummary{
+		{"block_0000", 0x00, 0x05, 4 + 15, 0},
+		{"block_0009", 0x09, 0x0e, 4 + 17, 4 + 5},
+		{"block_000e", 0x0e, 0x11, 19, 0},
+		{"block_0011", 0x11, 0x12, 8, 0},
+		{"block_0012", 0x12, 0x15, 3 + 8, 0},
+	}
+	if !reflect.DeepEqual(blocks, wantBlocks) {
+		t.Errorf("blocks = %+v, want %+v", blocks, wantBlocks)
+	}
+
+	wantEdges := []cfgEdge{
+		{"block_0000", "block_0009", edgeJump},
+		{"block_0009", "block_0009", edgeTaken},
+		{"block_0009", "block_000e", edgeNotTaken},
+		{"block_000e", "block_0012", edgeCall},
+		{"block_000e", "block_0011", edgeFallthrough},
+	}
+	if !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("edges = %+v, want %+v", g.Edges, wantEdges)
+	}
+
+	if got := g.Blocks[1].Instructions[1].Text; got != "loop block_0009" {
+		t.Errorf("loop text = %q, want %q", got, "loop block_0009")
+	}
+}
+
+func TestCFGUnresolvedTargets(t *testing.T) {
+	input := []byte{
+		0xb8, 0x01, 0xc3, // mov ax, 0xc301
+		0x74, 0xfd, // je 0x0002, inside the mov
+		0xeb, 0x10, // jmp short 0x0017, past the end
+	}
+
+	g := buildCFG(input, []int{0}, cpu8086)
+
+	wantEdges := []cfgEdge{
+		{"block_0000", "unresolved_0002", edgeTaken},
+		{"block_0000", "block_0005", edgeNotTaken},
+		{"block_0005", "unresolved_0017", edgeJump},
+	}
+	if !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("edges = %+v, want %+v", g.Edges, wantEdges)
+	}
+	wantUnresolved := []unresolvedTarget{
+		{"unresolved_0002", 0x02, "inside the instruction at 0000"},
+		{"unresolved_0017", 0x17, "past the end of the image"},
+	}
+	if !reflect.DeepEqual(g.Unresolved, wantUnresolved) {
+		t.Errorf("unresolved = %+v, want %+v", g.Unresolved, wantUnresolved)
+	}
+
+	var sb strings.Builder
+	if err := g.writeDOT(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\tunresolved_0002 [label=\"unresolved_0002  inside the instruction at 0000\\l\", style=dashed];\n"; !strings.Contains(sb.String(), want) {
+		t.Errorf("DOT output lacks %q:\n%s", want, sb.String())
+	}
+}
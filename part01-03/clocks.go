@@ -0,0 +1,231 @@
+package main
+
+// Clock counts below follow the 8086 instruction timing tables in the Intel
+// 8086 Family User's Manual. They exclude the penalty for word transfers at
+// odd addresses, which depends on the runtime address and is charged by the
+// simulator.
+//
+// Instructions the 8086 lacks use the 80186 figures from the iAPX 186 data
+// sheet, which include the effective address calculation.
+//
+// 8087 instructions take the typical execution time from the 8087 data sheet
+// plus the 8086's effective address calculation, as if every one were
+// followed by a WAIT; the overlap of 8086 and 8087 execution is not modelled.
+// The later additions such as fsin use the typical 80387 figures.
+
+// eaClocks returns the effective address calculation time of a memory operand.
+func eaClocks(m common) int {
+	if m.mod == 0b00 && m.rm == 0b110 {
+		return 6 // Displacement only.
+	}
+
+	hasDisp := m.mod == 0b01 || m.mod == 0b10
+	switch m.rm {
+	case 0b000, 0b011: // bx + si, bp + di
+		if hasDisp {
+			return 11
+		}
+		return 7
+	case 0b001, 0b010: // bx + di, bp + si
+		if hasDisp {
+			return 12
+		}
+		return 8
+	default: // Base or index only.
+		if hasDisp {
+			return 9
+		}
+		return 5
+	}
+}
+
+// instructionClocks estimates the clocks taken by instr. taken selects the
+// timing of conditional transfers.
+func instructionClocks(instr instruction, taken bool) int {
+	switch in := instr.(type) {
+	case *mov:
+		return movClocks(in)
+	case *arithmetic:
+		return arithmeticClocks(in)
+	case *jumpOrLoop:
+		return jumpOrLoopClocks(in, taken)
+	case *branch:
+		return branchClocks(in)
+	case *implied:
+		return impliedClocks[in.op]
+	case *pushImmediate:
+		return 10
+	case *imulImmediate:
+		if in.mod == 0b11 {
+			return 22
+		}
+		return 25
+	case *shiftImmediate:
+		if in.mod == 0b11 {
+			return 5 + int(in.count&0x1f)
+		}
+		return 17 + int(in.count&0x1f)
+	case *enter:
+		return enterClocks(in)
+	case *bound:
+		return 33
+	case *esc:
+		return escClocks(in)
+	case *wait:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func movClocks(m *mov) int {
+	toMem := m.mod != 0b11
+	switch m.typ {
+	case movRegisterMemToFromRegister:
+		switch {
+		case !toMem:
+			return 2
+		case m.d == 1:
+			return 8 + eaClocks(m.common)
+		default:
+			return 9 + eaClocks(m.common)
+		}
+	case movImmediateToRegister:
+		return 4
+	case movImmediateToMemoryOrRegister:
+		if !toMem {
+			return 4
+		}
+		return 10 + eaClocks(m.common)
+	case movMemoryToAccumulator, movAccumulatorToMemory:
+		return 10
+	default:
+		return 0
+	}
+}
+
+func arithmeticClocks(a *arithmetic) int {
+	toMem := a.mod != 0b11
+	cmp := a.op == arithmeticCmp
+	switch a.typ {
+	case arithmeticRegOrMemWithRegToEither:
+		switch {
+		case !toMem:
+			return 3
+		case a.d == 1 || cmp:
+			return 9 + eaClocks(a.common)
+		default:
+			return 16 + eaClocks(a.common)
+		}
+	case arithmeticImmediateToRegOrMem:
+		switch {
+		case !toMem:
+			return 4
+		case cmp:
+			return 10 + eaClocks(a.common)
+		default:
+			return 17 + eaClocks(a.common)
+		}
+	case arithmeticImmediateToAccumulator:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func jumpOrLoopClocks(j *jumpOrLoop, taken bool) int {
+	var takenClocks, notTakenClocks int
+	if (j.op >> 4) == 0b0111 {
+		takenClocks, notTakenClocks = 16, 4
+	} else {
+		switch j.op & 0b11 {
+		case 0b00: // loopnz
+			takenClocks, notTakenClocks = 19, 5
+		case 0b01: // loopz
+			takenClocks, notTakenClocks = 18, 6
+		case 0b10: // loop
+			takenClocks, notTakenClocks = 17, 5
+		case 0b11: // jcxz
+			takenClocks, notTakenClocks = 18, 6
+		}
+	}
+	if taken {
+		return takenClocks
+	}
+	return notTakenClocks
+}
+
+func branchClocks(b *branch) int {
+	switch b.op {
+	case opCallNear:
+		return 19
+	case opJmpNear, opJmpShort:
+		return 15
+	case opRetNearImm:
+		return 12
+	default: // opRetNear
+		return 8
+	}
+}
+
+var impliedClocks = map[uint8]int{
+	opPusha: 36,
+	opPopa:  51,
+	opLeave: 8,
+	opInsb:  14,
+	opInsw:  14,
+	opOutsb: 14,
+	opOutsw: 14,
+}
+
+func enterClocks(e *enter) int {
+	switch level := int(e.level & 0x1f); level {
+	case 0:
+		return 15
+	case 1:
+		return 25
+	default:
+		return 22 + 16*(level-1)
+	}
+}
+
+// fpuRegisterClocks are the 8087 times of the register forms by mnemonic.
+var fpuRegisterClocks = map[string]int{
+	"fadd": 85, "faddp": 90, "fsub": 85, "fsubp": 90, "fsubr": 87, "fsubrp": 90,
+	"fmul": 138, "fmulp": 142, "fdiv": 198, "fdivp": 202, "fdivr": 199, "fdivrp": 203,
+	"fcom": 45, "fcomp": 47, "fcompp": 50, "ftst": 42, "fxam": 17,
+	"fld": 20, "fst": 18, "fstp": 20, "fxch": 12, "ffree": 11,
+	"fchs": 15, "fabs": 14, "fsqrt": 183, "fscale": 35, "frndint": 45,
+	"fprem": 125, "fxtract": 50, "f2xm1": 500, "fyl2x": 950, "fyl2xp1": 850,
+	"fptan": 450, "fpatan": 650, "fdecstp": 9, "fincstp": 9, "fnop": 13,
+	"fld1": 18, "fldz": 14, "fldpi": 19, "fldl2t": 19, "fldl2e": 18, "fldlg2": 21, "fldln2": 20,
+	"fninit": 5, "fnclex": 5, "fneni": 5, "fndisi": 5, "fnsetpm": 5, "fnstsw": 13,
+	"fsin": 257, "fcos": 257, "fsincos": 292, "fprem1": 95,
+	"fucom": 24, "fucomp": 26, "fucompp": 26,
+}
+
+// fpuMemoryClocks are the 8087 times of the memory forms, excluding the
+// effective address calculation.
+var fpuMemoryClocks = map[fpuOperation]int{
+	{"fadd", 4}: 105, {"fadd", 8}: 110, {"fsub", 4}: 105, {"fsub", 8}: 110, {"fsubr", 4}: 105, {"fsubr", 8}: 110,
+	{"fmul", 4}: 118, {"fmul", 8}: 161, {"fdiv", 4}: 220, {"fdiv", 8}: 225, {"fdivr", 4}: 221, {"fdivr", 8}: 226,
+	{"fcom", 4}: 65, {"fcom", 8}: 70, {"fcomp", 4}: 68, {"fcomp", 8}: 72,
+	{"fiadd", 2}: 120, {"fiadd", 4}: 125, {"fisub", 2}: 120, {"fisub", 4}: 125, {"fisubr", 2}: 120, {"fisubr", 4}: 125,
+	{"fimul", 2}: 130, {"fimul", 4}: 136, {"fidiv", 2}: 230, {"fidiv", 4}: 236, {"fidivr", 2}: 230, {"fidivr", 4}: 237,
+	{"ficom", 2}: 80, {"ficom", 4}: 85, {"ficomp", 2}: 82, {"ficomp", 4}: 87,
+	{"fld", 4}: 43, {"fld", 8}: 46, {"fld", 10}: 57, {"fst", 4}: 87, {"fst", 8}: 100,
+	{"fstp", 4}: 89, {"fstp", 8}: 102, {"fstp", 10}: 55,
+	{"fild", 2}: 50, {"fild", 4}: 56, {"fild", 8}: 64, {"fist", 2}: 86, {"fist", 4}: 88,
+	{"fistp", 2}: 88, {"fistp", 4}: 90, {"fistp", 8}: 100, {"fbld", 10}: 300, {"fbstp", 10}: 530,
+	{"fldcw", 0}: 10, {"fnstcw", 0}: 15, {"fnstsw", 0}: 15,
+	{"fldenv", 0}: 40, {"fnstenv", 0}: 45, {"frstor", 0}: 210, {"fnsave", 0}: 210,
+}
+
+func escClocks(e *esc) int {
+	if e.mod == 0b11 {
+		op, _ := e.registerOp()
+		return fpuRegisterClocks[op.name]
+	}
+	op, _ := e.memoryOp()
+	return fpuMemoryClocks[op] + eaClocks(e.common)
+}
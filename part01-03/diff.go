@@ -0,0 +1,306 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// diffItem is one instruction, or one run of data bytes, of an image being
+// diffed.
+type diffItem struct {
+	offset int
+	raw    []byte
+	text   string // NASM text, with transfer targets left relative.
+	op     string // Mnemonic, or the data directive.
+	clocks int    // Estimated clocks, taking conditional transfers. 0 for data.
+}
+
+// diffItems splits the image decoded in cm into instructions and data runs
+// in offset order.
+func diffItems(cm *codeMap) []diffItem {
+	var items []diffItem
+	for off := 0; off < len(cm.image); {
+		if d, ok := cm.instrs[off]; ok {
+			items = append(items, diffItem{
+				offset: off,
+				raw:    cm.image[off:d.next()],
+				text:   nasm.print(d.instr.form()),
+				op:     d.instr.form().mnemonic,
+				clocks: instructionClocks(d.instr, true),
+			})
+			off = d.next()
+			continue
+		}
+		end, size := cm.dataRun(off, nil)
+		items = append(items, diffItem{offset: off, raw: cm.image[off:end], text: nasm.data(cm.image[off:end], size), op: dataDirective(size)})
+		off = end
+	}
+	return items
+}
+
+// editKind is the kind of an edit between two instruction sequences.
+type editKind uint8
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+	editChange
+)
+
+// edit aligns an item of the old sequence with one of the new. Deletions
+// have no new item and insertions no old one, marked by -1.
+type edit struct {
+	kind     editKind
+	from, to int // Indexes into the old and new sequences.
+}
+
+// alignItems returns the edits from a to b. Items with identical encodings
+// are aligned first. Between those, items with the same mnemonic are paired
+// as changes and the rest are deleted or inserted.
+func alignItems(a, b []diffItem) []edit {
+	var edits []edit
+	coarse := align(len(a), len(b), func(x, y int) bool { return string(a[x].raw) == string(b[y].raw) })
+	for i := 0; i < len(coarse); {
+		if coarse[i].kind == editEqual {
+			edits = append(edits, coarse[i])
+			i++
+			continue
+		}
+		var deleted, inserted []int
+		for ; i < len(coarse) && coarse[i].kind != editEqual; i++ {
+			if coarse[i].kind == editDelete {
+				deleted = append(deleted, coarse[i].from)
+			} else {
+				inserted = append(inserted, coarse[i].to)
+			}
+		}
+		fine := align(len(deleted), len(inserted), func(x, y int) bool { return a[deleted[x]].op == b[inserted[y]].op })
+		for _, e := range fine {
+			switch e.kind {
+			case editEqual:
+				edits = append(edits, edit{editChange, deleted[e.from], inserted[e.to]})
+			case editDelete:
+				edits = append(edits, edit{editDelete, deleted[e.from], -1})
+			default:
+				edits = append(edits, edit{editInsert, -1, inserted[e.to]})
+			}
+		}
+	}
+	return edits
+}
+
+// align returns the shortest edit script from a sequence of n items to one
+// of m, given whether item x of the first equals item y of the second. It is
+// Myers' O(ND) algorithm: the work grows with the number of differences,
+// which is small for a tweaked routine, rather than with the product of the
+// lengths. It uses the linear space refinement, so memory stays O(n+m) even
+// for images with nothing in common.
+func align(n, m int, equal func(x, y int) bool) []edit {
+	offset := (n+m+1)/2 + 1
+	al := aligner{
+		equal:  equal,
+		fwd:    make([]int, 2*offset+1),
+		rev:    make([]int, 2*offset+1),
+		offset: offset,
+	}
+	al.compare(0, n, 0, m)
+	return al.edits
+}
+
+// aligner holds the state of align. fwd and rev are indexed by diagonal
+// plus offset and reused by every level of the recursion.
+type aligner struct {
+	equal    func(x, y int) bool
+	fwd, rev []int
+	offset   int
+	edits    []edit
+}
+
+// compare appends the edits from items x0 to x1 of the first sequence to
+// items y0 to y1 of the second. Past the common prefix and suffix it splits
+// both ranges at the middle snake of a shortest script and recurses.
+func (al *aligner) compare(x0, x1, y0, y1 int) {
+	for x0 < x1 && y0 < y1 && al.equal(x0, y0) {
+		al.edits = append(al.edits, edit{editEqual, x0, y0})
+		x0, y0 = x0+1, y0+1
+	}
+	suffix := 0
+	for x0 < x1 && y0 < y1 && al.equal(x1-1, y1-1) {
+		x1, y1 = x1-1, y1-1
+		suffix++
+	}
+
+	switch {
+	case x0 == x1:
+		for y := y0; y < y1; y++ {
+			al.edits = append(al.edits, edit{editInsert, -1, y})
+		}
+	case y0 == y1:
+		for x := x0; x < x1; x++ {
+			al.edits = append(al.edits, edit{editDelete, x, -1})
+		}
+	default:
+		x, y, u, v := al.middleSnake(x0, x1, y0, y1)
+		al.compare(x0, x, y0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			al.edits = append(al.edits, edit{editEqual, x, y})
+		}
+		al.compare(u, x1, v, y1)
+	}
+
+	for i := 0; i < suffix; i++ {
+		al.edits = append(al.edits, edit{editEqual, x1 + i, y1 + i})
+	}
+}
+
+// middleSnake finds the diagonal run crossed by the middle of a shortest
+// path from (x0, y0) to (x1, y1), searching forward from the start and
+// backward from the end until the two searches overlap. It returns the
+// run's start (x, y) and end (u, v).
+func (al *aligner) middleSnake(x0, x1, y0, y1 int) (x, y, u, v int) {
+	n, m := x1-x0, y1-y0
+	delta := n - m
+	odd := delta&1 != 0
+	fwd, rev, off := al.fwd, al.rev, al.offset
+	fwd[off+1], rev[off+1] = 0, 0
+
+	// Both searches track the furthest x reached on each diagonal k = x - y,
+	// the reverse one in coordinates measured back from the end. Forward
+	// diagonal k meets reverse diagonal delta - k.
+	for d := 0; d <= (n+m+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && al.equal(x0+x, y0+y) {
+				x, y = x+1, y+1
+			}
+			fwd[off+k] = x
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+rev[off+c] >= n {
+				return x0 + sx, y0 + sy, x0 + x, y0 + y
+			}
+		}
+		for c := -d; c <= d; c += 2 {
+			var x int
+			if c == -d || (c != d && rev[off+c-1] < rev[off+c+1]) {
+				x = rev[off+c+1]
+			} else {
+				x = rev[off+c-1] + 1
+			}
+			y := x - c
+			sx, sy := x, y
+			for x < n && y < m && al.equal(x1-x-1, y1-y-1) {
+				x, y = x+1, y+1
+			}
+			rev[off+c] = x
+			if k := delta - c; !odd && k >= -d && k <= d && x+fwd[off+k] >= n {
+				return x1 - x, y1 - y, x1 - sx, y1 - sy
+			}
+		}
+	}
+	panic("align: no middle snake")
+}
+
+// diffSummary counts the edits between two images.
+type diffSummary struct {
+	changed, deleted, inserted int
+	oldClocks, newClocks       int // Estimated clocks of every instruction.
+}
+
+// writeDiffLine writes one side of an edit: a label, the offset, raw bytes,
+// text and clocks, followed by the clock delta if set.
+func writeDiffLine(out io.Writer, label string, it diffItem, delta string) {
+	fmt.Fprintf(out, "%-9s %04x  %-*s  %-28s %3d%s\n", label, it.offset, listingHexWidth, hexBytes(it.raw), it.text, it.clocks, delta)
+}
+
+// writeItemDiff prints the edits between a and b, a blank line between
+// each group of consecutive edits, and returns their totals.
+func writeItemDiff(out io.Writer, a, b []diffItem, edits []edit) diffSummary {
+	var s diffSummary
+	for _, it := range a {
+		s.oldClocks += it.clocks
+	}
+	for _, it := range b {
+		s.newClocks += it.clocks
+	}
+
+	inHunk := false
+	for _, e := range edits {
+		if e.kind == editEqual {
+			inHunk = false
+			continue
+		}
+		if !inHunk && s.changed+s.deleted+s.inserted > 0 {
+			fmt.Fprintln(out)
+		}
+		inHunk = true
+
+		switch e.kind {
+		case editChange:
+			s.changed++
+			writeDiffLine(out, "changed", a[e.from], "")
+			writeDiffLine(out, "       ->", b[e.to], fmt.Sprintf(" %+d", b[e.to].clocks-a[e.from].clocks))
+		case editDelete:
+			s.deleted++
+			writeDiffLine(out, "deleted", a[e.from], fmt.Sprintf(" %+d", -a[e.from].clocks))
+		case editInsert:
+			s.inserted++
+			writeDiffLine(out, "inserted", b[e.to], fmt.Sprintf(" %+d", b[e.to].clocks))
+		}
+	}
+	return s
+}
+
+// runDiff implements the diff command, which aligns two binaries
+// instruction by instruction. It returns true if they differ.
+func runDiff(args []string, out io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	recursive := fs.Bool("recursive", false, "decode by recursive traversal from offset 0, comparing unreached bytes as data")
+	model := cpu8086
+	fs.Var(&model, "cpu", "instruction set to decode: 8086, 80186 or 80286-real")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: diff [-recursive] [-cpu model] <old binary> <new binary>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return false, fmt.Errorf("expected 2 binaries, got %d", fs.NArg())
+	}
+
+	items := make([][]diffItem, 2)
+	for i, name := range fs.Args() {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return false, err
+		}
+		var cm *codeMap
+		if *recursive {
+			cm = traverse(b, []int{0}, model, nil)
+		} else if cm, err = sweep(b, model, nil); err != nil {
+			return false, fmt.Errorf("decoding %s: %w", name, err)
+		}
+		items[i] = diffItems(cm)
+	}
+
+	a, b := items[0], items[1]
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", fs.Arg(0), fs.Arg(1))
+	s := writeItemDiff(out, a, b, alignItems(a, b))
+	if s.changed+s.deleted+s.inserted == 0 {
+		fmt.Fprintf(out, "binaries match (%d instructions)\n", len(a))
+		return false, nil
+	}
+	fmt.Fprintf(out, "\n%d changed, %d deleted, %d inserted; estimated clocks %d -> %d (%+d)\n",
+		s.changed, s.deleted, s.inserted, s.oldClocks, s.newClocks, s.newClocks-s.oldClocks)
+	return true, nil
+}
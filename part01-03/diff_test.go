@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int // Deletions plus insertions in the shortest script.
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"abcabba", "cbabac", 5},
+		{"xaxbx", "ab", 3},
+	}
+
+	for _, tt := range tests {
+		edits := align(len(tt.a), len(tt.b), func(x, y int) bool { return tt.a[x] == tt.b[y] })
+
+		// Replaying the script over a must produce b.
+		var got strings.Builder
+		n, x, y := 0, 0, 0
+		for _, e := range edits {
+			switch e.kind {
+			case editEqual:
+				if e.from != x || e.to != y || tt.a[x] != tt.b[y] {
+					t.Fatalf("%q -> %q: bad match %+v at %d, %d", tt.a, tt.b, e, x, y)
+				}
+				got.WriteByte(tt.a[x])
+				x, y = x+1, y+1
+			case editDelete:
+				if e.from != x {
+					t.Fatalf("%q -> %q: deletes %d at %d", tt.a, tt.b, e.from, x)
+				}
+				x, n = x+1, n+1
+			case editInsert:
+				if e.to != y {
+					t.Fatalf("%q -> %q: inserts %d at %d", tt.a, tt.b, e.to, y)
+				}
+				got.WriteByte(tt.b[y])
+				y, n = y+1, n+1
+			}
+		}
+		if got.String() != tt.b || x != len(tt.a) {
+			t.Errorf("%q -> %q: script produces %q", tt.a, tt.b, got.String())
+		}
+		if n != tt.edits {
+			t.Errorf("%q -> %q: %d edits, want %d", tt.a, tt.b, n, tt.edits)
+		}
+	}
+}
+
+func TestDiffItems(t *testing.T) {
+	old := []byte{
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0x01, 0xd8, // add ax, bx
+		0x01, 0xd8, // add ax, bx
+		0xe2, 0xfa, // loop $+2-6
+		0x89, 0xd8, // mov ax, bx
+		0xc3, // ret
+	}
+	cur := []byte{
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0x01, 0xd8, // add ax, bx
+		0x01, 0xc0, // add ax, ax
+		0x01, 0xc8, // add ax, cx
+		0xe2, 0xf8, // loop $+2-8
+		0xc3, // ret
+	}
+
+	items := make([][]diffItem, 2)
+	for i, b := range [][]byte{old, cur} {
+		cm, err := sweep(b, cpu8086, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = diffItems(cm)
+	}
+
+	var out strings.Builder
+	s := writeItemDiff(&out, items[0], items[1], alignItems(items[0], items[1]))
+	want := `changed   0005  01 d8               add ax, bx                     3
+       -> 0005  01 c0               add ax, ax                     3 +0
+inserted  0007  01 c8               add ax, cx                     3 +3
+changed   0007  e2 fa               loop $+2-6                    17
+       -> 0009  e2 f8               loop $+2-8                    17 +0
+deleted   0009  89 d8               mov ax, bx                     2 -2
+`
+	if out.String() != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if s.changed != 2 || s.deleted != 1 || s.inserted != 1 || s.newClocks-s.oldClocks != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+
+	out.Reset()
+	if s := writeItemDiff(&out, items[0], items[0], alignItems(items[0], items[0])); s.changed+s.deleted+s.inserted != 0 || out.Len() != 0 {
+		t.Errorf("identical images differ: %+v\n%s", s, out.String())
+	}
+}
+
+// TestAlignItemsFullyDifferent diffs two images with nothing in common, the
+// worst case for Myers' algorithm, and checks that memory stays linear.
+func TestAlignItemsFullyDifferent(t *testing.T) {
+	const n = 3000
+	items := make([][]diffItem, 2)
+	for i, instr := range [][]byte{{0x89, 0xd8}, {0x01, 0xd8}} { // mov ax, bx; add ax, bx
+		cm, err := sweep(bytes.Repeat(instr, n), cpu8086, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = diffItems(cm)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	edits := alignItems(items[0], items[1])
+	runtime.ReadMemStats(&after)
+
+	var deleted, inserted int
+	for _, e := range edits {
+		switch e.kind {
+		case editDelete:
+			deleted++
+		case editInsert:
+			inserted++
+		default:
+			t.Fatalf("unexpected edit %+v", e)
+		}
+	}
+	if deleted != n || inserted != n {
+		t.Errorf("deleted, inserted = %d, %d, want %d, %d", deleted, inserted, n, n)
+	}
+	// Saving every round of the search would take D·(n+m) words, over half a
+	// gigabyte here.
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("aligning allocated %d bytes", alloc)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ahrav/perf-aware-programming/part01-03/fields"
+)
+
+// encoding describes how the bytes of a decoded instruction divide into the
+// fields of the encoding tables in the Intel manual. Every instruction
+// reports its own, so the field listing always follows the decoder.
+type encoding struct {
+	opcode string   // The first byte in the notation of fields.Opcode.
+	reg    string   // Name of the mod/reg/rm byte's middle field, empty without one.
+	imm    []string // Names of the bytes after the mod/reg/rm byte and displacement.
+}
+
+// dataFields names the bytes of an immediate of one or two bytes.
+func dataFields(wide bool) []string {
+	if wide {
+		return []string{"data-lo", "data-hi"}
+	}
+	return []string{"data-lo"}
+}
+
+// dispSize returns the number of displacement bytes that follow the mod/reg/rm
+// byte.
+func (c *common) dispSize() int {
+	switch {
+	case c.mod == 0b01:
+		return 1
+	case c.mod == 0b10 || (c.mod == 0b00 && c.rm == 0b110):
+		return 2
+	default:
+		return 0
+	}
+}
+
+// layoutFields labels the encoding fields of every byte of d, whose bytes
+// are raw. It fails if the fields do not cover exactly the bytes the decoder
+// consumed.
+func layoutFields(d decodedInstruction, raw []byte) ([]fields.Field, error) {
+	if len(raw) != d.size {
+		return nil, fmt.Errorf("got %d bytes for a %d byte instruction", len(raw), d.size)
+	}
+	e := d.instr.encoding()
+	layout := fields.Opcode(e.opcode, raw[0])
+	n := 1
+	next := func(name string) {
+		if n < len(raw) {
+			layout = append(layout, fields.Whole(name, n, raw[n]))
+		}
+		n++
+	}
+
+	if e.reg != "" {
+		if len(raw) < 2 {
+			return nil, fmt.Errorf("missing mod/reg/rm byte")
+		}
+		layout = append(layout, fields.ModRM(1, raw[1], e.reg)...)
+		n = 2
+		c := common{mod: raw[1] >> 6, rm: raw[1] & 0b111}
+		switch c.dispSize() {
+		case 1:
+			next("disp-lo")
+		case 2:
+			next("disp-lo")
+			next("disp-hi")
+		}
+	}
+	for _, name := range e.imm {
+		next(name)
+	}
+
+	if n != d.size {
+		return nil, fmt.Errorf("fields cover %d bytes, decoder consumed %d", n, d.size)
+	}
+	return layout, nil
+}
+
+func (m *mov) encoding() encoding {
+	wide := m.w == 1
+	switch m.typ {
+	case movRegisterMemToFromRegister:
+		return encoding{opcode: "100010dw", reg: "reg"}
+	case movImmediateToRegister:
+		return encoding{opcode: "1011wrrr", imm: dataFields(wide)}
+	case movImmediateToMemoryOrRegister:
+		return encoding{opcode: "1100011w", reg: "ext", imm: dataFields(wide)}
+	default: // Accumulator to or from memory.
+		return encoding{opcode: "101000xw", imm: []string{"addr-lo", "addr-hi"}}
+	}
+}
+
+func (a *arithmetic) encoding() encoding {
+	switch a.typ {
+	case arithmeticImmediateToRegOrMem:
+		if a.s == 1 && a.w == 1 {
+			// A byte sign-extended to a word.
+			return encoding{opcode: "100000sw", reg: "ext", imm: []string{"data-8"}}
+		}
+		return encoding{opcode: "100000sw", reg: "ext", imm: dataFields(a.w == 1)}
+	case arithmeticImmediateToAccumulator:
+		return encoding{opcode: "00xxx10w", imm: dataFields(a.w == 1)}
+	default:
+		return encoding{opcode: "00xxx0dw", reg: "reg"}
+	}
+}
+
+func (j *jumpOrLoop) encoding() encoding {
+	return encoding{imm: []string{"ip-inc8"}}
+}
+
+func (b *branch) encoding() encoding {
+	switch b.op {
+	case opCallNear, opJmpNear:
+		return encoding{imm: []string{"ip-inc-lo", "ip-inc-hi"}}
+	case opJmpShort:
+		return encoding{imm: []string{"ip-inc8"}}
+	case opRetNearImm:
+		return encoding{imm: dataFields(true)}
+	default:
+		return encoding{}
+	}
+}
+
+func (i *implied) encoding() encoding {
+	switch i.op {
+	case opInsb, opInsw, opOutsb, opOutsw:
+		return encoding{opcode: "011011xw"}
+	default:
+		return encoding{}
+	}
+}
+
+func (p *pushImmediate) encoding() encoding {
+	if p.op == opPushImmByte {
+		return encoding{imm: []string{"data-8"}}
+	}
+	return encoding{imm: dataFields(true)}
+}
+
+func (m *imulImmediate) encoding() encoding {
+	if m.op == opImulImmByte {
+		return encoding{reg: "reg", imm: []string{"data-8"}}
+	}
+	return encoding{reg: "reg", imm: dataFields(true)}
+}
+
+func (s *shiftImmediate) encoding() encoding {
+	return encoding{opcode: "1100000w", reg: "ext", imm: []string{"data-8"}}
+}
+
+func (e *enter) encoding() encoding {
+	return encoding{imm: []string{"data-lo", "data-hi", "level"}}
+}
+
+func (b *bound) encoding() encoding {
+	return encoding{reg: "reg"}
+}
+
+// The ext field and the low opcode bits together select the coprocessor
+// instruction.
+func (e *esc) encoding() encoding {
+	return encoding{reg: "ext"}
+}
+
+func (w *wait) encoding() encoding {
+	return encoding{}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+)
+
+// cpuModel selects the instruction set the decoder accepts and the simulator
+// executes. Later models accept everything earlier ones do.
+type cpuModel uint8
+
+const (
+	cpu8086      cpuModel = iota
+	cpu80186              // Also the NEC V20 and V30.
+	cpu80286Real          // The 80286 in real mode, which adds nothing over the 80186 here.
+)
+
+var cpuModelNames = []string{"8086", "80186", "80286-real"}
+
+func (m cpuModel) String() string {
+	return cpuModelNames[m]
+}
+
+// Set implements flag.Value.
+func (m *cpuModel) Set(s string) error {
+	for i, name := range cpuModelNames {
+		if s == name {
+			*m = cpuModel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cpu %q, expected 8086, 80186 or 80286-real", s)
+}
+
+// Opcodes introduced by the 80186.
+const (
+	opPusha        = 0b01100000 // PUSHA
+	opPopa         = 0b01100001 // POPA
+	opBound        = 0b01100010 // BOUND reg16, mem16&16
+	opPushImm      = 0b01101000 // PUSH imm16
+	opImulImm      = 0b01101001 // IMUL reg16, r/m16, imm16
+	opPushImmByte  = 0b01101010 // PUSH imm8, sign-extended
+	opImulImmByte  = 0b01101011 // IMUL reg16, r/m16, imm8, sign-extended
+	opInsb         = 0b01101100 // INSB
+	opInsw         = 0b01101101 // INSW
+	opOutsb        = 0b01101110 // OUTSB
+	opOutsw        = 0b01101111 // OUTSW
+	opShiftImmByte = 0b11000000 // Shift group r/m8, imm8
+	opShiftImm     = 0b11000001 // Shift group r/m16, imm8
+	opEnter        = 0b11001000 // ENTER imm16, imm8
+	opLeave        = 0b11001001 // LEAVE
+)
+
+// minCPU maps every opcode outside the 8086 set to the first model that
+// implements it. An 8086 executes most of these as aliases of other
+// instructions, which strict 8086 decoding reports as invalid instead.
+var minCPU = map[byte]cpuModel{
+	opPusha:        cpu80186,
+	opPopa:         cpu80186,
+	opBound:        cpu80186,
+	opPushImm:      cpu80186,
+	opImulImm:      cpu80186,
+	opPushImmByte:  cpu80186,
+	opImulImmByte:  cpu80186,
+	opInsb:         cpu80186,
+	opInsw:         cpu80186,
+	opOutsb:        cpu80186,
+	opOutsw:        cpu80186,
+	opShiftImmByte: cpu80186,
+	opShiftImm:     cpu80186,
+	opEnter:        cpu80186,
+	opLeave:        cpu80186,
+}
+
+// isExtendedOp determines if the given byte is an opcode added after the 8086.
+func isExtendedOp(b byte) bool {
+	_, ok := minCPU[b]
+	return ok
+}
+
+// implied is an extended instruction without operands: PUSHA, POPA, LEAVE
+// and the string I/O instructions.
+type implied struct {
+	op uint8
+}
+
+var impliedNames = map[uint8]string{
+	opPusha: "pusha",
+	opPopa:  "popa",
+	opLeave: "leave",
+	opInsb:  "insb",
+	opInsw:  "insw",
+	opOutsb: "outsb",
+	opOutsw: "outsw",
+}
+
+func (i *implied) disassemble() string {
+	return nasm.print(i.form())
+}
+
+func (i *implied) form() form {
+	return form{mnemonic: impliedNames[i.op]}
+}
+
+// pushImmediate is PUSH imm16 or PUSH imm8.
+type pushImmediate struct {
+	op   uint8
+	data uint16 // Sign-extended to a word for PUSH imm8.
+}
+
+func (p *pushImmediate) disassemble() string {
+	return nasm.print(p.form())
+}
+
+func (p *pushImmediate) form() form {
+	imm := immediateOperand(p.data, true)
+	imm.strict = p.op == opPushImm && fitsInt8(imm.value)
+	return form{mnemonic: "push", operands: []operand{imm}}
+}
+
+// imulImmediate is the three operand IMUL reg16, r/m16, imm.
+type imulImmediate struct {
+	op uint8
+	common
+	data uint16 // Sign-extended to a word for the imm8 form.
+}
+
+func (m *imulImmediate) disassemble() string {
+	return nasm.print(m.form())
+}
+
+func (m *imulImmediate) form() form {
+	imm := immediateOperand(m.data, true)
+	imm.strict = m.op == opImulImm && fitsInt8(imm.value)
+	return form{mnemonic: "imul", operands: []operand{m.regOperand(true), m.rmOperand(true), imm}}
+}
+
+// shiftImmediate is a rotate or shift of r/m by an immediate count. The reg
+// field selects the operation.
+type shiftImmediate struct {
+	w byte // Operand size bit.
+	common
+	count uint8
+}
+
+// shiftNames is indexed by the reg field of the shift group. 110 is an
+// undocumented alias of shl.
+var shiftNames = []string{"rol", "ror", "rcl", "rcr", "shl", "shr", "sal", "sar"}
+
+func (s *shiftImmediate) disassemble() string {
+	return nasm.print(s.form())
+}
+
+func (s *shiftImmediate) form() form {
+	f := form{
+		mnemonic: shiftNames[s.reg],
+		operands: []operand{s.rmOperand(s.w == 1), {kind: operandImmediate, value: int(s.count)}},
+	}
+	if s.mod != 0b11 {
+		f.size, f.sizeOperand = int(s.w)+1, 0
+	}
+	return f
+}
+
+// enter is ENTER imm16, imm8: create a stack frame of size bytes nested
+// level deep.
+type enter struct {
+	size  uint16
+	level uint8
+}
+
+func (e *enter) disassemble() string {
+	return nasm.print(e.form())
+}
+
+func (e *enter) form() form {
+	return form{mnemonic: "enter", operands: []operand{
+		{kind: operandImmediate, value: int(e.size)},
+		{kind: operandImmediate, value: int(e.level)},
+	}}
+}
+
+// bound checks a signed register against a pair of word bounds in memory.
+type bound struct {
+	common
+}
+
+func (b *bound) disassemble() string {
+	return nasm.print(b.form())
+}
+
+func (b *bound) form() form {
+	return form{mnemonic: "bound", operands: []operand{b.regOperand(true), b.rmOperand(true)}}
+}
+
+// decodeExtended decodes an instruction added by the 80186 from a stream of
+// bytes provided by a peekableByteReader.
+func decodeExtended(r *peekableByteReader) instruction {
+	op := r.readByte()
+
+	switch op {
+	case opPusha, opPopa, opLeave, opInsb, opInsw, opOutsb, opOutsw:
+		return &implied{op: op}
+	case opPushImm:
+		return &pushImmediate{op: op, data: r.readUint16()}
+	case opPushImmByte:
+		return &pushImmediate{op: op, data: uint16(int16(r.readInt8()))}
+	case opImulImm:
+		instruction := imulImmediate{op: op, common: decodeCommon(r)}
+		instruction.data = r.readUint16()
+		return &instruction
+	case opImulImmByte:
+		instruction := imulImmediate{op: op, common: decodeCommon(r)}
+		instruction.data = uint16(int16(r.readInt8()))
+		return &instruction
+	case opShiftImmByte, opShiftImm:
+		instruction := shiftImmediate{w: op & 1, common: decodeCommon(r)}
+		instruction.count = r.readUint8()
+		return &instruction
+	case opEnter:
+		instruction := enter{size: r.readUint16()}
+		instruction.level = r.readUint8()
+		return &instruction
+	case opBound:
+		instruction := bound{common: decodeCommon(r)}
+		if instruction.mod == 0b11 {
+			r.fail(fmt.Errorf("bound requires a memory operand"))
+		}
+		return &instruction
+	default:
+		r.fail(fmt.Errorf("invalid extended opcode: %b", op))
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeExtended(t *testing.T) {
+	tests := []struct {
+		input []byte
+		want  string
+	}{
+		{[]byte{0x60}, "pusha"},
+		{[]byte{0x61}, "popa"},
+		{[]byte{0x62, 0x07}, "bound ax, [bx]"},
+		{[]byte{0x68, 0x00, 0x01}, "push 256"},
+		{[]byte{0x68, 0x05, 0x00}, "push strict word 5"},
+		{[]byte{0x6a, 0xfe}, "push -2"},
+		{[]byte{0x69, 0xc3, 0x2c, 0x01}, "imul ax, bx, 300"},
+		{[]byte{0x6b, 0x47, 0x02, 0xfd}, "imul ax, [bx + 2], -3"},
+		{[]byte{0x6c}, "insb"},
+		{[]byte{0x6d}, "insw"},
+		{[]byte{0x6e}, "outsb"},
+		{[]byte{0x6f}, "outsw"},
+		{[]byte{0xc0, 0xe0, 0x03}, "shl al, 3"},
+		{[]byte{0xc1, 0x2f, 0x02}, "shr word [bx], 2"},
+		{[]byte{0xc1, 0xf9, 0x0f}, "sar cx, 15"},
+		{[]byte{0xc8, 0x10, 0x00, 0x01}, "enter 16, 1"},
+		{[]byte{0xc9}, "leave"},
+	}
+
+	for _, tt := range tests {
+		d, err := decodeAt(tt.input, 0, cpu80186)
+		if err != nil {
+			t.Errorf("%x: %v", tt.input, err)
+			continue
+		}
+		if got := d.instr.disassemble(); got != tt.want {
+			t.Errorf("%x: Expected %q, got %q", tt.input, tt.want, got)
+		}
+		if d.size != len(tt.input) {
+			t.Errorf("%x: decoded %d bytes, want %d", tt.input, d.size, len(tt.input))
+		}
+		if _, err := layoutFields(d, tt.input); err != nil {
+			t.Errorf("%x: labelling fields: %v", tt.input, err)
+		}
+
+		// The 80286 in real mode accepts everything the 80186 does.
+		if _, err := decodeAt(tt.input, 0, cpu80286Real); err != nil {
+			t.Errorf("%x: 80286-real: %v", tt.input, err)
+		}
+	}
+}
+
+func TestStrict8086RejectsExtendedOpcodes(t *testing.T) {
+	for op := range minCPU {
+		input := []byte{op, 0x07, 0x00, 0x00}
+		_, err := decodeAt(input, 0, cpu8086)
+		if err == nil || !strings.Contains(err.Error(), "requires the 80186") {
+			t.Errorf("%02x: expected an 80186 error, got %v", op, err)
+		}
+	}
+}
+
+func TestSimulateExtended(t *testing.T) {
+	program := []byte{
+		0xbc, 0x00, 0x01, // mov sp, 256
+		0xb8, 0x34, 0x12, // mov ax, 4660
+		0xbb, 0x05, 0x00, // mov bx, 5
+		0x6a, 0x07, // push 7
+		0x6a, 0xfe, // push -2
+		0x60,             // pusha
+		0xb8, 0x00, 0x00, // mov ax, 0
+		0x61,             // popa
+		0x6b, 0xcb, 0xfd, // imul cx, bx, -3
+		0xc1, 0xe3, 0x04, // shl bx, 4
+		0xba, 0x01, 0x80, // mov dx, -32767
+		0xc1, 0xfa, 0x01, // sar dx, 1
+		0xc8, 0x04, 0x00, 0x00, // enter 4, 0
+		0xc9, // leave
+	}
+
+	c := newCPU()
+	c.model = cpu80186
+	c.load(program)
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[int]uint16{regAX: 0x1234, regBX: 0x50, regCX: 0xfff1, regDX: 0xc000, regSP: 0xfc, regBP: 0}
+	for r, v := range want {
+		if got := c.regs[r]; got != v {
+			t.Errorf("%s = %#x, want %#x", regs[true][r], got, v)
+		}
+	}
+	if got := flagString(c.flags); got != "CPS" {
+		t.Errorf("flags = %q, want %q", got, "CPS")
+	}
+	if got := c.readMem(0, 0xfc, true); got != 0xfffe {
+		t.Errorf("[0xfc] = %#x, want 0xfffe", got)
+	}
+	if got := c.readMem(0, 0xfe, true); got != 7 {
+		t.Errorf("[0xfe] = %#x, want 7", got)
+	}
+}
+
+func TestSimulateNestedEnter(t *testing.T) {
+	c := newCPU()
+	c.model = cpu80186
+	c.load([]byte{0xc8, 0x00, 0x00, 0x02}) // enter 0, 2
+	c.regs[regBP] = 0x80
+	c.regs[regSP] = 0x70
+	c.writeMem(0, 0x7e, true, 0x1111)
+	if _, err := c.step(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.regs[regBP] != 0x6e || c.regs[regSP] != 0x6a {
+		t.Errorf("bp, sp = %#x, %#x, want 0x6e, 0x6a", c.regs[regBP], c.regs[regSP])
+	}
+	if got := c.readMem(0, 0x6c, true); got != 0x1111 {
+		t.Errorf("copied frame pointer = %#x, want 0x1111", got)
+	}
+	if got := c.readMem(0, 0x6a, true); got != 0x6e {
+		t.Errorf("new frame pointer = %#x, want 0x6e", got)
+	}
+}
+
+func TestSimulateBoundFailureStops(t *testing.T) {
+	c := newCPU()
+	c.model = cpu80186
+	c.load([]byte{0x62, 0x06, 0x00, 0x01}) // bound ax, [256]
+	c.writeMem(0, 0x100, true, 1)
+	c.writeMem(0, 0x102, true, 10)
+	c.regs[regAX] = 11
+	if _, err := c.step(); err == nil || !strings.Contains(err.Error(), "interrupt 5") {
+		t.Errorf("expected a bound failure, got %v", err)
+	}
+}
+
+func TestDecodeExtendedRejectsOtherOpcodes(t *testing.T) {
+	r := newPeekableBytReader([]byte{0x90})
+	r.model = cpu80186
+	if instr := decodeExtended(r); instr != nil || r.err == nil {
+		t.Errorf("decodeExtended(0x90) = %v, %v, want an error", instr, r.err)
+	}
+}
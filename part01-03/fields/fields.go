@@ -0,0 +1,105 @@
+// Package fields labels the encoding fields of 8086 instructions, in the
+// style of the instruction encoding tables in the Intel 8086 Family User's
+// Manual: opcode bits, d, w, s, mod, reg, rm, displacements and data.
+//
+// It only renders fields. Which fields an instruction has is decided by the
+// decoder that consumed its bytes.
+package fields
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a run of bits within one byte of an encoded instruction.
+type Field struct {
+	Name  string // Field name, e.g. "opcode", "w", "mod" or "disp-lo".
+	Byte  int    // Index of the byte within the instruction.
+	Hi    uint   // Most significant bit of the field, 7 for the top bit.
+	Lo    uint   // Least significant bit of the field.
+	Value byte   // Field value, shifted down to bit 0.
+}
+
+// Bits renders the field value in binary with the field's width.
+func (f Field) Bits() string {
+	return fmt.Sprintf("%0*b", int(f.Hi-f.Lo+1), f.Value)
+}
+
+// Opcode splits the first byte b of an instruction into the runs described
+// by spec, from bit 7 to bit 0: 0, 1 and x are opcode bits, d, s and w are
+// the single bit flags and r is a 3 bit register field. An empty spec makes
+// the whole byte the opcode.
+func Opcode(spec string, b byte) []Field {
+	if spec == "" {
+		spec = "xxxxxxxx"
+	}
+	var fields []Field
+	for i := 0; i < len(spec); {
+		kind := fieldKind(spec[i])
+		j := i + 1
+		for j < len(spec) && fieldKind(spec[j]) == kind {
+			j++
+		}
+		hi, lo := uint(7-i), uint(8-j)
+		fields = append(fields, Field{Name: kind, Byte: 0, Hi: hi, Lo: lo, Value: bitsOf(b, hi, lo)})
+		i = j
+	}
+	return fields
+}
+
+// fieldKind names the field a spec character belongs to.
+func fieldKind(c byte) string {
+	switch c {
+	case '0', '1', 'x':
+		return "opcode"
+	case 'r':
+		return "reg"
+	default:
+		return string(c)
+	}
+}
+
+func bitsOf(b byte, hi, lo uint) byte {
+	return (b >> lo) & (1<<(hi-lo+1) - 1)
+}
+
+// ModRM splits the mod/reg/rm byte b at index i, naming the middle field
+// reg: "reg", or "ext" where it extends the opcode.
+func ModRM(i int, b byte, reg string) []Field {
+	return []Field{
+		{Name: "mod", Byte: i, Hi: 7, Lo: 6, Value: bitsOf(b, 7, 6)},
+		{Name: reg, Byte: i, Hi: 5, Lo: 3, Value: bitsOf(b, 5, 3)},
+		{Name: "rm", Byte: i, Hi: 2, Lo: 0, Value: bitsOf(b, 2, 0)},
+	}
+}
+
+// Whole labels all of byte b at index i as the field name, such as
+// "disp-lo" or "data-8".
+func Whole(name string, i int, b byte) Field {
+	return Field{Name: name, Byte: i, Hi: 7, Lo: 0, Value: b}
+}
+
+// ByteFields returns the fields that lie in byte i, most significant first.
+func ByteFields(fields []Field, i int) []Field {
+	var res []Field
+	for _, f := range fields {
+		if f.Byte == i {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// Describe renders the fields of byte i as its bits grouped by field,
+// followed by the field names and values:
+//
+//	100010 0 1   opcode=100010 d=0 w=1
+func Describe(fields []Field, i int) string {
+	var bits, names []string
+	for _, f := range ByteFields(fields, i) {
+		bits = append(bits, f.Bits())
+		names = append(names, f.Name+"="+f.Bits())
+	}
+	// Eight bits split into at most four fields fit in 11 columns.
+	return fmt.Sprintf("%-11s  %s", strings.Join(bits, " "), strings.Join(names, " "))
+}
@@ -0,0 +1,53 @@
+package fields
+
+import (
+	"strings"
+	"testing"
+)
+
+func names(fields []Field) string {
+	var s []string
+	for _, f := range fields {
+		s = append(s, f.Name+"="+f.Bits())
+	}
+	return strings.Join(s, " ")
+}
+
+func TestOpcode(t *testing.T) {
+	tests := []struct {
+		spec string
+		b    byte
+		want string
+	}{
+		{"100010dw", 0x89, "opcode=100010 d=0 w=1"},
+		{"1011wrrr", 0xb9, "opcode=1011 w=1 reg=001"},
+		{"100000sw", 0x83, "opcode=100000 s=1 w=1"},
+		{"00xxx0dw", 0x2b, "opcode=001010 d=1 w=1"},
+		{"", 0xe8, "opcode=11101000"},
+	}
+
+	for _, tt := range tests {
+		if got := names(Opcode(tt.spec, tt.b)); got != tt.want {
+			t.Errorf("Opcode(%q, %08b): Expected %s, got %s", tt.spec, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	fields := append(Opcode("100010dw", 0x8b), ModRM(1, 0x2e, "reg")...)
+	fields = append(fields, Whole("disp-lo", 2, 0x05), Whole("disp-hi", 3, 0x00))
+
+	tests := []struct {
+		i    int
+		want string
+	}{
+		{0, "100010 1 1   opcode=100010 d=1 w=1"},
+		{1, "00 101 110   mod=00 reg=101 rm=110"},
+		{2, "00000101     disp-lo=00000101"},
+	}
+	for _, tt := range tests {
+		if got := Describe(fields, tt.i); got != tt.want {
+			t.Errorf("byte %d: Expected %q, got %q", tt.i, tt.want, got)
+		}
+	}
+}
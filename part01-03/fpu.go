@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Opcodes of the 8087 interface. ESC hands the instruction to the
+// coprocessor, WAIT stalls the 8086 until the coprocessor is idle.
+const (
+	opEscFirst = 0b11011000 // ESC 0, D8
+	opEscLast  = 0b11011111 // ESC 7, DF
+	opWait     = 0b10011011 // WAIT, 9B
+)
+
+// isEscOp determines if the given byte is one of the ESC opcodes D8-DF.
+func isEscOp(b byte) bool {
+	return b >= opEscFirst && b <= opEscLast
+}
+
+// fpuOperation names an x87 instruction with a memory operand of size bytes.
+// A zero size is printed without a size keyword.
+type fpuOperation struct {
+	name string
+	size int
+}
+
+// fpuMemoryOps is indexed by the low 3 bits of the ESC opcode and the reg
+// field for memory forms. Empty names are invalid encodings.
+var fpuMemoryOps = [8][8]fpuOperation{
+	// D8: arithmetic on a 32 bit real.
+	{{"fadd", 4}, {"fmul", 4}, {"fcom", 4}, {"fcomp", 4}, {"fsub", 4}, {"fsubr", 4}, {"fdiv", 4}, {"fdivr", 4}},
+	// D9: 32 bit real loads and stores, environment and control word.
+	{{"fld", 4}, {}, {"fst", 4}, {"fstp", 4}, {"fldenv", 0}, {"fldcw", 0}, {"fnstenv", 0}, {"fnstcw", 0}},
+	// DA: arithmetic on a 32 bit integer.
+	{{"fiadd", 4}, {"fimul", 4}, {"ficom", 4}, {"ficomp", 4}, {"fisub", 4}, {"fisubr", 4}, {"fidiv", 4}, {"fidivr", 4}},
+	// DB: 32 bit integer and 80 bit real loads and stores.
+	{{"fild", 4}, {}, {"fist", 4}, {"fistp", 4}, {}, {"fld", 10}, {}, {"fstp", 10}},
+	// DC: arithmetic on a 64 bit real.
+	{{"fadd", 8}, {"fmul", 8}, {"fcom", 8}, {"fcomp", 8}, {"fsub", 8}, {"fsubr", 8}, {"fdiv", 8}, {"fdivr", 8}},
+	// DD: 64 bit real loads and stores, state and status word.
+	{{"fld", 8}, {}, {"fst", 8}, {"fstp", 8}, {"frstor", 0}, {}, {"fnsave", 0}, {"fnstsw", 0}},
+	// DE: arithmetic on a 16 bit integer.
+	{{"fiadd", 2}, {"fimul", 2}, {"ficom", 2}, {"ficomp", 2}, {"fisub", 2}, {"fisubr", 2}, {"fidiv", 2}, {"fidivr", 2}},
+	// DF: 16 and 64 bit integer and packed BCD loads and stores.
+	{{"fild", 2}, {}, {"fist", 2}, {"fistp", 2}, {"fbld", 10}, {"fild", 8}, {"fbstp", 10}, {"fistp", 8}},
+}
+
+// fpuRegisterForm is the operand layout of a register form.
+type fpuRegisterForm uint8
+
+const (
+	fpuNone   fpuRegisterForm = iota // No operands.
+	fpuST0STi                        // st0, st(i)
+	fpuSTiST0                        // st(i), st0
+	fpuSTi                           // st(i)
+	fpuAX                            // ax
+)
+
+// fpuRegisterOp is an x87 instruction with register operands.
+type fpuRegisterOp struct {
+	name   string
+	layout fpuRegisterForm
+}
+
+// fpuArithmetic lists the D8, DC and DE register forms by reg field. The
+// destination is st0 for D8 and st(i) for DC and DE. With st(i) as the
+// destination the sub and div encodings are swapped relative to D8.
+var (
+	fpuArithmeticD8 = [8]fpuRegisterOp{{"fadd", fpuST0STi}, {"fmul", fpuST0STi}, {"fcom", fpuSTi}, {"fcomp", fpuSTi}, {"fsub", fpuST0STi}, {"fsubr", fpuST0STi}, {"fdiv", fpuST0STi}, {"fdivr", fpuST0STi}}
+	fpuArithmeticDC = [8]fpuRegisterOp{{"fadd", fpuSTiST0}, {"fmul", fpuSTiST0}, {}, {}, {"fsubr", fpuSTiST0}, {"fsub", fpuSTiST0}, {"fdivr", fpuSTiST0}, {"fdiv", fpuSTiST0}}
+	fpuArithmeticDE = [8]fpuRegisterOp{{"faddp", fpuSTiST0}, {"fmulp", fpuSTiST0}, {}, {}, {"fsubrp", fpuSTiST0}, {"fsubp", fpuSTiST0}, {"fdivrp", fpuSTiST0}, {"fdivp", fpuSTiST0}}
+)
+
+// fpuD9Functions lists the D9 register forms with reg 100 to 111 by rm.
+// fsin, fcos, fsincos and fprem1 arrived with the 80387 but are decoded so
+// that later kernels can be read too. minFPU keeps them from running on an
+// 8087.
+var fpuD9Functions = map[byte][8]string{
+	0b100: {"fchs", "fabs", "", "", "ftst", "fxam", "", ""},
+	0b101: {"fld1", "fldl2t", "fldl2e", "fldpi", "fldlg2", "fldln2", "fldz", ""},
+	0b110: {"f2xm1", "fyl2x", "fptan", "fpatan", "fxtract", "fprem1", "fdecstp", "fincstp"},
+	0b111: {"fprem", "fyl2xp1", "fsqrt", "fsincos", "frndint", "fscale", "fsin", "fcos"},
+}
+
+// fpuDBControl lists the DB E0-E4 control instructions. fneni and fndisi
+// only do something on the 8087, fnsetpm only on the 80287.
+var fpuDBControl = [8]string{"fneni", "fndisi", "fnclex", "fninit", "fnsetpm", "", "", ""}
+
+// fpuModel selects the coprocessor the simulator attaches. Later models
+// execute everything earlier ones do.
+type fpuModel uint8
+
+const (
+	fpu8087 fpuModel = iota
+	fpu80287
+	fpu80387
+)
+
+var fpuModelNames = []string{"8087", "80287", "80387"}
+
+func (m fpuModel) String() string {
+	return fpuModelNames[m]
+}
+
+// Set implements flag.Value.
+func (m *fpuModel) Set(s string) error {
+	for i, name := range fpuModelNames {
+		if s == name {
+			*m = fpuModel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fpu %q, expected 8087, 80287 or 80387", s)
+}
+
+// minFPU maps the register form mnemonics added after the 8087 to the first
+// coprocessor that executes them.
+var minFPU = map[string]fpuModel{
+	"fnsetpm": fpu80287,
+	"fnstsw":  fpu80287, // fnstsw ax; the memory form is an 8087 instruction.
+	"fsin":    fpu80387,
+	"fcos":    fpu80387,
+	"fsincos": fpu80387,
+	"fprem1":  fpu80387,
+	"fucom":   fpu80387,
+	"fucomp":  fpu80387,
+	"fucompp": fpu80387,
+}
+
+// esc is an 8087 coprocessor instruction. The 8086 only computes the
+// address of a memory operand and leaves the rest to the coprocessor.
+type esc struct {
+	op uint8 // First byte, D8-DF.
+	common
+}
+
+// registerOp returns the register form selected by the reg and rm fields.
+func (e *esc) registerOp() (fpuRegisterOp, bool) {
+	var op fpuRegisterOp
+	switch e.op {
+	case 0xd8:
+		op = fpuArithmeticD8[e.reg]
+	case 0xd9:
+		switch e.reg {
+		case 0b000:
+			op = fpuRegisterOp{"fld", fpuSTi}
+		case 0b001:
+			op = fpuRegisterOp{"fxch", fpuSTi}
+		case 0b010:
+			if e.rm == 0 {
+				op = fpuRegisterOp{"fnop", fpuNone}
+			}
+		default:
+			if names, ok := fpuD9Functions[e.reg]; ok {
+				op = fpuRegisterOp{names[e.rm], fpuNone}
+			}
+		}
+	case 0xda:
+		if e.reg == 0b101 && e.rm == 1 {
+			op = fpuRegisterOp{"fucompp", fpuNone} // 80387.
+		}
+	case 0xdb:
+		if e.reg == 0b100 {
+			op = fpuRegisterOp{fpuDBControl[e.rm], fpuNone}
+		}
+	case 0xdc:
+		op = fpuArithmeticDC[e.reg]
+	case 0xdd:
+		names := [8]string{"ffree", "", "fst", "fstp", "fucom", "fucomp", "", ""} // fucom and fucomp are 80387.
+		op = fpuRegisterOp{names[e.reg], fpuSTi}
+	case 0xde:
+		op = fpuArithmeticDE[e.reg]
+		if e.reg == 0b011 && e.rm == 1 {
+			op = fpuRegisterOp{"fcompp", fpuNone}
+		}
+	case 0xdf:
+		if e.reg == 0b100 && e.rm == 0 {
+			op = fpuRegisterOp{"fnstsw", fpuAX} // 80287.
+		}
+	}
+	return op, op.name != ""
+}
+
+// memoryOp returns the memory form selected by the reg field.
+func (e *esc) memoryOp() (fpuOperation, bool) {
+	op := fpuMemoryOps[e.op&0b111][e.reg]
+	return op, op.name != ""
+}
+
+// stRegister returns the name of x87 stack register i.
+func stRegister(i byte) string {
+	return fmt.Sprintf("st%d", i)
+}
+
+// isSTRegister reports whether name is an x87 stack register and which.
+func isSTRegister(name string) (int, bool) {
+	if len(name) == 3 && strings.HasPrefix(name, "st") && name[2] >= '0' && name[2] <= '7' {
+		return int(name[2] - '0'), true
+	}
+	return 0, false
+}
+
+func (e *esc) disassemble() string {
+	return nasm.print(e.form())
+}
+
+func (e *esc) form() form {
+	if e.mod != 0b11 {
+		op, _ := e.memoryOp()
+		return form{mnemonic: op.name, operands: []operand{e.rmOperand(true)}, size: op.size, fpu: true}
+	}
+
+	op, _ := e.registerOp()
+	f := form{mnemonic: op.name, fpu: true}
+	sti := registerOperand(stRegister(e.rm))
+	st0 := registerOperand(stRegister(0))
+	switch op.layout {
+	case fpuST0STi:
+		f.operands = []operand{st0, sti}
+	case fpuSTiST0:
+		f.operands = []operand{sti, st0}
+		f.stDestination = true
+	case fpuSTi:
+		f.operands = []operand{sti}
+	case fpuAX:
+		f.operands = []operand{registerOperand("ax")}
+	}
+	return f
+}
+
+// wait is the WAIT instruction, which assemblers also emit as the prefix
+// that turns fnstsw into fstsw and so on.
+type wait struct{}
+
+func (w *wait) disassemble() string {
+	return nasm.print(w.form())
+}
+
+func (w *wait) form() form {
+	return form{mnemonic: "wait"}
+}
+
+// decodeEsc decodes an ESC instruction from a stream of bytes provided by a
+// peekableByteReader.
+func decodeEsc(r *peekableByteReader) *esc {
+	instruction := esc{op: r.readByte()}
+	instruction.common = decodeCommon(r)
+
+	var ok bool
+	if instruction.mod == 0b11 {
+		_, ok = instruction.registerOp()
+	} else {
+		_, ok = instruction.memoryOp()
+	}
+	if !ok {
+		r.fail(fmt.Errorf("unsupported 8087 instruction: %08b mod=%02b reg=%03b rm=%03b", instruction.op, instruction.mod, instruction.reg, instruction.rm))
+	}
+	return &instruction
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestDecodeEsc(t *testing.T) {
+	tests := []struct {
+		input []byte
+		nasm  string
+		masm  string
+		att   string
+	}{
+		{[]byte{0xd9, 0x07}, "fld dword [bx]", "fld dword ptr [bx]", "flds (%bx)"},
+		{[]byte{0xdd, 0x47, 0x08}, "fld qword [bx + 8]", "fld qword ptr [bx+8]", "fldl 8(%bx)"},
+		{[]byte{0xdb, 0x2f}, "fld tword [bx]", "fld tbyte ptr [bx]", "fldt (%bx)"},
+		{[]byte{0xdd, 0x1e, 0x00, 0x02}, "fstp qword [512]", "fstp qword ptr ds:[512]", "fstpl 512"},
+		{[]byte{0xde, 0x07}, "fiadd word [bx]", "fiadd word ptr [bx]", "fiadds (%bx)"},
+		{[]byte{0xdf, 0x2f}, "fild qword [bx]", "fild qword ptr [bx]", "fildll (%bx)"},
+		{[]byte{0xdf, 0x37}, "fbstp tword [bx]", "fbstp tbyte ptr [bx]", "fbstp (%bx)"},
+		{[]byte{0xd9, 0x3e, 0x00, 0x01}, "fnstcw [256]", "fnstcw ds:[256]", "fnstcw 256"},
+		{[]byte{0xd8, 0xc1}, "fadd st0, st1", "fadd st, st(1)", "fadd %st(1), %st"},
+		{[]byte{0xdc, 0xc1}, "fadd st1, st0", "fadd st(1), st", "fadd %st, %st(1)"},
+		{[]byte{0xd8, 0xe1}, "fsub st0, st1", "fsub st, st(1)", "fsub %st(1), %st"},
+		{[]byte{0xde, 0xe9}, "fsubp st1, st0", "fsubp st(1), st", "fsubrp %st, %st(1)"},
+		{[]byte{0xdc, 0xf9}, "fdiv st1, st0", "fdiv st(1), st", "fdivr %st, %st(1)"},
+		{[]byte{0xd8, 0xd1}, "fcom st1", "fcom st(1)", "fcom %st(1)"},
+		{[]byte{0xde, 0xd9}, "fcompp", "fcompp", "fcompp"},
+		{[]byte{0xd9, 0xc9}, "fxch st1", "fxch st(1)", "fxch %st(1)"},
+		{[]byte{0xdd, 0xd9}, "fstp st1", "fstp st(1)", "fstp %st(1)"},
+		{[]byte{0xd9, 0xfa}, "fsqrt", "fsqrt", "fsqrt"},
+		{[]byte{0xd9, 0xfe}, "fsin", "fsin", "fsin"},
+		{[]byte{0xd9, 0xeb}, "fldpi", "fldpi", "fldpi"},
+		{[]byte{0xdb, 0xe3}, "fninit", "fninit", "fninit"},
+		{[]byte{0xdf, 0xe0}, "fnstsw ax", "fnstsw ax", "fnstsw %ax"},
+		{[]byte{0x9b}, "wait", "wait", "wait"},
+	}
+
+	printers := []printer{nasmPrinter{}, masmPrinter{}, attPrinter{}}
+	for _, tt := range tests {
+		d, err := decodeAt(tt.input, 0, cpu8086)
+		if err != nil {
+			t.Errorf("%x: %v", tt.input, err)
+			continue
+		}
+		if d.size != len(tt.input) {
+			t.Errorf("%x: decoded %d bytes, want %d", tt.input, d.size, len(tt.input))
+		}
+		for i, want := range []string{tt.nasm, tt.masm, tt.att} {
+			if got := printers[i].print(d.instr.form()); got != want {
+				t.Errorf("%x: Expected %q, got %q", tt.input, want, got)
+			}
+		}
+		if _, err := layoutFields(d, tt.input); err != nil {
+			t.Errorf("%x: labelling fields: %v", tt.input, err)
+		}
+	}
+}
+
+func TestDecodeEscRejectsInvalidForms(t *testing.T) {
+	for _, input := range [][]byte{{0xd9, 0x0f}, {0xd9, 0xd1}, {0xdb, 0xe7}} {
+		if _, err := decodeAt(input, 0, cpu8086); err == nil {
+			t.Errorf("%x: expected an error", input)
+		}
+	}
+}
+
+// hypotenuse computes sqrt(3*3 + 4*4) into [516] and compares it with 1,
+// using only 8087 instructions.
+var hypotenuse = []byte{
+	0xc7, 0x06, 0x00, 0x02, 0x03, 0x00, // mov word [512], 3
+	0xc7, 0x06, 0x02, 0x02, 0x04, 0x00, // mov word [514], 4
+	0xdf, 0x06, 0x00, 0x02, // fild word [512]
+	0xd8, 0xc8, // fmul st0, st0
+	0xdf, 0x06, 0x02, 0x02, // fild word [514]
+	0xd8, 0xc8, // fmul st0, st0
+	0xde, 0xc1, // faddp st1, st0
+	0xd9, 0xfa, // fsqrt
+	0xdd, 0x16, 0x04, 0x02, // fst qword [516]
+	0xd9, 0xe8, // fld1
+	0xde, 0xd9, // fcompp
+	0xdd, 0x3e, 0x0c, 0x02, // fnstsw [524]
+	0x9b,             // wait
+	0xa1, 0x0c, 0x02, // mov ax, [524]
+}
+
+func TestSimulateX87(t *testing.T) {
+	c := newCPU()
+	c.fpu = newX87(fpu8087)
+	c.load(hypotenuse)
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(c.mem[516:])); got != 5 {
+		t.Errorf("[516] = %v, want 5", got)
+	}
+	// 1 < 5 sets C0, and the two pops leave TOP back at 0.
+	if got := c.regs[regAX]; got != x87C0 {
+		t.Errorf("ax = %#04x, want %#04x", got, x87C0)
+	}
+	if got := c.fpu.tagWord(); got != 0xffff {
+		t.Errorf("tag word = %#04x, want an empty stack", got)
+	}
+}
+
+func TestSimulateWithoutX87IgnoresEsc(t *testing.T) {
+	c := newCPU()
+	c.load(hypotenuse)
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := binary.LittleEndian.Uint64(c.mem[516:]); got != 0 {
+		t.Errorf("[516] = %#x, want it untouched", got)
+	}
+}
+
+func TestSimulateX87Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+	}{
+		{"underflow", []byte{0xd9, 0xfa}},                             // fsqrt
+		{"negative sqrt", []byte{0xd9, 0xe8, 0xd9, 0xe0, 0xd9, 0xfa}}, // fld1, fchs, fsqrt
+		{"zero by zero", []byte{0xd9, 0xee, 0xd9, 0xee, 0xde, 0xf9}},  // fldz, fldz, fdivp
+		{"overflow", bytes.Repeat([]byte{0xd9, 0xe8}, 9)},             // fld1 nine times
+	}
+
+	for _, tt := range tests {
+		c := newCPU()
+		c.fpu = newX87(fpu8087)
+		c.load(tt.program)
+		var err error
+		for err == nil && !c.halted() {
+			_, err = c.step()
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+// TestX87StoreToEmptyRegister checks that fst and fstp accept an empty
+// destination, which they only write.
+func TestX87StoreToEmptyRegister(t *testing.T) {
+	c := newCPU()
+	c.fpu = newX87(fpu8087)
+	c.load([]byte{
+		0xd9, 0xe8, // fld1
+		0xdd, 0xd2, // fst st(2)
+		0xdd, 0xd9, // fstp st(1)
+	})
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st0, err := c.fpu.st(0); err != nil || st0.Cmp(big.NewFloat(1)) != 0 {
+		t.Errorf("st0 = %v, %v, want 1", st0, err)
+	}
+	if st1, err := c.fpu.st(1); err != nil || st1.Cmp(big.NewFloat(1)) != 0 {
+		t.Errorf("st1 = %v, %v, want 1 from fst st(2)", st1, err)
+	}
+	if _, err := c.fpu.st(2); err == nil {
+		t.Error("st2 is not empty after the pop")
+	}
+}
+
+func TestTempReal(t *testing.T) {
+	// fldpi rounds pi to 64 significant bits: C90FDAA22168C234C4 rounds up.
+	c := newCPU()
+	c.fpu = newX87(fpu8087)
+	c.load([]byte{0xd9, 0xeb, 0xdb, 0x3e, 0x00, 0x01}) // fldpi, fstp tword [256]
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []byte{0x35, 0xc2, 0x68, 0x21, 0xa2, 0xda, 0x0f, 0xc9, 0x00, 0x40}
+	if got := c.mem[256:266]; !bytes.Equal(got, want) {
+		t.Errorf("pi = % x, want % x", got, want)
+	}
+
+	for _, v := range []float64{1, -0.1, 1e300, 5e-324, math.Inf(-1)} {
+		x := newFloat().SetFloat64(v)
+		got, err := readTempReal(tempReal(x))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Cmp(x) != 0 {
+			t.Errorf("%v round trips to %v", v, got)
+		}
+	}
+}
+
+func TestX87IntegerStores(t *testing.T) {
+	tests := []struct {
+		v    float64
+		size int
+		want int64
+	}{
+		{2.5, 2, 2},
+		{3.5, 2, 4},
+		{-2.5, 2, -2},
+		{-2.6, 4, -3},
+		{32767.4, 2, 32767},
+		{32767.5, 2, -32768}, // Rounds out of range: integer indefinite.
+		{1e19, 8, math.MinInt64},
+	}
+
+	for _, tt := range tests {
+		b := integerBytes(newFloat().SetFloat64(tt.v), tt.size)
+		got, _ := readInteger(b).Int64()
+		if got != tt.want {
+			t.Errorf("%v as %d bytes = %d, want %d", tt.v, tt.size, got, tt.want)
+		}
+	}
+
+	bcd := bcdBytes(newFloat().SetInt64(-1234))
+	if want := []byte{0x34, 0x12, 0, 0, 0, 0, 0, 0, 0, 0x80}; !bytes.Equal(bcd, want) {
+		t.Errorf("bcd = % x, want % x", bcd, want)
+	}
+	if got, _ := readBCD(bcd).Int64(); got != -1234 {
+		t.Errorf("bcd reads back as %d", got)
+	}
+}
+
+func TestX87Remainder(t *testing.T) {
+	r, q := remainder(newFloat().SetInt64(17), newFloat().SetInt64(5), false)
+	if v, _ := r.Int64(); v != 2 || q != 3 {
+		t.Errorf("fprem 17, 5 = %v quotient bits %b, want 2 and 11", r, q)
+	}
+	r, _ = remainder(newFloat().SetInt64(18), newFloat().SetInt64(5), true)
+	if v, _ := r.Int64(); v != -2 {
+		t.Errorf("fprem1 18, 5 = %v, want -2", r)
+	}
+}
+
+func TestSnapshotKeepsX87(t *testing.T) {
+	c := newCPU()
+	c.fpu = newX87(fpu80387)
+	if err := c.fpu.push(big.NewFloat(2.25)); err != nil {
+		t.Fatal(err)
+	}
+	c.fpu.control = 0x037f
+
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.fpu == nil {
+		t.Fatal("snapshot lost the coprocessor")
+	}
+	if got.fpu.model != fpu80387 {
+		t.Errorf("restored model = %v, want %v", got.fpu.model, fpu80387)
+	}
+	if got.fpu.control != 0x037f || got.fpu.statusWord() != c.fpu.statusWord() || got.fpu.tagWord() != c.fpu.tagWord() {
+		t.Errorf("restored control, status, tag = %#x, %#x, %#x", got.fpu.control, got.fpu.statusWord(), got.fpu.tagWord())
+	}
+	if st0, err := got.fpu.st(0); err != nil || st0.Cmp(big.NewFloat(2.25)) != 0 {
+		t.Errorf("restored st0 = %v, %v, want 2.25", st0, err)
+	}
+}
+
+// TestX87Transcendentals checks that the transcendental instructions round
+// their results to the full 64 bit significand, not to float64.
+func TestX87Transcendentals(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		st1     string // Pushed first, if set.
+		st0     string
+		want    []string // The stack from st0 after the instruction.
+	}{
+		{"fsin", []byte{0xd9, 0xfe}, "", "1", []string{"0.84147098480789650665250232163029899962256306079837"}},
+		{"fsin reduced", []byte{0xd9, 0xfe}, "", "4611686018427387904", []string{"-0.70292244361920887641538155784702383217886777076311"}},
+		{"fcos", []byte{0xd9, 0xff}, "", "1", []string{"0.54030230586813971740093660744297660373231042061792"}},
+		{"fsincos", []byte{0xd9, 0xfb}, "", "1", []string{"0.54030230586813971740093660744297660373231042061792", "0.84147098480789650665250232163029899962256306079837"}},
+		{"fptan", []byte{0xd9, 0xf2}, "", "0.5", []string{"1", "0.54630248984379051325517946578028538329755172017979"}},
+		{"f2xm1", []byte{0xd9, 0xf0}, "", "0.5", []string{"0.41421356237309504880168872420969807856967187537695"}},
+		{"fyl2x", []byte{0xd9, 0xf1}, "1", "3", []string{"1.58496250072115618145373894394781650875981440769248"}},
+		{"fyl2xp1", []byte{0xd9, 0xf9}, "1", "9.094947017729282379150390625e-13", []string{"1.3121234959619935994960031017850191710121890821179e-12"}},
+		{"fpatan", []byte{0xd9, 0xf3}, "1", "1", []string{"0.78539816339744830961566084581987572104929234984378"}},
+		{"fpatan second quadrant", []byte{0xd9, 0xf3}, "1", "-1", []string{"2.3561944901923449288469825374596271631478770495313"}},
+	}
+
+	parse := func(s string) *big.Float {
+		v, _, err := newFloat().Parse(s, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tt := range tests {
+		c := newCPU()
+		c.fpu = newX87(fpu80387)
+		if tt.st1 != "" {
+			if err := c.fpu.push(parse(tt.st1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.fpu.push(parse(tt.st0)); err != nil {
+			t.Fatal(err)
+		}
+		c.load(tt.program)
+		if _, err := c.step(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for i, want := range tt.want {
+			got, err := c.fpu.st(byte(i))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if got.Cmp(parse(want)) != 0 {
+				t.Errorf("%s: st%d = %s, want %s", tt.name, i, got.Text('g', 22), parse(want).Text('g', 22))
+			}
+		}
+	}
+}
+
+func TestX87SinOutOfRange(t *testing.T) {
+	c := newCPU()
+	c.fpu = newX87(fpu80387)
+	huge := newFloat().SetMantExp(big.NewFloat(1), 64)
+	if err := c.fpu.push(huge); err != nil {
+		t.Fatal(err)
+	}
+	c.load([]byte{0xd9, 0xfe}) // fsin
+	if _, err := c.step(); err != nil {
+		t.Fatal(err)
+	}
+	if st0, _ := c.fpu.st(0); st0.Cmp(huge) != 0 || c.fpu.status&x87C2 == 0 {
+		t.Errorf("st0 = %v, C2 = %v, want the operand kept and C2 set", st0, c.fpu.status&x87C2 != 0)
+	}
+}
+
+func TestX87RejectsLaterInstructions(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		need    fpuModel
+	}{
+		{"fnstsw ax", []byte{0xdf, 0xe0}, fpu80287},
+		{"fsin", []byte{0xd9, 0xe8, 0xd9, 0xfe}, fpu80387},               // fld1, fsin
+		{"fprem1", []byte{0xd9, 0xe8, 0xd9, 0xe8, 0xd9, 0xf5}, fpu80387}, // fld1, fld1, fprem1
+	}
+
+	for _, tt := range tests {
+		for model := fpu8087; model <= fpu80387; model++ {
+			c := newCPU()
+			c.fpu = newX87(model)
+			c.load(tt.program)
+			var err error
+			for err == nil && !c.halted() {
+				_, err = c.step()
+			}
+			if got, want := err != nil, model < tt.need; got != want {
+				t.Errorf("%s on the %s: err = %v, want an error: %v", tt.name, model, err, want)
+			}
+		}
+	}
+}
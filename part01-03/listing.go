@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ahrav/perf-aware-programming/part01-03/fields"
+)
+
+// listingHexWidth is the width of the raw bytes column, wide enough for a
+// six byte instruction.
+const listingHexWidth = 18
+
+// hexBytes renders b as space separated hex bytes.
+func hexBytes(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02x", v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeListingLine writes the offset, raw bytes and text of one instruction
+// or data run.
+func writeListingLine(output *bytes.Buffer, off int, raw []byte, text string) {
+	fmt.Fprintf(output, "%04x  %-*s  %s\n", off, listingHexWidth, hexBytes(raw), text)
+}
+
+// writeInstruction writes the listing line of d, whose bytes are raw. With
+// withFields set it is followed by one line per byte labelling its encoding
+// fields.
+func writeInstruction(output *bytes.Buffer, d decodedInstruction, raw []byte, text string, withFields bool) error {
+	writeListingLine(output, d.offset, raw, text)
+	if !withFields {
+		return nil
+	}
+
+	layout, err := layoutFields(d, raw)
+	if err != nil {
+		return fmt.Errorf("labelling fields at %#04x: %w", d.offset, err)
+	}
+	for i := range raw {
+		fmt.Fprintf(output, "      %02x  %s\n", raw[i], fields.Describe(layout, i))
+	}
+	return nil
+}
+
+// listFile disassembles b by linear sweep into a listing of file offsets,
+// raw bytes and decoded text printed with p. The data ranges of symbols are
+// listed as data.
+func listFile(b []byte, p printer, model cpuModel, symbols *symbolTable, withFields bool) (string, error) {
+	if symbols != nil {
+		cm, err := sweep(b, model, symbols)
+		if err != nil {
+			return "", err
+		}
+		return cm.list(p, withFields)
+	}
+
+	var output bytes.Buffer
+	for off := 0; off < len(b); {
+		d, err := decodeAt(b, off, model)
+		if err != nil {
+			return "", err
+		}
+		if err := writeInstruction(&output, d, b[off:d.next()], p.print(d.instr.form()), withFields); err != nil {
+			return "", err
+		}
+		off = d.next()
+	}
+	return output.String(), nil
+}
+
+// listRecursive is listFile for a recursive traversal from entries. Bytes
+// that are not reached as code are listed as data.
+func listRecursive(b []byte, entries []int, p printer, model cpuModel, symbols *symbolTable, withFields bool) (string, error) {
+	if err := checkEntries(b, entries); err != nil {
+		return "", err
+	}
+	return traverse(b, entries, model, symbols).list(p, withFields)
+}
+
+// list renders the code map as a listing with p.
+func (cm *codeMap) list(p printer, withFields bool) (string, error) {
+	labels := cm.labels()
+
+	var output bytes.Buffer
+	for off := 0; off < len(cm.image); {
+		if name, ok := labels[off]; ok {
+			fmt.Fprintf(&output, "%s:\n", name)
+		}
+
+		if d, ok := cm.instrs[off]; ok {
+			if err := writeInstruction(&output, d, cm.image[off:d.next()], cm.text(p, d, labels), withFields); err != nil {
+				return "", err
+			}
+			off = d.next()
+			continue
+		}
+
+		end, size := cm.dataRun(off, labels)
+		writeListingLine(&output, off, cm.image[off:end], p.data(cm.image[off:end], size))
+		off = end
+	}
+	return output.String(), nil
+}
+
+// writeFields walks the instructions in b, which starts at file offset base,
+// and writes every byte with its encoding fields labelled. Bytes that do not
+// start an instruction of model are reported and skipped one at a time.
+func writeFields(w io.Writer, b []byte, base int64, model cpuModel) error {
+	bw := bufio.NewWriter(w)
+	for off := 0; off < len(b); {
+		d, err := decodeAt(b, off, model)
+		var layout []fields.Field
+		if err == nil {
+			layout, err = layoutFields(d, b[off:d.next()])
+		}
+		if err != nil {
+			fmt.Fprintf(bw, "%08x  %02x  %v\n", base+int64(off), b[off], err)
+			off++
+			continue
+		}
+		for i := 0; i < d.size; i++ {
+			fmt.Fprintf(bw, "%08x  %02x  %s\n", base+int64(off+i), b[off+i], fields.Describe(layout, i))
+		}
+		off = d.next()
+	}
+	return bw.Flush()
+}
+
+// runFields labels the encoding fields of the instructions in a byte range
+// of a binary.
+func runFields(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fields", flag.ExitOnError)
+	offset := fs.Int64("offset", 0, "start at byte `n` of the file")
+	length := fs.Int64("length", 0, "label at most `n` bytes (0 reads to the end of the file)")
+	model := cpu8086
+	fs.Var(&model, "cpu", "instruction set to decode: 8086, 80186 or 80286-real")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: fields [-offset n] [-length n] [-cpu model] <binary>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected 1 binary, got %d", fs.NArg())
+	}
+
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *offset < 0 || *offset > int64(len(b)) {
+		return fmt.Errorf("offset %#x is outside the %d byte file", *offset, len(b))
+	}
+	if *length < 0 {
+		return fmt.Errorf("negative length %d", *length)
+	}
+	b = b[*offset:]
+	if *length > 0 && *length < int64(len(b)) {
+		b = b[:*length]
+	}
+	return writeFields(out, b, *offset, model)
+}
@@ -2,33 +2,330 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"golang.org/x/exp/constraints"
 )
 
 func main() {
-	if len(os.Args) == 1 {
-		fmt.Println("dissasebling: ", os.Args[0])
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tracediff":
+			diverged, err := runTraceDiff(os.Args[2:], os.Stdout)
+			if err != nil {
+				log.Fatalf("error diffing traces: %v", err)
+			}
+			if diverged {
+				os.Exit(1)
+			}
+			return
+		case "cfg":
+			if err := runCFG(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("error building control-flow graph: %v", err)
+			}
+			return
+		case "diff":
+			differ, err := runDiff(os.Args[2:], os.Stdout)
+			if err != nil {
+				log.Fatalf("error diffing binaries: %v", err)
+			}
+			if differ {
+				os.Exit(1)
+			}
+			return
+		case "vectors":
+			failed, err := runVectors(os.Args[2:], os.Stdout)
+			if err != nil {
+				log.Fatalf("error running test vectors: %v", err)
+			}
+			if failed {
+				os.Exit(1)
+			}
+			return
+		case "fields":
+			if err := runFields(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("error labelling fields: %v", err)
+			}
+			return
+		case "symbols":
+			if err := runSymbols(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("error building symbol skeleton: %v", err)
+			}
+			return
+		}
+	}
+
+	var (
+		execute      = flag.Bool("exec", false, "simulate the program instead of disassembling it")
+		tracePath    = flag.String("trace", "", "write a JSON Lines execution trace to `file` (implies -exec)")
+		maxSteps     = flag.Uint64("steps", 0, "stop simulating after `n` instructions (0 runs until IP leaves the program)")
+		loadSnapshot = flag.String("load-snapshot", "", "resume simulation from the snapshot in `file` (implies -exec)")
+		saveSnapshot = flag.String("save-snapshot", "", "write the simulator state to `file` when simulation stops (implies -exec)")
+		quiet        = flag.Bool("quiet", false, "do not print the per-instruction log while simulating")
+		profileRun   = flag.Bool("profile", false, "report hot instructions, basic block totals and loop counts after simulating (implies -exec)")
+		annotate     = flag.Bool("annotate", false, "print the disassembly annotated with execution counts and clocks after simulating (implies -exec)")
+		recursive    = flag.Bool("recursive", false, "disassemble by recursive traversal from the entry points, emitting unreached bytes as data")
+		listing      = flag.Bool("listing", false, "print each instruction's file offset and raw bytes next to its text")
+		showFields   = flag.Bool("fields", false, "label the encoding fields of every instruction byte (implies -listing)")
+		syntax       = flag.String("syntax", "nasm", "assembler `syntax` of the disassembly: nasm, masm (Intel manual style) or att")
+		hexNumbers   = flag.Bool("hex", false, "print immediates and displacements in hex")
+		upperCase    = flag.Bool("upper", false, "print mnemonics, registers and keywords in upper case")
+		bench        = flag.Bool("bench", false, "rerun the program without logging for at least a second and report simulated instructions per second and MHz (implies -exec)")
+		coprocessor  = flag.Bool("8087", false, "attach a simulated 8087 that executes ESC instructions, which are ignored otherwise")
+		fpu          = fpu8087
+		symbolsPath  = flag.String("symbols", "", "name addresses and declare data ranges from the symbol `file` in disassembly, logs and traces")
+		entries      entryList
+		model        = cpu8086
+	)
+	flag.Var(&model, "cpu", "instruction set to decode and simulate: 8086, 80186 or 80286-real")
+	flag.Var(&fpu, "fpu", "attach a simulated coprocessor of this `model`, 8087, 80287 or 80387, like -8087")
+	flag.Var(&entries, "entry", "extra comma separated entry point `offsets` for -recursive, in addition to 0")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <binary>\n       %s -load-snapshot <file> [flags]\n       %s tracediff [-context n] <trace> <reference>\n       %s cfg [-format dot|json] [-entry offsets] [-cpu model] <binary>\n       %s symbols [-entry offsets] [-cpu model] [-symbols file] <binary>\n       %s diff [-recursive] [-cpu model] <old binary> <new binary>\n       %s vectors [-limit n] [-show n] [-opcodes list] <directory>\n       %s fields [-offset n] [-length n] [-cpu model] <binary>\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 && (*loadSnapshot == "" || flag.NArg() != 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var symbols *symbolTable
+	if *symbolsPath != "" {
+		var err error
+		if symbols, err = readSymbolFile(*symbolsPath); err != nil {
+			log.Fatalf("error reading symbols: %v", err)
+		}
+	}
+
+	if *bench && (*tracePath != "" || *saveSnapshot != "" || *profileRun || *annotate) {
+		log.Fatal("-bench cannot be combined with -trace, -save-snapshot, -profile or -annotate")
+	}
+
+	popts := printOptions{hex: *hexNumbers, upper: *upperCase, cpu: model, fpu: fpu}
+	p, err := newPrinter(*syntax, popts)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	b, err := os.ReadFile(os.Args[1])
+	if *execute || *tracePath != "" || *loadSnapshot != "" || *saveSnapshot != "" || *profileRun || *annotate || *bench {
+		c, err := newSimulation(flag.Arg(0), *loadSnapshot)
+		if err != nil {
+			log.Fatalf("error loading program: %v", err)
+		}
+		// A snapshot keeps the model it was taken with unless -cpu overrides it.
+		if *loadSnapshot == "" || flagSet("cpu") {
+			c.model = model
+		}
+		if (*coprocessor || flagSet("fpu")) && c.fpu == nil {
+			c.fpu = newX87(fpu)
+		}
+		if c.fpu != nil && flagSet("fpu") {
+			c.fpu.model = fpu
+		}
+		// Listings name the models the simulation runs, which a snapshot
+		// may have chosen.
+		popts.cpu = c.model
+		if c.fpu != nil {
+			popts.fpu = c.fpu.model
+		}
+		p, _ = newPrinter(*syntax, popts)
+		if *bench {
+			res, err := benchmark(c, *maxSteps, time.Second)
+			if err != nil {
+				log.Fatalf("error simulating file: %v", err)
+			}
+			printRegisters(os.Stdout, c)
+			res.write(os.Stdout)
+			return
+		}
+
+		opts := simOptions{tracePath: *tracePath, maxSteps: *maxSteps, snapshotPath: *saveSnapshot, quiet: *quiet, symbols: symbols, printer: p}
+		if *profileRun || *annotate {
+			opts.profile = newProfile(c.model)
+			opts.profile.symbols = symbols
+			opts.profile.printer = p
+		}
+		if err := simulate(c, opts, os.Stdout); err != nil {
+			log.Fatalf("error simulating file: %v", err)
+		}
+
+		image := c.mem[c.imageStart():c.codeEnd]
+		if *profileRun {
+			fmt.Println()
+			opts.profile.writeReport(os.Stdout, image, c.imageStart(), 20)
+		}
+		if *annotate {
+			fmt.Println()
+			opts.profile.writeAnnotatedListing(os.Stdout, image, c.imageStart())
+		}
+		return
+	}
+
+	b, err := os.ReadFile(flag.Arg(0))
 	if err != nil {
 		log.Fatalf("error reading file: %v", err)
 	}
 
-	res, err := disassembleFile(b)
+	var res string
+	switch {
+	case (*listing || *showFields) && *recursive:
+		res, err = listRecursive(b, append([]int{0}, entries...), p, model, symbols, *showFields)
+	case *listing || *showFields:
+		res, err = listFile(b, p, model, symbols, *showFields)
+	case *recursive:
+		res, err = disassembleRecursive(b, append([]int{0}, entries...), p, model, symbols)
+	default:
+		res, err = disassembleFile(b, p, model, symbols)
+	}
 	if err != nil {
 		log.Fatalf("error disassembling file: %v", err)
 	}
 	fmt.Println(res)
 }
 
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// newSimulation creates a CPU either from a snapshot or with the program in
+// binaryPath loaded at CS:0.
+func newSimulation(binaryPath, snapshotPath string) (*cpu, error) {
+	if snapshotPath != "" {
+		return readSnapshotFile(snapshotPath)
+	}
+
+	b, err := os.ReadFile(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	c := newCPU()
+	c.load(b)
+	return c, nil
+}
+
+// simOptions controls a simulation run.
+type simOptions struct {
+	tracePath    string   // JSON Lines trace output, if set.
+	maxSteps     uint64   // Instruction limit counted from the start of the program, 0 for none.
+	snapshotPath string   // Snapshot written when the simulation stops, if set.
+	quiet        bool     // Suppresses the per-instruction log.
+	profile      *profile // Collects execution counts, if set.
+
+	// symbols names transfer targets and direct memory operands in the log
+	// and trace, if set.
+	symbols *symbolTable
+
+	// printer renders the instructions of the log and trace, NASM if nil.
+	printer printer
+}
+
+// text renders the instruction of res for the log and trace.
+func (opts simOptions) text(c *cpu, res stepResult) string {
+	p := opts.printer
+	if p == nil {
+		p = nasm
+	}
+	if opts.symbols == nil {
+		return p.print(res.instr.form())
+	}
+	d := decodedInstruction{instr: res.instr, offset: int(res.addr - c.imageStart()), size: res.size}
+	return p.print(opts.symbols.form(d))
+}
+
+// simulate executes instructions until IP leaves the program or the step
+// limit is reached, printing a text log of every instruction and the final
+// registers to out.
+func simulate(c *cpu, opts simOptions, out io.Writer) error {
+	var tw *traceWriter
+	if opts.tracePath != "" {
+		f, err := os.Create(opts.tracePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tw = newTraceWriter(f)
+		c.trackWrites = true
+	}
+
+	for !c.halted() && (opts.maxSteps == 0 || c.steps < opts.maxSteps) {
+		before := c.registers
+		res, err := c.step()
+		if err != nil {
+			return err
+		}
+
+		if opts.profile != nil {
+			opts.profile.record(res)
+		}
+		if opts.quiet && tw == nil {
+			continue
+		}
+
+		rec := newTraceRecord(c, before, res, opts.text(c, res))
+		if !opts.quiet {
+			fmt.Fprintln(out, rec)
+		}
+		if tw != nil {
+			if err := tw.write(rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	printRegisters(out, c)
+	if tw != nil {
+		if err := tw.flush(); err != nil {
+			return err
+		}
+	}
+	if opts.snapshotPath != "" {
+		return writeSnapshotFile(opts.snapshotPath, c)
+	}
+	return nil
+}
+
+// printRegisters prints the non-zero registers and the flags.
+func printRegisters(out io.Writer, c *cpu) {
+	fmt.Fprintln(out, "\nFinal registers:")
+	values := c.registers.named()
+	for _, name := range regOrder {
+		if v := values[name]; v != 0 {
+			fmt.Fprintf(out, "      %s: %#04x (%d)\n", name, v, v)
+		}
+	}
+	if c.flags != 0 {
+		fmt.Fprintf(out, "   flags: %s\n", flagString(c.flags))
+	}
+	if c.fpu != nil {
+		for i := byte(0); i < 8; i++ {
+			if r, err := c.fpu.st(i); err == nil {
+				fmt.Fprintf(out, "     st%d: %s\n", i, r.Text('g', 20))
+			}
+		}
+		if sw := c.fpu.statusWord(); sw != 0 {
+			fmt.Fprintf(out, "  status: %#04x\n", sw)
+		}
+	}
+	fmt.Fprintf(out, "  clocks: %d\n", c.clocks)
+}
+
 type peekableByteReader struct {
 	*bytes.Reader
+	err   error    // First read or decode error, reported by decodeInstruction.
+	model cpuModel // Instruction set accepted by decodeInstruction.
 }
 
 func newPeekableBytReader(b []byte) *peekableByteReader {
@@ -52,13 +349,29 @@ func (pr *peekableByteReader) peek(n int) ([]byte, error) {
 	return buf, nil
 }
 
+// fail records err unless an earlier error is already pending. Decoders keep
+// going after a failure and the error is reported once the instruction ends.
+func (pr *peekableByteReader) fail(err error) {
+	if pr.err == nil {
+		pr.err = err
+	}
+}
+
+// readByte reads the next byte, recording an error on a truncated stream.
+func (pr *peekableByteReader) readByte() byte {
+	b, err := pr.ReadByte()
+	if err != nil {
+		pr.fail(fmt.Errorf("error reading byte: %w", err))
+	}
+	return b
+}
+
 func (pr *peekableByteReader) readUint16W(wide bool) uint16 {
 	if wide {
 		return pr.readUint16()
 	}
 
-	readByte, _ := pr.ReadByte()
-	return uint16(readByte)
+	return uint16(pr.readByte())
 }
 
 func (pr *peekableByteReader) readInt16() int16 {
@@ -69,7 +382,8 @@ func (pr *peekableByteReader) readInt16() int16 {
 func (pr *peekableByteReader) readUint16() uint16 {
 	b, err := pr.readN(2)
 	if err != nil {
-		log.Fatalf("error reading bytes: %v", err)
+		pr.fail(fmt.Errorf("error reading bytes: %w", err))
+		return 0
 	}
 
 	low := uint16(b[0])
@@ -95,70 +409,110 @@ func (pr *peekableByteReader) readN(n int) ([]byte, error) {
 }
 
 func (pr *peekableByteReader) readUint8() uint8 {
-	byteVal, err := pr.ReadByte()
-	if err != nil {
-		log.Fatalf("error reading byte: %v", err)
-	}
-	return byteVal
+	return pr.readByte()
 }
 
 func (pr *peekableByteReader) readInt8() int8 {
-	result, err := pr.ReadByte()
-	if err != nil {
-		log.Fatalf("error reading byte: %v", err)
-	}
-	return int8(result)
+	return int8(pr.readByte())
 }
 
 // instruction defines the contract for x86 instructions that can be disassembled
 // into their human-readable representations. Implementers of this interface
 // should provide a disassemble method that returns the assembly code equivalent
-// of the binary-encoded instruction.
+// of the binary-encoded instruction, a form method that describes it
+// independently of assembler syntax, and an encoding method that names the
+// fields of its bytes.
 type instruction interface {
 	disassemble() string
+	form() form
+	encoding() encoding
 }
 
-func disassembleFile(b []byte) (string, error) {
+// disassembleFile disassembles b for model by linear sweep, printing it with p.
+// The data ranges of symbols are emitted as data and their names replace
+// addresses.
+func disassembleFile(b []byte, p printer, model cpuModel, symbols *symbolTable) (string, error) {
+	if symbols != nil {
+		cm, err := sweep(b, model, symbols)
+		if err != nil {
+			return "", err
+		}
+		return cm.write(p), nil
+	}
+
 	var output bytes.Buffer
-	output.WriteString("bits 16\n")
+	output.WriteString(p.header())
+	output.WriteByte('\n')
 
 	reader := newPeekableBytReader(b)
+	reader.model = model
 	for reader.Len() > 0 {
-		fmt.Printf("reader len: %d\n", reader.Len())
-		b, err := reader.peek(min(4, reader.Len()))
+		instr, err := decodeInstruction(reader)
 		if err != nil {
 			return "", err
 		}
 
-		firstByte := b[0]
-		fmt.Println(firstByte)
-		var instr instruction
-		switch {
-		// Handle move instruction.
-		case isMovOp(firstByte):
-			instr = decodeMov(reader)
-			// Handle arithmetic instruction.
-		case isArithmetic(firstByte):
-			instr = decodeArithmetic(reader)
-			// Handle jump/loop instruction.
-		case isJumpOrLoopOp(firstByte):
-			b, err := reader.ReadByte()
-			if err != nil {
-				return "", err
-			}
-			j := jumpOrLoop{op: b, inc: reader.readInt8()}
-			instr = &j
-		default:
-			log.Fatalf("unsupported instruction opcode: %b", firstByte)
-		}
-
-		output.WriteString(instr.disassemble())
+		output.WriteString(p.print(instr.form()))
 		output.WriteByte('\n')
 	}
 
 	return output.String(), nil
 }
 
+// decodeInstruction decodes the single instruction at the reader's current
+// position, leaving the reader positioned at the start of the next one.
+func decodeInstruction(reader *peekableByteReader) (instruction, error) {
+	if reader.Len() == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b, err := reader.peek(min(4, reader.Len()))
+	if err != nil {
+		return nil, err
+	}
+
+	firstByte := b[0]
+	if need, ok := minCPU[firstByte]; ok && reader.model < need {
+		return nil, fmt.Errorf("opcode %08b requires the %s, decoding for the %s", firstByte, need, reader.model)
+	}
+
+	var instr instruction
+	switch {
+	// Handle move instruction.
+	case isMovOp(firstByte):
+		instr = decodeMov(reader)
+	// Handle arithmetic instruction.
+	case isArithmetic(firstByte):
+		a := decodeArithmetic(reader)
+		if a.op == arithmeticInvalidOp {
+			reader.fail(fmt.Errorf("unsupported arithmetic operation: %b /%d", firstByte, a.reg))
+		}
+		instr = a
+	// Handle jump/loop instruction.
+	case isJumpOrLoopOp(firstByte):
+		op := reader.readByte()
+		instr = &jumpOrLoop{op: op, inc: reader.readInt8()}
+	// Handle unconditional jump, call and return instruction.
+	case isBranchOp(firstByte):
+		instr = decodeBranch(reader)
+	// Handle instructions added by the 80186.
+	case isExtendedOp(firstByte):
+		instr = decodeExtended(reader)
+	// Handle 8087 coprocessor instructions.
+	case isEscOp(firstByte):
+		instr = decodeEsc(reader)
+	case firstByte == opWait:
+		reader.readByte()
+		instr = &wait{}
+	default:
+		return nil, fmt.Errorf("unsupported instruction opcode: %b", firstByte)
+	}
+
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	return instr, nil
+}
+
 // isMovOp determines if the given byte represents a MOV operation.
 func isMovOp(b byte) bool {
 	return (b>>2) == 0b100010 || (b>>1) == 0b1100011 || (b>>4) == 0b1011 || (b>>1) == 0b1010000 || (b>>1) == 0b1010001 || b == 0b10001110 || b == 0b10001100
@@ -189,6 +543,11 @@ func isJumpOrLoopOp(b byte) bool {
 	return (b>>4) == 0b0111 || (b>>2) == 0b111000
 }
 
+// isBranchOp determines if the given byte represents a direct near CALL or JMP, or a near RET.
+func isBranchOp(b byte) bool {
+	return b == opCallNear || b == opJmpNear || b == opJmpShort || b == opRetNear || b == opRetNearImm
+}
+
 // movType represents different types of MOV operations.
 type movType uint8
 
@@ -220,36 +579,37 @@ type common struct {
 }
 
 func (m *mov) disassemble() string {
+	return nasm.print(m.form())
+}
+
+func (m *mov) form() form {
+	f := form{mnemonic: "mov"}
+	wide := m.w == 1
 	switch m.typ {
 	case movRegisterMemToFromRegister:
-		src := m.regName(m.w == 1)
-		tgt := m.rmName(m.w == 1)
+		src := m.regOperand(wide)
+		tgt := m.rmOperand(wide)
 
 		if m.d == 1 {
 			src, tgt = tgt, src
 		}
 
-		return fmt.Sprintf("mov %s, %s", tgt, src)
+		f.operands = []operand{tgt, src}
 	case movImmediateToRegister:
-		return fmt.Sprintf("mov %s, %d", m.regName(m.w == 1), m.data)
+		f.operands = []operand{m.regOperand(wide), immediateOperand(m.data, wide)}
 	case movImmediateToMemoryOrRegister:
-		if m.mod == 0b11 {
-			return fmt.Sprintf("mov %s, %d", m.rmName(m.w == 1), m.data)
+		f.operands = []operand{m.rmOperand(wide), immediateOperand(m.data, wide)}
+		if m.mod != 0b11 {
+			f.size, f.sizeOperand = int(m.w)+1, 1
 		}
-
-		dataT := "byte"
-		if m.w == 1 {
-			dataT = "word"
-		}
-		return fmt.Sprintf("mov %s, %s %d", m.rmName(m.w == 1), dataT, m.data)
 	case movMemoryToAccumulator:
-		return fmt.Sprintf("mov ax, [%d]", m.data)
+		f.operands = []operand{registerOperand(regs[wide][0]), directOperand(m.data)}
 	case movAccumulatorToMemory:
-		return fmt.Sprintf("mov [%d], ax", m.data)
+		f.operands = []operand{directOperand(m.data), registerOperand(regs[wide][0])}
 	default:
 		log.Fatalf("invalid mov type: %d", m.typ)
-		return ""
 	}
+	return f
 }
 
 // regs maps the register index to the string representation of the register.
@@ -275,37 +635,10 @@ func (c *common) regName(wide bool) string {
 	return regs[wide][c.reg]
 }
 
-func (c *common) rmName(wide bool) string {
-	switch c.mod {
-	case 0b11:
-		return regs[wide][c.rm]
-	case 0b00:
-		if c.rm == 0b110 {
-			return fmt.Sprintf("[%d]", uint16(c.disp))
-		}
-		return fmt.Sprintf("[%s]", baseAddresses[c.rm])
-	case 0b01, 0b10:
-		base := baseAddresses[c.rm]
-		dispStr := ""
-		if c.disp < 0 {
-			dispStr = fmt.Sprintf(" - %d", -c.disp)
-		} else if c.disp > 0 {
-			dispStr = fmt.Sprintf(" + %d", c.disp)
-		}
-		return fmt.Sprintf("[%s%s]", base, dispStr)
-	default:
-		panic("invalid mod value")
-	}
-}
-
 // decodeMov decodes the MOV instruction from a stream of bytes provided by a peekableByteReader.
 // It identifies the type of MOV operation based on the opcode and populates the mov struct accordingly.
 func decodeMov(r *peekableByteReader) *mov {
-	firstByte, err := r.ReadByte()
-	if err != nil {
-		log.Fatalf("error reading byte: %v", err)
-	}
-
+	firstByte := r.readByte()
 	instruction := mov{}
 
 	switch {
@@ -333,15 +666,17 @@ func decodeMov(r *peekableByteReader) *mov {
 	// Decode MOV from memory to accumulator.
 	case (firstByte >> 1) == 0b1010000:
 		instruction.typ = movMemoryToAccumulator
+		instruction.w = firstByte & 1
 		instruction.data = r.readUint16()
 
 	// Decode MOV from accumulator to memory.
 	case (firstByte >> 1) == 0b1010001:
 		instruction.typ = movAccumulatorToMemory
+		instruction.w = firstByte & 1
 		instruction.data = r.readUint16()
 
 	default:
-		log.Fatalf("unsupported instruction opcode: %b", firstByte)
+		r.fail(fmt.Errorf("unsupported instruction opcode: %b", firstByte))
 	}
 
 	return &instruction
@@ -351,20 +686,17 @@ func decodeMov(r *peekableByteReader) *mov {
 // the provided byte stream. It decodes mode, register, and optional displacement values.
 func decodeCommon(r *peekableByteReader) common {
 	instruction := common{}
-	b1, err := r.ReadByte()
-	if err != nil {
-		log.Fatalf("error reading byte: %v", err)
-	}
+	b1 := r.readByte()
 
 	instruction.mod = (b1 >> 6) & 0b11
 	instruction.reg = (b1 >> 3) & 0b111
 	instruction.rm = b1 & 0b111
 
 	// Decode displacement based on mod value.
-	if instruction.mod == 0b01 {
-		b, _ := r.ReadByte()
-		instruction.disp = int16(int8(b))
-	} else if instruction.mod == 0b10 || (instruction.mod == 0b00 && instruction.rm == 0b110) {
+	switch instruction.dispSize() {
+	case 1:
+		instruction.disp = int16(r.readInt8())
+	case 2:
 		instruction.disp = r.readInt16()
 	}
 
@@ -419,84 +751,94 @@ type arithmetic struct {
 	op  arithmeticOp   // The specific arithmetic operation (e.g., ADD, SUB).
 
 	common           // Embedded type capturing common details across instructions.
-	data      uint16 // Immediate data or offset used in the instruction.
+	data      uint16 // Immediate data, sign-extended to a word when s = 1 and w = 1.
 	d         byte   // Direction flag: source and destination for operation.
 	s         byte   // Sign flag: determines signedness of immediate data.
 	w         byte   // Operand size: byte or word.
 	firstByte byte   // The first byte of the instruction for reference.
 }
 
-// opName translates the byte representation of an arithmetic operation
-// into its human-readable form. It determines the operation name based on
-// the instruction's first byte and the `reg` field.
-func (a *arithmetic) opName() string {
+// operation resolves the arithmetic operation from the instruction's first
+// byte and, for the immediate group, the `reg` field.
+func (a *arithmetic) operation() arithmeticOp {
 	b := a.firstByte
 	switch {
 	case isAddOp(b):
-		return "add"
+		return arithmeticAdd
 	case isSubOp(b):
-		return "sub"
+		return arithmeticSub
 	case isCmpOp(b):
-		return "cmp"
+		return arithmeticCmp
 	default:
 		switch a.reg {
 		case 0b000:
-			return "add"
+			return arithmeticAdd
 		case 0b111:
-			return "cmp"
+			return arithmeticCmp
 		case 0b101:
-			return "sub"
+			return arithmeticSub
 		default:
-			log.Fatalf("invalid arithmetic operation: %b", b)
-			return ""
+			return arithmeticInvalidOp
 		}
 	}
 }
 
+// opName translates the decoded arithmetic operation into its human-readable form.
+func (a *arithmetic) opName() string {
+	switch a.op {
+	case arithmeticAdd:
+		return "add"
+	case arithmeticSub:
+		return "sub"
+	case arithmeticCmp:
+		return "cmp"
+	default:
+		log.Fatalf("invalid arithmetic operation: %b", a.firstByte)
+		return ""
+	}
+}
+
 func (a *arithmetic) disassemble() string {
-	op := a.opName()
+	return nasm.print(a.form())
+}
+
+func (a *arithmetic) form() form {
+	f := form{mnemonic: a.opName()}
+	wide := a.w == 1
 
 	switch a.typ {
 	case arithmeticRegOrMemWithRegToEither:
-		src := a.regName(a.w == 1)
-		tgt := a.rmName(a.w == 1)
+		src := a.regOperand(wide)
+		tgt := a.rmOperand(wide)
 
 		if a.d == 1 {
 			src, tgt = tgt, src
 		}
 
-		return fmt.Sprintf("%s %s, %s", op, tgt, src)
+		f.operands = []operand{tgt, src}
 	case arithmeticImmediateToRegOrMem:
-		if a.mod == 0b11 {
-			return fmt.Sprintf("%s %s, %d", op, a.rmName(a.w == 1), a.data)
-		}
-
-		dataT := "byte"
-		if a.w == 1 {
-			dataT = "word"
+		imm := immediateOperand(a.data, wide)
+		// Without s the word form was chosen on purpose, even for a value
+		// the sign-extended byte form could hold.
+		imm.strict = a.s == 0 && wide && fitsInt8(imm.value)
+		f.operands = []operand{a.rmOperand(wide), imm}
+		if a.mod != 0b11 {
+			f.size, f.sizeOperand = int(a.w)+1, 0
 		}
-
-		return fmt.Sprintf("%s %s %s, %d", op, dataT, a.rmName(a.w == 1), a.data)
 	case arithmeticImmediateToAccumulator:
-		regName := "ax"
-		if a.w == 0 {
-			regName = "al"
-		}
-		return fmt.Sprintf("%s %s, %d", op, regName, a.data)
+		imm := immediateOperand(a.data, wide)
+		imm.strict = wide && fitsInt8(imm.value)
+		f.operands = []operand{registerOperand(regs[wide][0]), imm}
 	default:
 		log.Fatalf("invalid arithmetic type: %d", a.typ)
-		return ""
 	}
+	return f
 }
 
 // decodeArithmetic decodes the arithmetic instruction from a stream of bytes provided by a peekableByteReader.
 // It identifies the type of arithmetic operation based on the opcode and populates the arithmetic struct accordingly.
 func decodeArithmetic(r *peekableByteReader) *arithmetic {
-	firstByte, err := r.ReadByte()
-	if err != nil {
-		log.Fatalf("error reading byte: %v", err)
-	}
-
+	firstByte := r.readByte()
 	instruction := arithmetic{}
 	instruction.firstByte = firstByte
 
@@ -506,7 +848,13 @@ func decodeArithmetic(r *peekableByteReader) *arithmetic {
 		instruction.s = (firstByte >> 1) & 1
 		instruction.w = (firstByte >> 0) & 1
 		instruction.common = decodeCommon(r)
-		instruction.data = r.readUint16W(instruction.s == 0 && instruction.w == 1)
+		if instruction.s == 1 && instruction.w == 1 {
+			// The CPU sign-extends the byte to a word before the operation.
+			instruction.data = uint16(int16(r.readInt8()))
+		} else {
+			// With w = 0 the s bit has no effect: 82 behaves exactly like 80.
+			instruction.data = r.readUint16W(instruction.w == 1)
+		}
 	case (firstByte>>2)&1 == 0b1:
 		instruction.typ = arithmeticImmediateToAccumulator
 		instruction.w = (firstByte >> 0) & 1
@@ -517,8 +865,9 @@ func decodeArithmetic(r *peekableByteReader) *arithmetic {
 		instruction.w = (firstByte >> 0) & 1
 		instruction.common = decodeCommon(r)
 	default:
-		log.Fatalf("unsupported instruction opcode: %b", firstByte)
+		r.fail(fmt.Errorf("unsupported instruction opcode: %b", firstByte))
 	}
+	instruction.op = instruction.operation()
 
 	return &instruction
 }
@@ -557,9 +906,13 @@ var loopLabels = []string{
 }
 
 func (j *jumpOrLoop) disassemble() string {
-	return fmt.Sprintf("%s $+2%+d", j.opName(), j.inc)
+	return nasm.print(j.form())
+}
 
+func (j *jumpOrLoop) form() form {
+	return form{mnemonic: j.opName(), operands: []operand{relativeOperand(int(j.inc), 2)}}
 }
+
 func (j *jumpOrLoop) opName() string {
 	if (j.op >> 4) == 0b0111 {
 		return jumpLabels[j.op&0b1111]
@@ -568,6 +921,57 @@ func (j *jumpOrLoop) opName() string {
 	return loopLabels[j.op&0b11]
 }
 
+// Opcodes of the unconditional near transfers.
+const (
+	opCallNear   = 0b11101000 // CALL rel16
+	opJmpNear    = 0b11101001 // JMP rel16
+	opJmpShort   = 0b11101011 // JMP rel8
+	opRetNear    = 0b11000011 // RET
+	opRetNearImm = 0b11000010 // RET imm16
+)
+
+// branch is an unconditional near transfer: a direct CALL or JMP, or a RET.
+type branch struct {
+	op   uint8  // Opcode byte.
+	disp int16  // Displacement from the next instruction for CALL and JMP.
+	data uint16 // Bytes released from the stack by RET imm16.
+}
+
+func (b *branch) disassemble() string {
+	return nasm.print(b.form())
+}
+
+func (b *branch) form() form {
+	switch b.op {
+	case opCallNear:
+		return form{mnemonic: "call", operands: []operand{relativeOperand(int(b.disp), 3)}}
+	case opJmpNear:
+		return form{mnemonic: "jmp", distance: "near", operands: []operand{relativeOperand(int(b.disp), 3)}}
+	case opJmpShort:
+		return form{mnemonic: "jmp", distance: "short", operands: []operand{relativeOperand(int(b.disp), 2)}}
+	case opRetNearImm:
+		return form{mnemonic: "ret", operands: []operand{{kind: operandImmediate, value: int(b.data)}}}
+	default:
+		return form{mnemonic: "ret"}
+	}
+}
+
+// decodeBranch decodes a near CALL, JMP or RET from a stream of bytes provided by a peekableByteReader.
+func decodeBranch(r *peekableByteReader) *branch {
+	instruction := branch{op: r.readByte()}
+
+	switch instruction.op {
+	case opCallNear, opJmpNear:
+		instruction.disp = r.readInt16()
+	case opJmpShort:
+		instruction.disp = int16(r.readInt8())
+	case opRetNearImm:
+		instruction.data = r.readUint16()
+	}
+
+	return &instruction
+}
+
 func min[T constraints.Ordered](a, b T) T {
 	if a < b {
 		return a
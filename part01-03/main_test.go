@@ -1 +1,137 @@
 package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// simulateOne loads program into c, runs its first instruction with bx
+// preset and returns bx.
+func simulateOne(t *testing.T, c *cpu, program []byte, bx uint16) uint16 {
+	t.Helper()
+	c.load(program)
+	c.regs[regBX] = bx
+	if _, err := c.step(); err != nil {
+		t.Fatal(err)
+	}
+	return c.regs[regBX]
+}
+
+// TestImmediateSignExtension covers every s/w combination of the immediate
+// group with every byte immediate, and every word immediate of the 81 form,
+// checking the decoded value, the printed text and the simulated result.
+func TestImmediateSignExtension(t *testing.T) {
+	c := newCPU()
+	for op := byte(0x80); op <= 0x83; op++ {
+		s, w := (op>>1)&1, op&1
+		values := 256
+		if s == 0 && w == 1 {
+			values = 1 << 16
+		}
+
+		for v := 0; v < values; v++ {
+			program := []byte{op, 0xc3, byte(v)} // add bl/bx, imm
+			if values > 256 {
+				program = append(program, byte(v>>8))
+			}
+
+			d, err := decodeAt(program, 0, cpu8086)
+			if err != nil {
+				t.Fatalf("%x: %v", program, err)
+			}
+			if d.size != len(program) {
+				t.Fatalf("%x: decoded %d bytes, want %d", program, d.size, len(program))
+			}
+
+			var wantData uint16
+			var wantText string
+			switch {
+			case w == 0:
+				wantData = uint16(v)
+				wantText = fmt.Sprintf("add bl, %d", int8(v))
+			case s == 1:
+				wantData = uint16(int16(int8(v)))
+				wantText = fmt.Sprintf("add bx, %d", int8(v))
+			case fitsInt8(int(int16(v))):
+				wantData = uint16(v)
+				wantText = fmt.Sprintf("add bx, strict word %d", int16(v))
+			default:
+				wantData = uint16(v)
+				wantText = fmt.Sprintf("add bx, %d", int16(v))
+			}
+
+			a := d.instr.(*arithmetic)
+			if a.data != wantData {
+				t.Fatalf("%x: data = %#x, want %#x", program, a.data, wantData)
+			}
+			if got := d.instr.disassemble(); got != wantText {
+				t.Fatalf("%x: disassembled %q, want %q", program, got, wantText)
+			}
+
+			// Adding to a zero register leaves the operand in it, showing
+			// how the CPU extended the immediate.
+			wantBX := wantData
+			if w == 0 {
+				wantBX &= 0xff
+			}
+			if got := simulateOne(t, c, program, 0); got != wantBX {
+				t.Fatalf("%x: bx = %#x, want %#x", program, got, wantBX)
+			}
+		}
+	}
+}
+
+func TestImmediatesPrintSigned(t *testing.T) {
+	tests := []struct {
+		input []byte
+		want  string
+	}{
+		{[]byte{0xb5, 0xf4}, "mov ch, -12"},
+		{[]byte{0xb9, 0xf4, 0xff}, "mov cx, -12"},
+		{[]byte{0xba, 0x94, 0xf0}, "mov dx, -3948"},
+		{[]byte{0xc7, 0x07, 0xff, 0xff}, "mov [bx], word -1"},
+		{[]byte{0x04, 0xe2}, "add al, -30"},
+		{[]byte{0x05, 0xe8, 0x03}, "add ax, 1000"},
+		{[]byte{0x05, 0xff, 0xff}, "add ax, strict word -1"},
+		{[]byte{0x3d, 0x80, 0xff}, "cmp ax, strict word -128"},
+		{[]byte{0x3d, 0x7f, 0xff}, "cmp ax, -129"},
+		{[]byte{0x83, 0x3f, 0x80}, "cmp word [bx], -128"},
+		{[]byte{0x81, 0x3f, 0x80, 0x00}, "cmp word [bx], 128"},
+		{[]byte{0xc2, 0xfe, 0xff}, "ret 65534"},
+	}
+	for _, tt := range tests {
+		d, err := decodeAt(tt.input, 0, cpu8086)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.instr.disassemble(); got != tt.want {
+			t.Errorf("%x: Expected %q, got %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestDisplacementsPrintForReencoding(t *testing.T) {
+	tests := []struct {
+		input []byte
+		want  string
+	}{
+		{[]byte{0x8b, 0x47, 0x80}, "mov ax, [bx - 128]"},
+		{[]byte{0x8b, 0x47, 0x7f}, "mov ax, [bx + 127]"},
+		{[]byte{0x8b, 0x40, 0x00}, "mov ax, [byte bx + si]"},
+		{[]byte{0x8b, 0x46, 0x00}, "mov ax, [bp]"},
+		{[]byte{0x8b, 0x87, 0x04, 0x00}, "mov ax, [word bx + 4]"},
+		{[]byte{0x8b, 0x87, 0x80, 0xff}, "mov ax, [word bx - 128]"},
+		{[]byte{0x8b, 0x87, 0x7f, 0xff}, "mov ax, [bx - 129]"},
+		{[]byte{0x8b, 0x86, 0x00, 0x00}, "mov ax, [word bp]"},
+		{[]byte{0x8b, 0x06, 0xff, 0xff}, "mov ax, [65535]"},
+	}
+	for _, tt := range tests {
+		d, err := decodeAt(tt.input, 0, cpu8086)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.instr.disassemble(); got != tt.want {
+			t.Errorf("%x: Expected %q, got %q", tt.input, tt.want, got)
+		}
+	}
+}
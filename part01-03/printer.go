@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// operandKind distinguishes the operands of a form.
+type operandKind uint8
+
+const (
+	operandRegister  operandKind = iota // A general purpose register.
+	operandMemory                       // A memory operand described by mod, rm and disp.
+	operandImmediate                    // An immediate value.
+	operandRelative                     // A transfer target relative to the instruction.
+	operandLabel                        // A transfer target replaced by a label.
+)
+
+// operand is a single syntax-neutral instruction operand.
+type operand struct {
+	kind  operandKind
+	reg   string // Register name for operandRegister.
+	mem   common // Addressing fields for operandMemory.
+	value int    // Immediate value, or the displacement of operandRelative.
+	size  int    // Instruction length added to $ for operandRelative.
+
+	// label is the target name for operandLabel. On a direct operandMemory
+	// it names the symbol the address refers to, value bytes into it.
+	label string
+
+	// strict marks a word immediate that an assembler would shrink to a
+	// sign-extended byte unless told otherwise.
+	strict bool
+}
+
+// form is the syntax-neutral shape of a decoded instruction: its mnemonic and
+// operands in Intel order, destination first.
+type form struct {
+	mnemonic string
+	operands []operand
+
+	// size is the operand size in bytes when no register operand implies it,
+	// or 0. sizeOperand is the operand NASM attaches the size keyword to.
+	size        int
+	sizeOperand int
+
+	// distance is "near" or "short" for unconditional jumps, which assemblers
+	// would otherwise be free to encode either way.
+	distance string
+
+	// fpu marks an 8087 instruction, whose size suffixes and stack registers
+	// GAS spells differently. stDestination marks its register forms that
+	// store to st(i) instead of st0.
+	fpu           bool
+	stDestination bool
+}
+
+func registerOperand(name string) operand {
+	return operand{kind: operandRegister, reg: name}
+}
+
+// immediateOperand returns the signed value of an encoded byte or word
+// immediate, the way the course listings write them.
+func immediateOperand(data uint16, wide bool) operand {
+	if wide {
+		return operand{kind: operandImmediate, value: int(int16(data))}
+	}
+	return operand{kind: operandImmediate, value: int(int8(data))}
+}
+
+// fitsInt8 reports whether v survives a round trip through a sign-extended byte.
+func fitsInt8(v int) bool {
+	return v >= -128 && v <= 127
+}
+
+func relativeOperand(disp, size int) operand {
+	return operand{kind: operandRelative, value: disp, size: size}
+}
+
+// directOperand is a memory operand at a 16 bit address.
+func directOperand(addr uint16) operand {
+	return operand{kind: operandMemory, mem: common{mod: 0b00, rm: 0b110, disp: int16(addr)}}
+}
+
+// rmOperand returns the operand selected by the mod and rm fields.
+func (c *common) rmOperand(wide bool) operand {
+	if c.mod == 0b11 {
+		return registerOperand(regs[wide][c.rm])
+	}
+	return operand{kind: operandMemory, mem: *c}
+}
+
+// regOperand returns the register selected by the reg field.
+func (c *common) regOperand(wide bool) operand {
+	return registerOperand(c.regName(wide))
+}
+
+// sizeName returns the size keyword for an operand of n bytes.
+func sizeName(n int) string {
+	switch n {
+	case 1:
+		return "byte"
+	case 4:
+		return "dword"
+	case 8:
+		return "qword"
+	case 10:
+		return "tword"
+	default:
+		return "word"
+	}
+}
+
+// printer renders decoded instructions in a particular assembler syntax.
+type printer interface {
+	// header returns the directive that starts a listing.
+	header() string
+	// print renders a single instruction.
+	print(f form) string
+	// data renders bytes that are not code as little-endian elements of
+	// size bytes: 1, 2, 4 or 8.
+	data(b []byte, size int) string
+}
+
+// printOptions are the settings shared by every syntax.
+type printOptions struct {
+	hex   bool // Print immediates and displacements in hex.
+	upper bool // Print mnemonics, registers and keywords in upper case.
+
+	cpu cpuModel // Processor named by the MASM header.
+	fpu fpuModel // Coprocessor named by the MASM header.
+}
+
+// keyword applies the case setting to a mnemonic, register or keyword.
+func (o printOptions) keyword(s string) string {
+	if o.upper {
+		return strings.ToUpper(s)
+	}
+	return s
+}
+
+// hexDigits renders the magnitude of v in hex, applying the case setting.
+func (o printOptions) hexDigits(v int) string {
+	if v < 0 {
+		v = -v
+	}
+	if o.upper {
+		return fmt.Sprintf("%X", v)
+	}
+	return fmt.Sprintf("%x", v)
+}
+
+// hexByte renders b as two hex digits, applying the case setting.
+func (o printOptions) hexByte(b byte) string {
+	if o.upper {
+		return fmt.Sprintf("%02X", b)
+	}
+	return fmt.Sprintf("%02x", b)
+}
+
+// dataValues renders b as hex digits per little-endian element of size
+// bytes, most significant byte first, applying the case setting.
+func (o printOptions) dataValues(b []byte, size int) []string {
+	values := make([]string, 0, len(b)/size)
+	for i := 0; i+size <= len(b); i += size {
+		var digits strings.Builder
+		for j := i + size - 1; j >= i; j-- {
+			digits.WriteString(o.hexByte(b[j]))
+		}
+		values = append(values, digits.String())
+	}
+	return values
+}
+
+// dataDirective returns the NASM and MASM directive for data elements of
+// size bytes.
+func dataDirective(size int) string {
+	switch size {
+	case 2:
+		return "dw"
+	case 4:
+		return "dd"
+	case 8:
+		return "dq"
+	default:
+		return "db"
+	}
+}
+
+// cNumber renders v with a 0x prefix in hex mode, as NASM and GAS accept.
+func (o printOptions) cNumber(v int) string {
+	if !o.hex {
+		return fmt.Sprintf("%d", v)
+	}
+	sign := ""
+	if v < 0 {
+		sign = "-"
+	}
+	return sign + "0x" + o.hexDigits(v)
+}
+
+// signed renders a displacement as " + n" or " - n" with the given separator
+// around the sign, or nothing for 0.
+func signed(v int, sep string, number func(int) string) string {
+	switch {
+	case v < 0:
+		return sep + "-" + sep + number(-v)
+	case v > 0:
+		return sep + "+" + sep + number(v)
+	default:
+		return ""
+	}
+}
+
+// nasmPrinter prints NASM syntax, the form the course listings use.
+type nasmPrinter struct{ printOptions }
+
+func (p nasmPrinter) header() string {
+	return p.keyword("bits") + " 16"
+}
+
+func (p nasmPrinter) print(f form) string {
+	text := p.keyword(f.mnemonic)
+	if f.distance != "" {
+		text += " " + p.keyword(f.distance)
+	}
+	for i, op := range f.operands {
+		if i == 0 {
+			text += " "
+		} else {
+			text += ", "
+		}
+		if f.size != 0 && i == f.sizeOperand {
+			text += p.keyword(sizeName(f.size)) + " "
+		}
+		if op.strict {
+			text += p.keyword("strict word") + " "
+		}
+		text += p.operand(op)
+	}
+	return text
+}
+
+func (p nasmPrinter) operand(op operand) string {
+	switch op.kind {
+	case operandRegister:
+		return p.keyword(op.reg)
+	case operandMemory:
+		m := op.mem
+		if m.mod == 0b00 && m.rm == 0b110 {
+			if op.label != "" {
+				return "[" + op.label + signed(op.value, " ", p.cNumber) + "]"
+			}
+			return "[" + p.cNumber(int(uint16(m.disp))) + "]"
+		}
+		base := p.keyword(baseAddresses[m.rm])
+		switch {
+		case m.mod == 0b00:
+			return "[" + base + "]"
+		case m.mod == 0b01 && m.disp == 0 && m.rm != 0b110:
+			// NASM drops a zero displacement unless forced, except for bp
+			// which has no form without one.
+			return "[" + p.keyword("byte") + " " + base + "]"
+		case m.mod == 0b10 && fitsInt8(int(m.disp)):
+			// NASM would shrink this displacement to a byte.
+			return "[" + p.keyword("word") + " " + base + signed(int(m.disp), " ", p.cNumber) + "]"
+		}
+		return "[" + base + signed(int(m.disp), " ", p.cNumber) + "]"
+	case operandImmediate:
+		return p.cNumber(op.value)
+	case operandRelative:
+		return fmt.Sprintf("$+%d%+d", op.size, op.value)
+	default:
+		return op.label
+	}
+}
+
+func (p nasmPrinter) data(b []byte, size int) string {
+	parts := p.dataValues(b, size)
+	for i, v := range parts {
+		parts[i] = "0x" + v
+	}
+	return p.keyword(dataDirective(size)) + " " + strings.Join(parts, ", ")
+}
+
+// masmPrinter prints the MASM syntax used by the Intel manuals: memory
+// operands of ambiguous size carry "byte ptr" or "word ptr" and hex numbers
+// have an h suffix.
+type masmPrinter struct{ printOptions }
+
+// masmCPUDirectives and masmFPUDirectives are the MASM directives that
+// enable each model's instructions, indexed by cpuModel and fpuModel.
+var (
+	masmCPUDirectives = []string{".8086", ".186", ".286"}
+	masmFPUDirectives = []string{".8087", ".287", ".387"}
+)
+
+func (p masmPrinter) header() string {
+	return p.keyword(masmCPUDirectives[p.cpu]) + "\n" + p.keyword(masmFPUDirectives[p.fpu])
+}
+
+// number renders v in decimal or with an h suffix, adding a leading 0 where
+// the digits would otherwise start with a letter.
+func (p masmPrinter) number(v int) string {
+	if !p.hex {
+		return fmt.Sprintf("%d", v)
+	}
+	sign := ""
+	if v < 0 {
+		sign = "-"
+	}
+	digits := p.hexDigits(v)
+	if strings.ContainsAny(digits[:1], "abcdefABCDEF") {
+		digits = "0" + digits
+	}
+	return sign + digits + "h"
+}
+
+func (p masmPrinter) print(f form) string {
+	text := p.keyword(f.mnemonic)
+	switch f.distance {
+	case "near":
+		text += " " + p.keyword("near ptr")
+	case "short":
+		text += " " + p.keyword("short")
+	}
+
+	// The size goes on the memory operand, or on the only operand there is.
+	sized := -1
+	if f.size != 0 {
+		sized = f.sizeOperand
+		for i, op := range f.operands {
+			if op.kind == operandMemory {
+				sized = i
+				break
+			}
+		}
+	}
+
+	for i, op := range f.operands {
+		if i == 0 {
+			text += " "
+		} else {
+			text += ", "
+		}
+		if i == sized {
+			name := sizeName(f.size)
+			if name == "tword" {
+				name = "tbyte"
+			}
+			text += p.keyword(name+" ptr") + " "
+		}
+		text += p.operand(op)
+	}
+	return text
+}
+
+func (p masmPrinter) operand(op operand) string {
+	switch op.kind {
+	case operandRegister:
+		if i, ok := isSTRegister(op.reg); ok {
+			if i == 0 {
+				return p.keyword("st")
+			}
+			return p.keyword(fmt.Sprintf("st(%d)", i))
+		}
+		return p.keyword(op.reg)
+	case operandMemory:
+		m := op.mem
+		if m.mod == 0b00 && m.rm == 0b110 {
+			if op.label != "" {
+				return "[" + op.label + signed(op.value, "", p.number) + "]"
+			}
+			return p.keyword("ds") + ":[" + p.number(int(uint16(m.disp))) + "]"
+		}
+		base := p.keyword(strings.ReplaceAll(baseAddresses[m.rm], " ", ""))
+		if m.mod == 0b00 {
+			return "[" + base + "]"
+		}
+		return "[" + base + signed(int(m.disp), "", p.number) + "]"
+	case operandImmediate:
+		return p.number(op.value)
+	case operandRelative:
+		return fmt.Sprintf("$+%d%+d", op.size, op.value)
+	default:
+		return op.label
+	}
+}
+
+func (p masmPrinter) data(b []byte, size int) string {
+	parts := p.dataValues(b, size)
+	for i, digits := range parts {
+		if strings.ContainsAny(digits[:1], "abcdefABCDEF") {
+			digits = "0" + digits
+		}
+		parts[i] = digits + "h"
+	}
+	return p.keyword(dataDirective(size)) + " " + strings.Join(parts, ", ")
+}
+
+// attPrinter prints AT&T syntax as accepted by GAS: source operand first,
+// % before registers, $ before immediates, disp(base,index) memory operands
+// and a b or w mnemonic suffix where no register implies the size.
+type attPrinter struct{ printOptions }
+
+func (p attPrinter) header() string {
+	return p.keyword(".code16")
+}
+
+// attReversed swaps the subtract and divide mnemonics of the x87 register
+// forms that store to st(i). GAS keeps the historical AT&T Unix assembler
+// spelling, which has them the other way round from Intel.
+var attReversed = map[string]string{
+	"fsub": "fsubr", "fsubr": "fsub", "fsubp": "fsubrp", "fsubrp": "fsubp",
+	"fdiv": "fdivr", "fdivr": "fdiv", "fdivp": "fdivrp", "fdivrp": "fdivp",
+}
+
+// attSuffix returns the mnemonic suffix that gives the memory operand size:
+// b or w for integer instructions, s, l or t for x87 reals and s, l or ll for
+// x87 integers. Packed BCD and the control instructions take none.
+func attSuffix(f form) string {
+	switch {
+	case f.size == 0:
+		return ""
+	case !f.fpu:
+		return sizeName(f.size)[:1]
+	case strings.HasPrefix(f.mnemonic, "fi"):
+		return map[int]string{2: "s", 4: "l", 8: "ll"}[f.size]
+	case strings.HasPrefix(f.mnemonic, "fb"):
+		return ""
+	default:
+		return map[int]string{4: "s", 8: "l", 10: "t"}[f.size]
+	}
+}
+
+func (p attPrinter) print(f form) string {
+	mnemonic := f.mnemonic
+	if reversed, ok := attReversed[mnemonic]; ok && f.stDestination {
+		mnemonic = reversed
+	}
+	text := p.keyword(mnemonic + attSuffix(f))
+	for i := len(f.operands) - 1; i >= 0; i-- {
+		if i == len(f.operands)-1 {
+			text += " "
+		} else {
+			text += ", "
+		}
+		text += p.operand(f.operands[i])
+	}
+	return text
+}
+
+func (p attPrinter) operand(op operand) string {
+	switch op.kind {
+	case operandRegister:
+		if i, ok := isSTRegister(op.reg); ok {
+			if i == 0 {
+				return "%" + p.keyword("st")
+			}
+			return "%" + p.keyword(fmt.Sprintf("st(%d)", i))
+		}
+		return "%" + p.keyword(op.reg)
+	case operandMemory:
+		m := op.mem
+		if m.mod == 0b00 && m.rm == 0b110 {
+			if op.label != "" {
+				return op.label + signed(op.value, "", p.cNumber)
+			}
+			return p.cNumber(int(uint16(m.disp)))
+		}
+		regs := strings.Split(baseAddresses[m.rm], " + ")
+		for i, r := range regs {
+			regs[i] = "%" + p.keyword(r)
+		}
+		disp := ""
+		if m.mod != 0b00 && m.disp != 0 {
+			disp = p.cNumber(int(m.disp))
+		}
+		return disp + "(" + strings.Join(regs, ",") + ")"
+	case operandImmediate:
+		return "$" + p.cNumber(op.value)
+	case operandRelative:
+		return fmt.Sprintf(".+%d%+d", op.size, op.value)
+	default:
+		return op.label
+	}
+}
+
+// attDataDirectives are the GAS directives for data elements by size.
+var attDataDirectives = map[int]string{1: ".byte", 2: ".word", 4: ".long", 8: ".quad"}
+
+func (p attPrinter) data(b []byte, size int) string {
+	parts := p.dataValues(b, size)
+	for i, v := range parts {
+		parts[i] = "0x" + v
+	}
+	return p.keyword(attDataDirectives[size]) + " " + strings.Join(parts, ", ")
+}
+
+// nasm is the printer behind disassemble and the simulator's logs.
+var nasm printer = nasmPrinter{}
+
+// newPrinter returns the printer for the named syntax: nasm, masm or att.
+func newPrinter(syntax string, opts printOptions) (printer, error) {
+	switch syntax {
+	case "nasm":
+		return nasmPrinter{opts}, nil
+	case "masm", "intel":
+		return masmPrinter{opts}, nil
+	case "att", "gas":
+		return attPrinter{opts}, nil
+	default:
+		return nil, fmt.Errorf("unknown syntax %q, expected nasm, masm or att", syntax)
+	}
+}
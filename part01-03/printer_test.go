@@ -0,0 +1,120 @@
+package main
+
+import "testing"
+
+func TestPrinters(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		nasm  string
+		masm  string
+		att   string
+	}{
+		{"register to register", []byte{0x89, 0xd9}, "mov cx, bx", "mov cx, bx", "mov %bx, %cx"},
+		{"load with displacement", []byte{0x8b, 0x41, 0xdb}, "mov ax, [bx + di - 37]", "mov ax, [bx+di-25h]", "mov -0x25(%bx,%di), %ax"},
+		{"store byte immediate", []byte{0xc6, 0x03, 0x07}, "mov [bp + di], byte 7", "mov byte ptr [bp+di], 7h", "movb $0x7, (%bp,%di)"},
+		{"add word immediate", []byte{0x81, 0x40, 0x04, 0xff, 0x00}, "add word [bx + si + 4], 255", "add word ptr [bx+si+4h], 0ffh", "addw $0xff, 0x4(%bx,%si)"},
+		{"direct address", []byte{0xa1, 0xfb, 0x09}, "mov ax, [2555]", "mov ax, ds:[9fbh]", "mov 0x9fb, %ax"},
+		{"conditional jump", []byte{0x75, 0xf8}, "jnz $+2-8", "jnz $+2-8", "jnz .+2-8"},
+		{"near jump", []byte{0xe9, 0x10, 0x00}, "jmp near $+3+16", "jmp near ptr $+3+16", "jmp .+3+16"},
+		{"return with immediate", []byte{0xc2, 0x04, 0x00}, "ret 4", "ret 4h", "ret $0x4"},
+	}
+
+	// NASM in decimal, as the course listings are written, the others in hex.
+	hex := printOptions{hex: true}
+	printers := []printer{nasmPrinter{}, masmPrinter{hex}, attPrinter{hex}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := decodeAt(tt.input, 0, cpu8086)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range []string{tt.nasm, tt.masm, tt.att} {
+				if got := printers[i].print(d.instr.form()); got != want {
+					t.Errorf("Expected %q, got %q", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPrinterOptions(t *testing.T) {
+	d, err := decodeAt([]byte{0x81, 0x40, 0x04, 0xff, 0x00}, 0, cpu8086)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		p    printer
+		want string
+	}{
+		{nasmPrinter{printOptions{hex: true}}, "add word [bx + si + 0x4], 0xff"},
+		{nasmPrinter{printOptions{hex: true, upper: true}}, "ADD WORD [BX + SI + 0x4], 0xFF"},
+		{masmPrinter{printOptions{upper: true}}, "ADD WORD PTR [BX+SI+4], 255"},
+		{attPrinter{printOptions{upper: true}}, "ADDW $255, 4(%BX,%SI)"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.print(d.instr.form()); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestPrinterLabelsAndData(t *testing.T) {
+	input := []byte{
+		0xeb, 0x01, // jmp short label_0003
+		0xff, // data
+		0xc3, // label_0003: ret
+	}
+
+	expected := map[string]string{
+		"nasm": "bits 16\njmp short label_0003\ndb 0xff\nlabel_0003:\nret\n",
+		"masm": ".8086\n.8087\njmp short label_0003\ndb 0ffh\nlabel_0003:\nret\n",
+		"att":  ".code16\njmp label_0003\n.byte 0xff\nlabel_0003:\nret\n",
+	}
+	for syntax, want := range expected {
+		p, err := newPrinter(syntax, printOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := disassembleRecursive(input, []int{0}, p, cpu8086, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: Expected:\n%s\nGot:\n%s", syntax, want, got)
+		}
+	}
+}
+
+func TestMASMHeaderModels(t *testing.T) {
+	tests := []struct {
+		opts printOptions
+		want string
+	}{
+		{printOptions{}, ".8086\n.8087"},
+		{printOptions{cpu: cpu80186, fpu: fpu80287}, ".186\n.287"},
+		{printOptions{cpu: cpu80286Real, fpu: fpu80387, upper: true}, ".286\n.387"},
+	}
+	for _, tt := range tests {
+		if got := (masmPrinter{tt.opts}).header(); got != tt.want {
+			t.Errorf("%s/%s: Expected %q, got %q", tt.opts.cpu, tt.opts.fpu, tt.want, got)
+		}
+	}
+}
+
+func TestPrinterDataUpperCase(t *testing.T) {
+	expected := map[string]string{
+		"nasm": "DW 0xABCD",
+		"masm": "DW 0ABCDh",
+		"att":  ".WORD 0xABCD",
+	}
+	for syntax, want := range expected {
+		p, err := newPrinter(syntax, printOptions{upper: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.data([]byte{0xcd, 0xab}, 2); got != want {
+			t.Errorf("%s: Expected %q, got %q", syntax, want, got)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// addrProfile accumulates the executions of a single instruction.
+type addrProfile struct {
+	count  uint64 // Times executed.
+	clocks uint64 // Clocks spent, including odd-address penalties.
+	taken  uint64 // Times a conditional transfer at this address was taken.
+}
+
+// profile collects per-instruction execution counts and clocks while the
+// simulator runs.
+type profile struct {
+	byAddr map[uint32]*addrProfile
+	steps  uint64
+	clocks uint64
+	model  cpuModel // Instruction set the profiled program was decoded with.
+
+	// symbols names the code and data of the annotated listing, if set.
+	symbols *symbolTable
+
+	// printer renders the instructions of the report and listing, NASM if
+	// nil.
+	printer printer
+}
+
+// syntax returns the printer of the report and listing.
+func (p *profile) syntax() printer {
+	if p.printer == nil {
+		return nasm
+	}
+	return p.printer
+}
+
+func newProfile(model cpuModel) *profile {
+	return &profile{byAddr: make(map[uint32]*addrProfile), model: model}
+}
+
+// record adds an executed instruction to the profile.
+func (p *profile) record(res stepResult) {
+	ap, ok := p.byAddr[res.addr]
+	if !ok {
+		ap = &addrProfile{}
+		p.byAddr[res.addr] = ap
+	}
+	ap.count++
+	ap.clocks += uint64(res.clocks)
+	if res.taken {
+		ap.taken++
+	}
+	p.steps++
+	p.clocks += uint64(res.clocks)
+}
+
+// at returns the profile of the instruction at image offset off.
+func (p *profile) at(imageStart uint32, off int) addrProfile {
+	if ap, ok := p.byAddr[imageStart+uint32(off)]; ok {
+		return *ap
+	}
+	return addrProfile{}
+}
+
+// entries returns the image offsets to traverse from: the start of the image
+// plus the first executed address of every region the traversal from there
+// does not reach, such as code only reached through a RET. It also returns
+// the traversal from those entries.
+func (p *profile) entries(image []byte, imageStart uint32) ([]int, *codeMap) {
+	var executed []int
+	for addr := range p.byAddr {
+		if addr >= imageStart && int(addr-imageStart) < len(image) {
+			executed = append(executed, int(addr-imageStart))
+		}
+	}
+	sort.Ints(executed)
+
+	entries := []int{0}
+	cm := traverse(image, entries, p.model, p.symbols)
+	for _, off := range executed {
+		if !cm.covered[off] {
+			entries = append(entries, off)
+			cm = traverse(image, entries, p.model, p.symbols)
+		}
+	}
+	return entries, cm
+}
+
+// percent returns part as a percentage of total.
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// writeReport prints the hottest instructions, per-block totals and loop
+// iteration counts. image is the program as loaded at imageStart.
+func (p *profile) writeReport(w io.Writer, image []byte, imageStart uint32, top int) {
+	entries, cm := p.entries(image, imageStart)
+	fmt.Fprintf(w, "Profile: %d instructions, %d clocks\n", p.steps, p.clocks)
+
+	// Hot instructions.
+	addrs := make([]uint32, 0, len(p.byAddr))
+	for addr := range p.byAddr {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		a, b := p.byAddr[addrs[i]], p.byAddr[addrs[j]]
+		if a.clocks != b.clocks {
+			return a.clocks > b.clocks
+		}
+		return addrs[i] < addrs[j]
+	})
+	if top > 0 && len(addrs) > top {
+		addrs = addrs[:top]
+	}
+	fmt.Fprintln(w, "\nHot instructions:")
+	fmt.Fprintf(w, "  %-6s %10s %10s %7s  %s\n", "addr", "count", "clocks", "share", "instruction")
+	for _, addr := range addrs {
+		ap := p.byAddr[addr]
+		text := "?"
+		if d, ok := cm.instrs[int(addr-imageStart)]; ok {
+			text = p.syntax().print(d.instr.form())
+		}
+		fmt.Fprintf(w, "  %04x   %10d %10d %6.2f%%  %s\n", addr-imageStart, ap.count, ap.clocks, percent(ap.clocks, p.clocks), text)
+	}
+
+	// Basic blocks.
+	g := buildCFG(image, entries, p.model)
+	fmt.Fprintln(w, "\nBasic blocks:")
+	fmt.Fprintf(w, "  %-10s %10s %10s %7s\n", "block", "execs", "clocks", "share")
+	for _, blk := range g.Blocks {
+		var clocks uint64
+		for _, d := range blk.instrs {
+			clocks += p.at(imageStart, d.offset).clocks
+		}
+		execs := p.at(imageStart, blk.Start).count
+		if execs == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %-10s %10d %10d %6.2f%%\n", blk.Name, execs, clocks, percent(clocks, p.clocks))
+	}
+
+	// Loops are identified by back edges: taken transfers to a block at or
+	// before the transfer itself.
+	fmt.Fprintln(w, "\nLoops:")
+	fmt.Fprintf(w, "  %-10s %-10s %10s %10s %10s\n", "header", "back edge", "entries", "iterations", "avg/entry")
+	for _, blk := range g.Blocks {
+		last := blk.instrs[len(blk.instrs)-1]
+		targets, _ := controlFlow(last.instr, uint16(last.next()))
+		if len(targets) != 1 || int(targets[0]) > last.offset {
+			continue
+		}
+		header, ok := g.byStart[int(targets[0])]
+		if !ok {
+			continue
+		}
+
+		back := p.at(imageStart, last.offset)
+		backTaken := back.taken
+		if _, ok := last.instr.(*branch); ok {
+			backTaken = back.count // Unconditional jumps are always taken.
+		}
+		iterations := p.at(imageStart, header.Start).count
+		if iterations == 0 {
+			continue
+		}
+		entries := iterations - min(backTaken, iterations)
+		avg := float64(iterations)
+		if entries > 0 {
+			avg /= float64(entries)
+		}
+		fmt.Fprintf(w, "  %-10s %04x       %10d %10d %10.1f\n", header.Name, last.offset, entries, iterations, avg)
+	}
+}
+
+// writeAnnotatedListing prints the disassembly of the image with every line
+// prefixed by its execution count and cumulative clocks. Decoded
+// instructions that never ran are marked with #####, and bytes that were
+// never reached as code are emitted as db data marked with -.
+func (p *profile) writeAnnotatedListing(w io.Writer, image []byte, imageStart uint32) {
+	_, cm := p.entries(image, imageStart)
+	labels := cm.labels()
+
+	pr := p.syntax()
+	for i, line := range strings.Split(pr.header(), "\n") {
+		if i == 0 {
+			fmt.Fprintf(w, "%10s %10s  | %s\n", "count", "clocks", line)
+		} else {
+			fmt.Fprintf(w, "%10s %10s  | %s\n", "", "", line)
+		}
+	}
+	for off := 0; off < len(image); {
+		if name, ok := labels[off]; ok {
+			fmt.Fprintf(w, "%10s %10s  | %s:\n", "", "", name)
+		}
+
+		if d, ok := cm.instrs[off]; ok {
+			ap := p.at(imageStart, off)
+			if ap.count == 0 {
+				fmt.Fprintf(w, "%10s %10s  | %s\n", "#####", "", cm.text(pr, d, labels))
+			} else {
+				fmt.Fprintf(w, "%10d %10d  | %s\n", ap.count, ap.clocks, cm.text(pr, d, labels))
+			}
+			off = d.next()
+			continue
+		}
+
+		end, size := cm.dataRun(off, labels)
+		fmt.Fprintf(w, "%10s %10s  | %s\n", "-", "", pr.data(image[off:end], size))
+		off = end
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileCountsAndListing(t *testing.T) {
+	program := []byte{
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0xeb, 0x02, // jmp short label_0007
+		0x12, 0x34, // data
+		0x05, 0x02, 0x00, // label_0007: add ax, strict word 2
+		0xe2, 0xfb, // loop label_0007
+		0x83, 0xf8, 0x00, // cmp ax, 0
+		0x75, 0x02, // jnz label_0013
+		0x01, 0xd8, // add ax, bx, never executed
+	}
+
+	c := newCPU()
+	c.load(program)
+	p := newProfile(cpu8086)
+	if err := simulate(c, simOptions{quiet: true, profile: p}, &strings.Builder{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.at(0, 0x07); got.count != 3 || got.clocks != 12 {
+		t.Errorf("add ax, 2 profile = %+v, want 3 executions and 12 clocks", got)
+	}
+	if got := p.at(0, 0x0a); got.count != 3 || got.taken != 2 {
+		t.Errorf("loop profile = %+v, want 3 executions, 2 taken", got)
+	}
+	if p.steps != c.steps || p.clocks != c.clocks {
+		t.Errorf("profile totals = %d steps, %d clocks, want %d, %d", p.steps, p.clocks, c.steps, c.clocks)
+	}
+
+	var listing strings.Builder
+	p.writeAnnotatedListing(&listing, program, 0)
+	for _, want := range []string{
+		"         3         12  | add ax, strict word 2\n",
+		"         -             | db 0x12, 0x34\n",
+		"     #####             | add ax, bx\n",
+	} {
+		if !strings.Contains(listing.String(), want) {
+			t.Errorf("listing missing %q:\n%s", want, listing.String())
+		}
+	}
+
+	var report strings.Builder
+	p.writeReport(&report, program, 0, 5)
+	if !strings.Contains(report.String(), "  block_0007 000a                1          3        3.0\n") {
+		t.Errorf("report missing loop iteration counts:\n%s", report.String())
+	}
+}
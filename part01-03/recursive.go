@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decodedInstruction is an instruction decoded at a known offset of an image.
+type decodedInstruction struct {
+	instr  instruction
+	offset int // Offset of the first byte in the image.
+	size   int // Encoded length in bytes.
+}
+
+// next returns the offset of the instruction that follows d.
+func (d decodedInstruction) next() int {
+	return d.offset + d.size
+}
+
+// decodeAt decodes the instruction starting at offset in b, accepting the
+// instruction set of model.
+func decodeAt(b []byte, offset int, model cpuModel) (decodedInstruction, error) {
+	reader := newPeekableBytReader(b[offset:])
+	reader.model = model
+	instr, err := decodeInstruction(reader)
+	if err != nil {
+		return decodedInstruction{}, err
+	}
+	return decodedInstruction{instr: instr, offset: offset, size: int(reader.Size()) - reader.Len()}, nil
+}
+
+// controlFlow reports where control can go after instr, given the offset of
+// the instruction that follows it. Calls are assumed to return.
+func controlFlow(instr instruction, next uint16) (targets []uint16, fallsThrough bool) {
+	switch in := instr.(type) {
+	case *jumpOrLoop:
+		return []uint16{next + uint16(int16(in.inc))}, true
+	case *branch:
+		switch in.op {
+		case opCallNear:
+			return []uint16{next + uint16(in.disp)}, true
+		case opJmpNear, opJmpShort:
+			return []uint16{next + uint16(in.disp)}, false
+		default: // RET
+			return nil, false
+		}
+	default:
+		return nil, true
+	}
+}
+
+// withLabel returns f with its relative transfer target replaced by label.
+func withLabel(f form, label string) form {
+	for i, op := range f.operands {
+		if op.kind == operandRelative {
+			f.operands[i] = operand{kind: operandLabel, label: label}
+		}
+	}
+	return f
+}
+
+// codeMap is the result of a recursive traversal: which bytes of an image
+// are reachable code and which offsets are transfer targets.
+type codeMap struct {
+	image   []byte
+	instrs  map[int]decodedInstruction // Keyed by start offset.
+	covered []bool                     // True for every byte of a decoded instruction.
+	targets map[int]bool               // Offsets jumped, looped or called to.
+	symbols *symbolTable               // Names and data ranges, if loaded.
+}
+
+func newCodeMap(b []byte, symbols *symbolTable) *codeMap {
+	return &codeMap{
+		image:   b,
+		instrs:  make(map[int]decodedInstruction),
+		covered: make([]bool, len(b)),
+		targets: make(map[int]bool),
+		symbols: symbols,
+	}
+}
+
+// traverse decodes b for model starting at the entry points and any code
+// symbols, following jumps, loops, calls and fallthrough. Bytes that are
+// never reached, that fail to decode where they are reached or that a symbol
+// declares as data are left as data.
+func traverse(b []byte, entries []int, model cpuModel, symbols *symbolTable) *codeMap {
+	cm := newCodeMap(b, symbols)
+
+	work := append([]int(nil), entries...)
+	work = append(work, symbols.code()...)
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for pc >= 0 && pc < len(b) && !cm.covered[pc] {
+			d, err := decodeAt(b, pc, model)
+			if err != nil || cm.overlaps(d) {
+				break
+			}
+			cm.instrs[pc] = d
+			for i := pc; i < d.next(); i++ {
+				cm.covered[i] = true
+			}
+
+			targets, fallsThrough := controlFlow(d.instr, uint16(d.next()))
+			for _, t := range targets {
+				if int(t) < len(b) {
+					cm.targets[int(t)] = true
+					work = append(work, int(t))
+				}
+			}
+			if !fallsThrough {
+				break
+			}
+			pc = d.next()
+		}
+	}
+	return cm
+}
+
+// sweep decodes b for model by linear sweep, skipping the data ranges of
+// symbols. Unlike traverse it fails on bytes that do not decode.
+func sweep(b []byte, model cpuModel, symbols *symbolTable) (*codeMap, error) {
+	cm := newCodeMap(b, symbols)
+	for off := 0; off < len(b); {
+		if s, ok := symbols.dataAt(off); ok {
+			off = s.end()
+			continue
+		}
+		d, err := decodeAt(b, off, model)
+		if err != nil {
+			return nil, err
+		}
+		if cm.overlaps(d) {
+			return nil, fmt.Errorf("instruction at %#04x runs into data or past the end of the image", off)
+		}
+		cm.instrs[off] = d
+		for i := off; i < d.next(); i++ {
+			cm.covered[i] = true
+		}
+		off = d.next()
+	}
+	return cm, nil
+}
+
+// overlaps reports whether d runs past the image, into an instruction that
+// has already been decoded or into a data symbol.
+func (cm *codeMap) overlaps(d decodedInstruction) bool {
+	if d.next() > len(cm.image) {
+		return true
+	}
+	for i := d.offset; i < d.next(); i++ {
+		if cm.covered[i] {
+			return true
+		}
+		if _, ok := cm.symbols.dataAt(i); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// labels names the offsets that can carry a label, the start of a decoded
+// instruction or a data byte, when they are transfer targets or have a
+// symbol. Symbol names take precedence. Targets inside an instruction keep
+// their relative form.
+func (cm *codeMap) labels() map[int]string {
+	labels := make(map[int]string)
+	for t := range cm.targets {
+		if _, ok := cm.instrs[t]; ok || !cm.covered[t] {
+			labels[t] = fmt.Sprintf("label_%04x", t)
+		}
+	}
+	if cm.symbols != nil {
+		for addr, s := range cm.symbols.byAddr {
+			if addr >= len(cm.image) {
+				continue
+			}
+			if _, ok := cm.instrs[addr]; ok || !cm.covered[addr] {
+				labels[addr] = s.name
+			}
+		}
+	}
+	return labels
+}
+
+// text renders a decoded instruction with p, naming its target if it has a
+// label and its direct memory operand if it has a symbol.
+func (cm *codeMap) text(p printer, d decodedInstruction, labels map[int]string) string {
+	f := cm.symbols.form(d)
+	targets, _ := controlFlow(d.instr, uint16(d.next()))
+	if len(targets) == 1 {
+		if name, ok := labels[int(targets[0])]; ok {
+			f = withLabel(f, name)
+		}
+	}
+	return p.print(f)
+}
+
+// dataRun returns the end of the data starting at off, which is not code,
+// and the size of its elements. Runs hold at most dbLineBytes bytes and end
+// at labels, which include the start of every data symbol, and
+// instructions. Inside a data symbol the run holds whole
+// elements of the symbol's type, and elsewhere it holds bytes.
+func (cm *codeMap) dataRun(off int, labels map[int]string) (end, size int) {
+	if s, ok := cm.symbols.dataAt(off); ok && (off-s.addr)%s.size == 0 {
+		limit := min(s.end(), len(cm.image))
+		end = off
+		for end+s.size <= limit && end-off < dbLineBytes && (end == off || labels[end] == "") {
+			end += s.size
+		}
+		if end > off {
+			return end, s.size
+		}
+	}
+
+	end = off + 1
+	for end < len(cm.image) && end-off < dbLineBytes && !cm.covered[end] && labels[end] == "" {
+		end++
+	}
+	return end, 1
+}
+
+// write renders the code map with p: labels, instructions and data.
+func (cm *codeMap) write(p printer) string {
+	labels := cm.labels()
+
+	var output bytes.Buffer
+	output.WriteString(p.header())
+	output.WriteByte('\n')
+	for off := 0; off < len(cm.image); {
+		if name, ok := labels[off]; ok {
+			fmt.Fprintf(&output, "%s:\n", name)
+		}
+
+		if d, ok := cm.instrs[off]; ok {
+			output.WriteString(cm.text(p, d, labels))
+			output.WriteByte('\n')
+			off = d.next()
+			continue
+		}
+
+		end, size := cm.dataRun(off, labels)
+		output.WriteString(p.data(cm.image[off:end], size))
+		output.WriteByte('\n')
+		off = end
+	}
+	return output.String()
+}
+
+// offsets returns the start offsets of all decoded instructions in order.
+func (cm *codeMap) offsets() []int {
+	offsets := make([]int, 0, len(cm.instrs))
+	for off := range cm.instrs {
+		offsets = append(offsets, off)
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// checkEntries verifies that every entry point lies inside the image.
+func checkEntries(b []byte, entries []int) error {
+	for _, e := range entries {
+		if e < 0 || e >= len(b) {
+			return fmt.Errorf("entry point %#x is outside the %d byte image", e, len(b))
+		}
+	}
+	return nil
+}
+
+// dbLineBytes is the maximum number of data bytes emitted per db line.
+const dbLineBytes = 8
+
+// disassembleRecursive disassembles b by recursive traversal from the given
+// entry points, printing it with p. Reachable bytes are decoded as code and
+// everything else is emitted as data, so embedded data cannot desynchronise
+// the decoder.
+func disassembleRecursive(b []byte, entries []int, p printer, model cpuModel, symbols *symbolTable) (string, error) {
+	if err := checkEntries(b, entries); err != nil {
+		return "", err
+	}
+	return traverse(b, entries, model, symbols).write(p), nil
+}
+
+// entryList is a flag.Value collecting comma separated entry point offsets.
+type entryList []int
+
+func (e *entryList) String() string {
+	parts := make([]string, len(*e))
+	for i, v := range *e {
+		parts[i] = fmt.Sprintf("%#x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (e *entryList) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(part), 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid entry point %q: %w", part, err)
+		}
+		*e = append(*e, int(v))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestDisassembleRecursiveSeparatesData(t *testing.T) {
+	input := []byte{
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0xeb, 0x04, // jmp short label_0009
+		0x12, 0x34, 0x56, 0x78, // data
+		0x05, 0x02, 0x00, // label_0009: add ax, strict word 2
+		0xe2, 0xfb, // loop label_0009
+		0xe8, 0x01, 0x00, // call label_0012
+		0xc3,       // ret
+		0x01, 0xd8, // label_0012: add ax, bx
+		0xc3,       // ret
+		0xff, 0xff, // data
+	}
+
+	expectedOutput := `bits 16
+mov cx, 3
+jmp short label_0009
+db 0x12, 0x34, 0x56, 0x78
+label_0009:
+add ax, strict word 2
+loop label_0009
+call label_0012
+ret
+label_0012:
+add ax, bx
+ret
+db 0xff, 0xff
+`
+
+	result, err := disassembleRecursive(input, []int{0}, nasm, cpu8086, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expectedOutput {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expectedOutput, result)
+	}
+}
+
+func TestDisassembleRecursiveExtraEntryPoints(t *testing.T) {
+	input := []byte{
+		0xc3,             // ret
+		0xb8, 0x01, 0x00, // mov ax, 1, only reachable from an extra entry point
+	}
+
+	result, err := disassembleRecursive(input, []int{0}, nasm, cpu8086, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "bits 16\nret\ndb 0xb8, 0x01, 0x00\n"; result != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, result)
+	}
+
+	result, err = disassembleRecursive(input, []int{0, 1}, nasm, cpu8086, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "bits 16\nret\nmov ax, 1\n"; result != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, result)
+	}
+}
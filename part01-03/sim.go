@@ -0,0 +1,699 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Register indices follow the reg/rm encoding used by the decoder for wide operands.
+const (
+	regAX = iota
+	regCX
+	regDX
+	regBX
+	regSP
+	regBP
+	regSI
+	regDI
+)
+
+// Segment register indices follow the sreg encoding used by the 8086.
+const (
+	segES = iota
+	segCS
+	segSS
+	segDS
+)
+
+// segNames maps the segment register index to its string representation.
+var segNames = []string{"es", "cs", "ss", "ds"}
+
+// Flag bits of the 8086 FLAGS register.
+const (
+	flagCF uint16 = 1 << 0
+	flagPF uint16 = 1 << 2
+	flagAF uint16 = 1 << 4
+	flagZF uint16 = 1 << 6
+	flagSF uint16 = 1 << 7
+	flagTF uint16 = 1 << 8
+	flagIF uint16 = 1 << 9
+	flagDF uint16 = 1 << 10
+	flagOF uint16 = 1 << 11
+)
+
+// flagLetters lists the flags in the order they are printed in traces.
+var flagLetters = []struct {
+	mask   uint16
+	letter byte
+}{
+	{flagCF, 'C'},
+	{flagPF, 'P'},
+	{flagAF, 'A'},
+	{flagZF, 'Z'},
+	{flagSF, 'S'},
+	{flagTF, 'T'},
+	{flagIF, 'I'},
+	{flagDF, 'D'},
+	{flagOF, 'O'},
+}
+
+// flagString renders the set flags as letters, e.g. "CPZ".
+func flagString(flags uint16) string {
+	var sb strings.Builder
+	for _, f := range flagLetters {
+		if flags&f.mask != 0 {
+			sb.WriteByte(f.letter)
+		}
+	}
+	return sb.String()
+}
+
+// memorySize is the size of the 8086's 20-bit physical address space.
+const memorySize = 1 << 20
+
+// linearAddress converts a segment:offset pair into a 20-bit physical address.
+func linearAddress(seg, off uint16) uint32 {
+	return (uint32(seg)<<4 + uint32(off)) & (memorySize - 1)
+}
+
+// registers is the architectural register state of the CPU.
+type registers struct {
+	regs  [8]uint16 // General purpose registers, indexed by reg encoding.
+	sregs [4]uint16 // Segment registers, indexed by sreg encoding.
+	ip    uint16    // Instruction pointer.
+	flags uint16    // FLAGS register.
+}
+
+// reg returns the value of the register with the given index and operand size.
+// Byte registers 4-7 address the high halves of ax, cx, dx and bx.
+func (r *registers) reg(idx byte, wide bool) uint16 {
+	if wide {
+		return r.regs[idx]
+	}
+	if idx < 4 {
+		return r.regs[idx] & 0xff
+	}
+	return r.regs[idx-4] >> 8
+}
+
+// setReg writes the register with the given index and operand size.
+func (r *registers) setReg(idx byte, wide bool, v uint16) {
+	switch {
+	case wide:
+		r.regs[idx] = v
+	case idx < 4:
+		r.regs[idx] = r.regs[idx]&0xff00 | v&0xff
+	default:
+		r.regs[idx-4] = r.regs[idx-4]&0x00ff | v<<8
+	}
+}
+
+// named returns the register values keyed by register name, including ip.
+func (r *registers) named() map[string]uint16 {
+	m := map[string]uint16{"ip": r.ip}
+	for i, name := range regs[true] {
+		m[name] = r.regs[i]
+	}
+	for i, name := range segNames {
+		m[name] = r.sregs[i]
+	}
+	return m
+}
+
+// memWrite records a single byte written to memory by an instruction.
+type memWrite struct {
+	Addr uint32 `json:"addr"`
+	Old  byte   `json:"old"`
+	New  byte   `json:"new"`
+}
+
+// cpu is a simulated 8086 with its full 1 MB address space.
+type cpu struct {
+	registers
+	mem   []byte
+	model cpuModel // Instruction set accepted when decoding.
+	fpu   *x87     // Attached 8087, or nil.
+
+	decoded decodeCache // Instructions decoded so far.
+
+	codeEnd uint32 // Linear address one past the last byte of the loaded program.
+	steps   uint64 // Number of instructions executed.
+	clocks  uint64 // Estimated clocks spent executing those instructions.
+
+	// penalty accumulates the odd-address word transfer clocks of the current instruction.
+	penalty int
+
+	// trackWrites enables recording of memory writes into writes, which is
+	// reset at the start of every step.
+	trackWrites bool
+	writes      []memWrite
+}
+
+// newCPU creates a CPU with zeroed registers and memory.
+func newCPU() *cpu {
+	return &cpu{mem: make([]byte, memorySize)}
+}
+
+// load copies a program to CS:0 and points IP at its first byte.
+func (c *cpu) load(program []byte) {
+	start := c.imageStart()
+	n := copy(c.mem[start:], program)
+	c.decoded.reset(c.model)
+	c.ip = 0
+	c.codeEnd = start + uint32(n)
+}
+
+// imageStart returns the linear address the program image starts at.
+func (c *cpu) imageStart() uint32 {
+	return linearAddress(c.sregs[segCS], 0)
+}
+
+// halted reports whether IP has run off the end of the loaded program.
+func (c *cpu) halted() bool {
+	return linearAddress(c.sregs[segCS], c.ip) >= c.codeEnd
+}
+
+// stepResult describes a single executed instruction.
+type stepResult struct {
+	instr  instruction
+	addr   uint32 // Linear address the instruction was fetched from.
+	size   int    // Encoded length in bytes.
+	clocks int    // Estimated clocks, including odd-address penalties.
+	taken  bool   // Whether a conditional transfer was taken.
+}
+
+// step decodes and executes the instruction at CS:IP.
+func (c *cpu) step() (stepResult, error) {
+	addr := linearAddress(c.sregs[segCS], c.ip)
+	d, err := c.decode(addr)
+	if err != nil {
+		return stepResult{}, fmt.Errorf("decoding at %#05x: %w", addr, err)
+	}
+	instr, size := d.instr, d.size
+
+	c.penalty = 0
+	c.writes = c.writes[:0]
+	c.ip += uint16(size)
+	taken, err := c.execute(instr)
+	if err != nil {
+		return stepResult{}, fmt.Errorf("executing at %#05x: %w", addr, err)
+	}
+
+	clocks := instructionClocks(instr, taken) + c.penalty
+	c.steps++
+	c.clocks += uint64(clocks)
+	return stepResult{instr: instr, addr: addr, size: size, clocks: clocks, taken: taken}, nil
+}
+
+// execute applies instr to the CPU state. IP must already point past the
+// instruction. It reports whether a conditional transfer was taken.
+func (c *cpu) execute(instr instruction) (bool, error) {
+	switch in := instr.(type) {
+	case *mov:
+		return false, c.executeMov(in)
+	case *arithmetic:
+		return false, c.executeArithmetic(in)
+	case *jumpOrLoop:
+		return c.executeJumpOrLoop(in), nil
+	case *branch:
+		c.executeBranch(in)
+		return false, nil
+	case *implied:
+		c.executeImplied(in)
+		return false, nil
+	case *pushImmediate:
+		c.push(in.data)
+		return false, nil
+	case *imulImmediate:
+		c.executeImul(in)
+		return false, nil
+	case *shiftImmediate:
+		wide := in.w == 1
+		c.writeRM(in.common, wide, c.shift(in.reg, c.readRM(in.common, wide), in.count, wide))
+		return false, nil
+	case *enter:
+		c.executeEnter(in)
+		return false, nil
+	case *bound:
+		return false, c.executeBound(in)
+	case *esc:
+		return false, c.executeEsc(in)
+	case *wait:
+		// The simulated 8087 finishes every instruction before the next.
+		return false, nil
+	default:
+		return false, fmt.Errorf("cannot execute %q", instr.disassemble())
+	}
+}
+
+func (c *cpu) executeMov(m *mov) error {
+	wide := m.w == 1
+	switch m.typ {
+	case movRegisterMemToFromRegister:
+		if m.d == 1 {
+			c.setReg(m.reg, wide, c.readRM(m.common, wide))
+		} else {
+			c.writeRM(m.common, wide, c.reg(m.reg, wide))
+		}
+	case movImmediateToRegister:
+		c.setReg(m.reg, wide, m.data)
+	case movImmediateToMemoryOrRegister:
+		c.writeRM(m.common, wide, m.data)
+	case movMemoryToAccumulator:
+		c.setReg(regAX, wide, c.readMem(c.sregs[segDS], m.data, wide))
+	case movAccumulatorToMemory:
+		c.writeMem(c.sregs[segDS], m.data, wide, c.reg(regAX, wide))
+	default:
+		return fmt.Errorf("invalid mov type: %d", m.typ)
+	}
+	return nil
+}
+
+func (c *cpu) executeArithmetic(a *arithmetic) error {
+	wide := a.w == 1
+	switch a.typ {
+	case arithmeticRegOrMemWithRegToEither:
+		if a.d == 1 {
+			res := c.arith(a.op, c.reg(a.reg, wide), c.readRM(a.common, wide), wide)
+			if a.op != arithmeticCmp {
+				c.setReg(a.reg, wide, res)
+			}
+		} else {
+			res := c.arith(a.op, c.readRM(a.common, wide), c.reg(a.reg, wide), wide)
+			if a.op != arithmeticCmp {
+				c.writeRM(a.common, wide, res)
+			}
+		}
+	case arithmeticImmediateToRegOrMem:
+		res := c.arith(a.op, c.readRM(a.common, wide), a.data, wide)
+		if a.op != arithmeticCmp {
+			c.writeRM(a.common, wide, res)
+		}
+	case arithmeticImmediateToAccumulator:
+		res := c.arith(a.op, c.reg(regAX, wide), a.data, wide)
+		if a.op != arithmeticCmp {
+			c.setReg(regAX, wide, res)
+		}
+	default:
+		return fmt.Errorf("invalid arithmetic type: %d", a.typ)
+	}
+	return nil
+}
+
+// arith computes dst op src at the given width, updates the arithmetic flags
+// and returns the result.
+func (c *cpu) arith(op arithmeticOp, dst, src uint16, wide bool) uint16 {
+	mask, sign := uint32(0xff), uint32(0x80)
+	if wide {
+		mask, sign = 0xffff, 0x8000
+	}
+
+	a, b := uint32(dst)&mask, uint32(src)&mask
+	var res uint32
+	var carry, overflow bool
+	switch op {
+	case arithmeticAdd:
+		res = a + b
+		carry = res > mask
+		overflow = (a^res)&(b^res)&sign != 0
+	default: // arithmeticSub, arithmeticCmp
+		res = a - b
+		carry = b > a
+		overflow = (a^b)&(a^res)&sign != 0
+	}
+	res &= mask
+
+	flags := c.flags &^ (flagCF | flagPF | flagAF | flagZF | flagSF | flagOF)
+	if carry {
+		flags |= flagCF
+	}
+	if bits.OnesCount8(uint8(res))%2 == 0 {
+		flags |= flagPF
+	}
+	if (a^b^res)&0x10 != 0 {
+		flags |= flagAF
+	}
+	if res == 0 {
+		flags |= flagZF
+	}
+	if res&sign != 0 {
+		flags |= flagSF
+	}
+	if overflow {
+		flags |= flagOF
+	}
+	c.flags = flags
+	return uint16(res)
+}
+
+func (c *cpu) executeJumpOrLoop(j *jumpOrLoop) bool {
+	var taken bool
+	if (j.op >> 4) == 0b0111 {
+		taken = c.condition(j.op)
+	} else {
+		cx := c.regs[regCX]
+		if j.op&0b11 != 0b11 { // jcxz does not decrement cx.
+			cx--
+			c.regs[regCX] = cx
+		}
+		switch j.op & 0b11 {
+		case 0b00: // loopnz
+			taken = cx != 0 && c.flags&flagZF == 0
+		case 0b01: // loopz
+			taken = cx != 0 && c.flags&flagZF != 0
+		case 0b10: // loop
+			taken = cx != 0
+		case 0b11: // jcxz
+			taken = cx == 0
+		}
+	}
+
+	if taken {
+		c.ip += uint16(int16(j.inc))
+	}
+	return taken
+}
+
+func (c *cpu) executeBranch(b *branch) {
+	switch b.op {
+	case opCallNear:
+		c.push(c.ip)
+		c.ip += uint16(b.disp)
+	case opJmpNear, opJmpShort:
+		c.ip += uint16(b.disp)
+	case opRetNear, opRetNearImm:
+		c.ip = c.pop()
+		c.regs[regSP] += b.data
+	}
+}
+
+// executeImplied runs the operand-less 80186 instructions. No I/O devices
+// are attached, so INS stores all ones, as an undriven bus reads, and OUTS
+// reads its source and discards it.
+func (c *cpu) executeImplied(i *implied) {
+	switch i.op {
+	case opPusha:
+		sp := c.regs[regSP]
+		for r := regAX; r <= regDI; r++ {
+			if r == regSP {
+				c.push(sp)
+			} else {
+				c.push(c.regs[r])
+			}
+		}
+	case opPopa:
+		for r := regDI; r >= regAX; r-- {
+			v := c.pop()
+			if r != regSP { // The stored SP is skipped.
+				c.regs[r] = v
+			}
+		}
+	case opLeave:
+		c.regs[regSP] = c.regs[regBP]
+		c.regs[regBP] = c.pop()
+	case opInsb, opInsw:
+		wide := i.op == opInsw
+		c.writeMem(c.sregs[segES], c.regs[regDI], wide, 0xffff)
+		c.regs[regDI] += c.stringStep(wide)
+	case opOutsb, opOutsw:
+		wide := i.op == opOutsw
+		c.readMem(c.sregs[segDS], c.regs[regSI], wide)
+		c.regs[regSI] += c.stringStep(wide)
+	}
+}
+
+// stringStep returns the amount string instructions add to SI or DI: the
+// operand size, negated when the direction flag is set.
+func (c *cpu) stringStep(wide bool) uint16 {
+	step := uint16(1)
+	if wide {
+		step = 2
+	}
+	if c.flags&flagDF != 0 {
+		return -step
+	}
+	return step
+}
+
+// executeImul keeps the low word of the signed product. CF and OF report
+// whether the product needed more than a word; the other flags are undefined
+// and left unchanged.
+func (c *cpu) executeImul(m *imulImmediate) {
+	product := int32(int16(c.readRM(m.common, true))) * int32(int16(m.data))
+	c.setReg(m.reg, true, uint16(product))
+	c.flags &^= flagCF | flagOF
+	if product != int32(int16(product)) {
+		c.flags |= flagCF | flagOF
+	}
+}
+
+// shift applies the shift group operation op to v count times. Like the
+// 80186, only the low 5 bits of the count are used. Rotates only change CF
+// and OF; shifts also set SF, ZF and PF from the result. OF is only defined
+// for single bit shifts but is computed the same way for longer ones.
+func (c *cpu) shift(op byte, v uint16, count uint8, wide bool) uint16 {
+	count &= 0x1f
+	if count == 0 {
+		return v
+	}
+
+	mask, sign := uint16(0xff), uint16(0x80)
+	if wide {
+		mask, sign = 0xffff, 0x8000
+	}
+	v &= mask
+	orig := v
+	cf := c.flags&flagCF != 0
+	for i := uint8(0); i < count; i++ {
+		switch op {
+		case 0b000: // rol
+			cf = v&sign != 0
+			v = (v << 1) & mask
+			if cf {
+				v |= 1
+			}
+		case 0b001: // ror
+			cf = v&1 != 0
+			v >>= 1
+			if cf {
+				v |= sign
+			}
+		case 0b010: // rcl
+			out := v&sign != 0
+			v = (v << 1) & mask
+			if cf {
+				v |= 1
+			}
+			cf = out
+		case 0b011: // rcr
+			out := v&1 != 0
+			v >>= 1
+			if cf {
+				v |= sign
+			}
+			cf = out
+		case 0b100, 0b110: // shl, sal
+			cf = v&sign != 0
+			v = (v << 1) & mask
+		case 0b101: // shr
+			cf = v&1 != 0
+			v >>= 1
+		case 0b111: // sar
+			cf = v&1 != 0
+			v = v>>1 | v&sign
+		}
+	}
+
+	var of bool
+	switch op {
+	case 0b000, 0b010, 0b100, 0b110:
+		of = (v&sign != 0) != cf
+	case 0b001, 0b011:
+		of = (v&sign != 0) != (v&(sign>>1) != 0)
+	case 0b101:
+		of = orig&sign != 0
+	}
+
+	flags := c.flags &^ (flagCF | flagOF)
+	if cf {
+		flags |= flagCF
+	}
+	if of {
+		flags |= flagOF
+	}
+	if op >= 0b100 {
+		flags &^= flagSF | flagZF | flagPF
+		if v&sign != 0 {
+			flags |= flagSF
+		}
+		if v == 0 {
+			flags |= flagZF
+		}
+		if bits.OnesCount8(uint8(v))%2 == 0 {
+			flags |= flagPF
+		}
+	}
+	c.flags = flags
+	return v
+}
+
+// executeEnter builds a stack frame, copying level-1 frame pointers of the
+// enclosing procedures. Like the 80186, only the low 5 bits of the level are
+// used.
+func (c *cpu) executeEnter(e *enter) {
+	level := e.level & 0x1f
+	c.push(c.regs[regBP])
+	frame := c.regs[regSP]
+	if level > 0 {
+		for i := uint8(1); i < level; i++ {
+			c.regs[regBP] -= 2
+			c.push(c.readMem(c.sregs[segSS], c.regs[regBP], true))
+		}
+		c.push(frame)
+	}
+	c.regs[regBP] = frame
+	c.regs[regSP] -= e.size
+}
+
+// executeBound checks a signed register against the bounds in memory. The
+// simulator has no interrupt support, so a failed check, which raises
+// interrupt 5, stops the simulation.
+func (c *cpu) executeBound(b *bound) error {
+	seg, off := c.effectiveAddress(b.common)
+	lower := int16(c.readMem(seg, off, true))
+	upper := int16(c.readMem(seg, off+2, true))
+	if v := int16(c.reg(b.reg, true)); v < lower || v > upper {
+		return fmt.Errorf("bound: %d is outside [%d, %d] and interrupt 5 is not simulated", v, lower, upper)
+	}
+	return nil
+}
+
+// push pushes a word onto the stack at SS:SP.
+func (c *cpu) push(v uint16) {
+	c.regs[regSP] -= 2
+	c.writeMem(c.sregs[segSS], c.regs[regSP], true, v)
+}
+
+// pop pops a word from the stack at SS:SP.
+func (c *cpu) pop() uint16 {
+	v := c.readMem(c.sregs[segSS], c.regs[regSP], true)
+	c.regs[regSP] += 2
+	return v
+}
+
+// condition evaluates the condition encoded in the low nibble of a Jcc opcode.
+// Odd opcodes are the negation of the preceding even one.
+func (c *cpu) condition(op byte) bool {
+	f := c.flags
+	var cond bool
+	switch (op >> 1) & 0b111 {
+	case 0b000: // jo
+		cond = f&flagOF != 0
+	case 0b001: // jb
+		cond = f&flagCF != 0
+	case 0b010: // jz
+		cond = f&flagZF != 0
+	case 0b011: // jbe
+		cond = f&(flagCF|flagZF) != 0
+	case 0b100: // js
+		cond = f&flagSF != 0
+	case 0b101: // jp
+		cond = f&flagPF != 0
+	case 0b110: // jl
+		cond = (f&flagSF != 0) != (f&flagOF != 0)
+	case 0b111: // jle
+		cond = f&flagZF != 0 || (f&flagSF != 0) != (f&flagOF != 0)
+	}
+	if op&1 == 1 {
+		return !cond
+	}
+	return cond
+}
+
+// effectiveAddress computes the segment and offset of a memory operand.
+// Addressing modes based on bp default to the stack segment.
+func (c *cpu) effectiveAddress(m common) (uint16, uint16) {
+	if m.mod == 0b00 && m.rm == 0b110 {
+		return c.sregs[segDS], uint16(m.disp)
+	}
+
+	seg := c.sregs[segDS]
+	var base uint16
+	switch m.rm {
+	case 0b000:
+		base = c.regs[regBX] + c.regs[regSI]
+	case 0b001:
+		base = c.regs[regBX] + c.regs[regDI]
+	case 0b010:
+		base = c.regs[regBP] + c.regs[regSI]
+		seg = c.sregs[segSS]
+	case 0b011:
+		base = c.regs[regBP] + c.regs[regDI]
+		seg = c.sregs[segSS]
+	case 0b100:
+		base = c.regs[regSI]
+	case 0b101:
+		base = c.regs[regDI]
+	case 0b110:
+		base = c.regs[regBP]
+		seg = c.sregs[segSS]
+	case 0b111:
+		base = c.regs[regBX]
+	}
+	return seg, base + uint16(m.disp)
+}
+
+// readRM reads the register or memory operand described by m.
+func (c *cpu) readRM(m common, wide bool) uint16 {
+	if m.mod == 0b11 {
+		return c.reg(m.rm, wide)
+	}
+	seg, off := c.effectiveAddress(m)
+	return c.readMem(seg, off, wide)
+}
+
+// writeRM writes the register or memory operand described by m.
+func (c *cpu) writeRM(m common, wide bool, v uint16) {
+	if m.mod == 0b11 {
+		c.setReg(m.rm, wide, v)
+		return
+	}
+	seg, off := c.effectiveAddress(m)
+	c.writeMem(seg, off, wide, v)
+}
+
+// readMem reads a byte or little-endian word. The high byte of a word wraps
+// within the segment.
+func (c *cpu) readMem(seg, off uint16, wide bool) uint16 {
+	lo := uint16(c.mem[linearAddress(seg, off)])
+	if !wide {
+		return lo
+	}
+	c.chargeWordTransfer(off)
+	return uint16(c.mem[linearAddress(seg, off+1)])<<8 | lo
+}
+
+// writeMem writes a byte or little-endian word.
+func (c *cpu) writeMem(seg, off uint16, wide bool, v uint16) {
+	c.writeByte(linearAddress(seg, off), byte(v))
+	if wide {
+		c.chargeWordTransfer(off)
+		c.writeByte(linearAddress(seg, off+1), byte(v>>8))
+	}
+}
+
+func (c *cpu) writeByte(addr uint32, v byte) {
+	if c.trackWrites {
+		c.writes = append(c.writes, memWrite{Addr: addr, Old: c.mem[addr], New: v})
+	}
+	c.mem[addr] = v
+	c.decoded.invalidate(addr)
+}
+
+// chargeWordTransfer adds the 8086's extra bus cycle for word accesses at odd addresses.
+func (c *cpu) chargeWordTransfer(off uint16) {
+	if off&1 == 1 {
+		c.penalty += 4
+	}
+}
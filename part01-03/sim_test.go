@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestSimulateArithmeticAndLoop(t *testing.T) {
+	program := []byte{
+		0xbb, 0x03, 0xf0, // mov bx, -4093
+		0xb9, 0x01, 0x0f, // mov cx, 3841
+		0x29, 0xcb, // sub bx, cx
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0x05, 0x02, 0x00, // add ax, strict word 2
+		0xe2, 0xfb, // loop $+2-5
+		0xc7, 0x06, 0xe8, 0x03, 0x01, 0x00, // mov [1000], word 1
+	}
+
+	c := newCPU()
+	c.load(program)
+	for !c.halted() {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := c.regs[regAX]; got != 6 {
+		t.Errorf("ax = %d, want 6", got)
+	}
+	if got := c.regs[regBX]; got != 0xe102 {
+		t.Errorf("bx = %#x, want 0xe102", got)
+	}
+	if got := c.regs[regCX]; got != 0 {
+		t.Errorf("cx = %d, want 0", got)
+	}
+	if got := flagString(c.flags); got != "P" {
+		t.Errorf("flags = %q, want %q", got, "P")
+	}
+	if got := c.readMem(0, 1000, true); got != 1 {
+		t.Errorf("[1000] = %d, want 1", got)
+	}
+	if c.steps != 11 {
+		t.Errorf("steps = %d, want 11", c.steps)
+	}
+	if c.clocks != 82 {
+		t.Errorf("clocks = %d, want 82", c.clocks)
+	}
+}
+
+func TestArithFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       arithmeticOp
+		dst, src uint16
+		wide     bool
+		want     uint16
+		flags    string
+	}{
+		{"add carry byte", arithmeticAdd, 0xff, 0x01, false, 0x00, "CPAZ"},
+		{"add overflow word", arithmeticAdd, 0x7fff, 0x0001, true, 0x8000, "PASO"},
+		{"sub borrow", arithmeticSub, 0x0000, 0x0001, true, 0xffff, "CPAS"},
+		{"cmp equal", arithmeticCmp, 0x1234, 0x1234, true, 0x0000, "PZ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCPU()
+			got := c.arith(tt.op, tt.dst, tt.src, tt.wide)
+			if got != tt.want {
+				t.Errorf("result = %#x, want %#x", got, tt.want)
+			}
+			if f := flagString(c.flags); f != tt.flags {
+				t.Errorf("flags = %q, want %q", f, tt.flags)
+			}
+		})
+	}
+}
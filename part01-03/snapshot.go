@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Snapshot file layout, all integers little-endian:
+//
+//	snapshotHeader  fixed-size CPU model, register file, IP, flags and counters
+//	device state    snapshotHeader.DeviceLen bytes of device sections
+//	memory          the full 1 MB address space
+//
+// Each device section is a 4-byte tag naming the device, a uint32 payload
+// length and the payload, so a device can change its state layout under a
+// new tag without disturbing the others. The only device today is the
+// coprocessor, written by x87.state under snapshotX87Tag when one is
+// attached; without one the block is empty.
+const (
+	snapshotMagic   = "SIM86SNP"
+	snapshotVersion = 3
+	snapshotX87Tag  = "X87 "
+)
+
+// maxDeviceLen bounds the device block a snapshot may declare, so that a
+// corrupt length cannot make readSnapshot allocate gigabytes.
+const maxDeviceLen = 64 << 10
+
+// snapshotHeader is the fixed-size part of a snapshot file.
+type snapshotHeader struct {
+	Magic     [8]byte
+	Version   uint16
+	Model     uint8 // cpuModel the snapshot was taken with.
+	Regs      [8]uint16
+	Sregs     [4]uint16
+	IP        uint16
+	Flags     uint16
+	CodeEnd   uint32
+	Steps     uint64
+	Clocks    uint64
+	DeviceLen uint32
+}
+
+var errBadSnapshot = errors.New("not a simulator snapshot")
+
+// writeSnapshot serialises the full CPU state to w.
+func writeSnapshot(w io.Writer, c *cpu) error {
+	hdr := snapshotHeader{
+		Version: snapshotVersion,
+		Model:   uint8(c.model),
+		Regs:    c.regs,
+		Sregs:   c.sregs,
+		IP:      c.ip,
+		Flags:   c.flags,
+		CodeEnd: c.codeEnd,
+		Steps:   c.steps,
+		Clocks:  c.clocks,
+	}
+	copy(hdr.Magic[:], snapshotMagic)
+	var device []byte
+	if c.fpu != nil {
+		device = appendDeviceSection(device, snapshotX87Tag, c.fpu.state())
+	}
+	hdr.DeviceLen = uint32(len(device))
+
+	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(device); err != nil {
+		return err
+	}
+	_, err := w.Write(c.mem)
+	return err
+}
+
+// readSnapshot restores a CPU from a snapshot written by writeSnapshot.
+func readSnapshot(r io.Reader) (*cpu, error) {
+	var hdr snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("reading snapshot header: %w", err)
+	}
+	if string(hdr.Magic[:]) != snapshotMagic {
+		return nil, errBadSnapshot
+	}
+	if hdr.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d (want %d)", hdr.Version, snapshotVersion)
+	}
+	if int(hdr.Model) >= len(cpuModelNames) {
+		return nil, fmt.Errorf("unknown snapshot cpu model %d", hdr.Model)
+	}
+	if hdr.CodeEnd > memorySize {
+		return nil, fmt.Errorf("snapshot code end %#x is outside memory", hdr.CodeEnd)
+	}
+	if hdr.DeviceLen > maxDeviceLen {
+		return nil, fmt.Errorf("snapshot device state of %d bytes exceeds %d", hdr.DeviceLen, maxDeviceLen)
+	}
+
+	c := newCPU()
+	c.model = cpuModel(hdr.Model)
+	if hdr.DeviceLen != 0 {
+		device := make([]byte, hdr.DeviceLen)
+		if _, err := io.ReadFull(r, device); err != nil {
+			return nil, fmt.Errorf("reading snapshot device state: %w", err)
+		}
+		if err := restoreDevices(c, device); err != nil {
+			return nil, fmt.Errorf("reading snapshot device state: %w", err)
+		}
+	}
+
+	if _, err := io.ReadFull(r, c.mem); err != nil {
+		return nil, fmt.Errorf("reading snapshot memory: %w", err)
+	}
+	c.regs = hdr.Regs
+	c.sregs = hdr.Sregs
+	c.ip = hdr.IP
+	c.flags = hdr.Flags
+	c.codeEnd = hdr.CodeEnd
+	c.steps = hdr.Steps
+	c.clocks = hdr.Clocks
+	return c, nil
+}
+
+// appendDeviceSection appends the section for one device's state to b.
+func appendDeviceSection(b []byte, tag string, state []byte) []byte {
+	b = append(b, tag...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(state)))
+	return append(b, state...)
+}
+
+// restoreDevices attaches the devices whose sections make up b to c. It
+// rejects unknown tags rather than silently dropping a device's state.
+func restoreDevices(c *cpu, b []byte) error {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return fmt.Errorf("truncated section header")
+		}
+		tag, n := string(b[:4]), binary.LittleEndian.Uint32(b[4:])
+		b = b[8:]
+		if uint64(n) > uint64(len(b)) {
+			return fmt.Errorf("section %q of %d bytes overruns the device block", tag, n)
+		}
+		state := b[:n]
+		b = b[n:]
+
+		switch tag {
+		case snapshotX87Tag:
+			if c.fpu != nil {
+				return fmt.Errorf("duplicate section %q", tag)
+			}
+			fpu, err := restoreX87(state)
+			if err != nil {
+				return err
+			}
+			c.fpu = fpu
+		default:
+			return fmt.Errorf("unknown device section %q", tag)
+		}
+	}
+	return nil
+}
+
+func writeSnapshotFile(path string, c *cpu) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := writeSnapshot(w, c); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func readSnapshotFile(path string) (*cpu, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := readSnapshot(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
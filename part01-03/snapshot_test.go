@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	program := []byte{
+		0xb9, 0x03, 0x00, // mov cx, 3
+		0x05, 0x02, 0x00, // add ax, strict word 2
+		0xe2, 0xfb, // loop $+2-5
+		0xa3, 0x00, 0x01, // mov [256], ax
+	}
+
+	// Run straight through for reference.
+	want := newCPU()
+	want.load(program)
+	for !want.halted() {
+		if _, err := want.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stop midway, snapshot, restore and finish.
+	c := newCPU()
+	c.load(program)
+	for c.steps < 4 {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.registers != c.registers || got.steps != c.steps || got.clocks != c.clocks {
+		t.Fatalf("restored state %+v does not match saved state %+v", got.registers, c.registers)
+	}
+	for !got.halted() {
+		if _, err := got.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got.registers != want.registers {
+		t.Errorf("registers = %+v, want %+v", got.registers, want.registers)
+	}
+	if got.steps != want.steps || got.clocks != want.clocks {
+		t.Errorf("steps, clocks = %d, %d, want %d, %d", got.steps, got.clocks, want.steps, want.clocks)
+	}
+	if !bytes.Equal(got.mem, want.mem) {
+		t.Error("memory differs from uninterrupted run")
+	}
+}
+
+func TestReadSnapshotRejectsOtherFiles(t *testing.T) {
+	_, err := readSnapshot(bytes.NewReader(make([]byte, 128)))
+	if !errors.Is(err, errBadSnapshot) {
+		t.Errorf("err = %v, want %v", err, errBadSnapshot)
+	}
+}
+
+func TestSnapshotKeepsCPUModel(t *testing.T) {
+	c := newCPU()
+	c.model = cpu80186
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.model != cpu80186 {
+		t.Errorf("model = %v, want %v", got.model, cpu80186)
+	}
+}
+
+func TestReadSnapshotRejectsHugeDeviceState(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, newCPU()); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	// DeviceLen is the last field of the header.
+	off := binary.Size(snapshotHeader{}) - 4
+	binary.LittleEndian.PutUint32(b[off:], 0xffffffff)
+
+	_, err := readSnapshot(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("err = %v, want a device size error", err)
+	}
+}
+
+func TestReadSnapshotRejectsUnknownDevice(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, newCPU()); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	off := binary.Size(snapshotHeader{}) - 4
+	section := appendDeviceSection(nil, "DISK", []byte{1, 2, 3})
+	binary.LittleEndian.PutUint32(b[off:], uint32(len(section)))
+	b = append(b[:off+4], append(section, b[off+4:]...)...)
+
+	_, err := readSnapshot(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "unknown device section") {
+		t.Errorf("err = %v, want an unknown device error", err)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol files name offsets of the image, one symbol per line:
+//
+//	# address  name        [type [count]]
+//	0x0010     draw_pixel
+//	0x03e8     counter     word
+//	0x0400     table       byte 16
+//
+// A symbol without a type names code. A typed symbol names count elements of
+// data, which the disassemblers emit as data rather than decode. Types are
+// byte, word, dword and qword. Offsets are relative to the start of the
+// image, which is also the data segment offset of the loaded program.
+// Everything after # is a comment.
+
+// symbolTypes maps the data types of a symbol file to their sizes in bytes.
+var symbolTypes = map[string]int{"byte": 1, "word": 2, "dword": 4, "qword": 8}
+
+// symbolName matches the names NASM, MASM and GAS all accept.
+var symbolName = regexp.MustCompile(`^[A-Za-z_.?@$][A-Za-z0-9_.?@$]*$`)
+
+// symbol names an offset of the image. Data symbols also describe the bytes
+// there: count elements of size bytes.
+type symbol struct {
+	addr  int
+	name  string
+	size  int // Element size in bytes, 0 for code.
+	count int
+}
+
+// end returns the offset one past the symbol's data.
+func (s symbol) end() int {
+	return s.addr + s.size*s.count
+}
+
+// typeName returns the data type of s, or "" for code.
+func (s symbol) typeName() string {
+	for name, size := range symbolTypes {
+		if size == s.size {
+			return name
+		}
+	}
+	return ""
+}
+
+// symbolTable is a loaded symbol file. A nil *symbolTable has no symbols.
+type symbolTable struct {
+	byAddr map[int]symbol
+	data   []symbol // Data symbols ordered by address.
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{byAddr: make(map[int]symbol)}
+}
+
+// add inserts s, rejecting duplicate names and addresses and overlapping
+// data ranges.
+func (st *symbolTable) add(s symbol) error {
+	if !symbolName.MatchString(s.name) {
+		return fmt.Errorf("invalid symbol name %q", s.name)
+	}
+	if s.addr < 0 || s.addr > 0xffff {
+		return fmt.Errorf("%s: address %#x is outside the segment", s.name, s.addr)
+	}
+	if old, ok := st.byAddr[s.addr]; ok {
+		return fmt.Errorf("%s: address %#04x is already named %s", s.name, s.addr, old.name)
+	}
+	for _, old := range st.byAddr {
+		if old.name == s.name {
+			return fmt.Errorf("%s: defined at both %#04x and %#04x", s.name, old.addr, s.addr)
+		}
+	}
+	if s.size != 0 {
+		if d, ok := st.dataAt(s.addr); ok {
+			return fmt.Errorf("%s: overlaps %s", s.name, d.name)
+		}
+		i := sort.Search(len(st.data), func(i int) bool { return st.data[i].addr > s.addr })
+		if i < len(st.data) && st.data[i].addr < s.end() {
+			return fmt.Errorf("%s: overlaps %s", s.name, st.data[i].name)
+		}
+		st.data = append(st.data, symbol{})
+		copy(st.data[i+1:], st.data[i:])
+		st.data[i] = s
+	}
+	st.byAddr[s.addr] = s
+	return nil
+}
+
+// parseSymbols reads a symbol file.
+func parseSymbols(r io.Reader) (*symbolTable, error) {
+	st := newSymbolTable()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected address, name, and optional type and count", line)
+		}
+
+		addr, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", line, fields[0])
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing name", line)
+		}
+		s := symbol{addr: int(addr), name: fields[1]}
+		if len(fields) > 2 {
+			size, ok := symbolTypes[fields[2]]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown type %q, expected byte, word, dword or qword", line, fields[2])
+			}
+			s.size, s.count = size, 1
+		}
+		if len(fields) > 3 {
+			count, err := strconv.ParseUint(fields[3], 0, 16)
+			if err != nil || count == 0 {
+				return nil, fmt.Errorf("line %d: invalid count %q", line, fields[3])
+			}
+			s.count = int(count)
+		}
+		if err := st.add(s); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func readSymbolFile(path string) (*symbolTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := parseSymbols(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return st, nil
+}
+
+// write renders the table in the symbol file format, ordered by address.
+func (st *symbolTable) write(w io.Writer) error {
+	addrs := make([]int, 0, len(st.byAddr))
+	for addr := range st.byAddr {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# address  name  [type [count]]")
+	for _, addr := range addrs {
+		s := st.byAddr[addr]
+		fmt.Fprintf(bw, "0x%04x  %s", s.addr, s.name)
+		if s.size != 0 {
+			fmt.Fprintf(bw, "  %s", s.typeName())
+			if s.count != 1 {
+				fmt.Fprintf(bw, " %d", s.count)
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// name returns the symbol at addr.
+func (st *symbolTable) name(addr int) (string, bool) {
+	if st == nil {
+		return "", false
+	}
+	s, ok := st.byAddr[addr]
+	return s.name, ok
+}
+
+// dataAt returns the data symbol whose range includes addr.
+func (st *symbolTable) dataAt(addr int) (symbol, bool) {
+	if st == nil {
+		return symbol{}, false
+	}
+	i := sort.Search(len(st.data), func(i int) bool { return st.data[i].addr > addr })
+	if i > 0 && addr < st.data[i-1].end() {
+		return st.data[i-1], true
+	}
+	return symbol{}, false
+}
+
+// reference names a memory address: the symbol at addr, or the data symbol
+// containing it and the offset into it.
+func (st *symbolTable) reference(addr int) (string, int, bool) {
+	if name, ok := st.name(addr); ok {
+		return name, 0, true
+	}
+	if s, ok := st.dataAt(addr); ok {
+		return s.name, addr - s.addr, true
+	}
+	return "", 0, false
+}
+
+// code returns the addresses of the code symbols, which the recursive
+// disassembler also uses as entry points.
+func (st *symbolTable) code() []int {
+	if st == nil {
+		return nil
+	}
+	var addrs []int
+	for addr, s := range st.byAddr {
+		if s.size == 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Ints(addrs)
+	return addrs
+}
+
+// form returns the form of d with its transfer target and direct memory
+// operand replaced by symbol names where the table has them.
+func (st *symbolTable) form(d decodedInstruction) form {
+	f := d.instr.form()
+	if st == nil {
+		return f
+	}
+	targets, _ := controlFlow(d.instr, uint16(d.next()))
+	for i, op := range f.operands {
+		switch {
+		case op.kind == operandRelative && len(targets) == 1:
+			if name, ok := st.name(int(targets[0])); ok {
+				f.operands[i] = operand{kind: operandLabel, label: name}
+			}
+		case op.kind == operandMemory && op.mem.mod == 0b00 && op.mem.rm == 0b110:
+			if name, off, ok := st.reference(int(uint16(op.mem.disp))); ok {
+				f.operands[i].label, f.operands[i].value = name, off
+			}
+		}
+	}
+	return f
+}
+
+// skeleton returns a symbol table for the image traversed by cm: the
+// existing symbols, a label for every other transfer target and a byte array
+// for every run of bytes not reached as code.
+func (cm *codeMap) skeleton() *symbolTable {
+	st := newSymbolTable()
+	if cm.symbols != nil {
+		for _, s := range cm.symbols.byAddr {
+			st.add(s)
+		}
+	}
+	for addr, name := range cm.labels() {
+		if _, ok := st.byAddr[addr]; !ok {
+			st.add(symbol{addr: addr, name: name})
+		}
+	}
+	for off := 0; off < len(cm.image); {
+		if cm.covered[off] {
+			off++
+			continue
+		}
+		if s, ok := st.dataAt(off); ok {
+			off = s.end()
+			continue
+		}
+		end := off + 1
+		for end < len(cm.image) && !cm.covered[end] {
+			if _, ok := st.dataAt(end); ok {
+				break
+			}
+			end++
+		}
+		name, ok := st.name(off)
+		if !ok {
+			name = fmt.Sprintf("data_%04x", off)
+		} else {
+			delete(st.byAddr, off) // Retyped below as data.
+		}
+		st.add(symbol{addr: off, name: name, size: 1, count: end - off})
+		off = end
+	}
+	return st
+}
+
+// runSymbols implements the symbols command, which prints a symbol file
+// skeleton for a binary from a recursive traversal.
+func runSymbols(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("symbols", flag.ExitOnError)
+	var entries entryList
+	fs.Var(&entries, "entry", "extra comma separated entry point `offsets`, in addition to 0")
+	model := cpu8086
+	fs.Var(&model, "cpu", "instruction set to decode: 8086, 80186 or 80286-real")
+	symbolsPath := fs.String("symbols", "", "start from the symbols in `file`, keeping their names")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: symbols [-entry offsets] [-cpu model] [-symbols file] <binary>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected 1 binary, got %d", fs.NArg())
+	}
+
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var st *symbolTable
+	if *symbolsPath != "" {
+		if st, err = readSymbolFile(*symbolsPath); err != nil {
+			return err
+		}
+	}
+	allEntries := append([]int{0}, entries...)
+	if err := checkEntries(b, allEntries); err != nil {
+		return err
+	}
+	return traverse(b, allEntries, model, st).skeleton().write(out)
+}
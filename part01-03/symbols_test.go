@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// symbolProgram calls a routine that stores into the second word of a two
+// word array after the code.
+var symbolProgram = []byte{
+	0xe8, 0x04, 0x00, // call draw_pixel
+	0xa1, 0x0c, 0x00, // mov ax, [counter]
+	0xc3,                   // ret
+	0x89, 0x1e, 0x0e, 0x00, // draw_pixel: mov [counter + 2], bx
+	0xc3,                   // ret
+	0x34, 0x12, 0x78, 0x56, // counter: dw 0x1234, 0x5678
+}
+
+const symbolFile = `# symbols for symbolProgram
+0x0007  draw_pixel
+0x000c  counter  word 2  # two words
+`
+
+func TestParseSymbols(t *testing.T) {
+	st, err := parseSymbols(strings.NewReader(symbolFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := st.name(7); !ok || name != "draw_pixel" {
+		t.Errorf("name(7) = %q, %v", name, ok)
+	}
+	if name, off, ok := st.reference(15); !ok || name != "counter" || off != 3 {
+		t.Errorf("reference(15) = %q, %d, %v, want counter, 3", name, off, ok)
+	}
+	if _, _, ok := st.reference(16); ok {
+		t.Error("reference(16) names an address past the data")
+	}
+	if got := st.code(); len(got) != 1 || got[0] != 7 {
+		t.Errorf("code() = %v, want [7]", got)
+	}
+}
+
+func TestParseSymbolsErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"0x10000 big", "line 1: invalid address"},
+		{"10", "line 1: missing name"},
+		{"10 1st", "invalid symbol name"},
+		{"10 table float", "unknown type"},
+		{"10 table byte 0", "invalid count"},
+		{"10 a\n20 a", "line 2: a: defined at both"},
+		{"10 a\n10 b", "already named a"},
+		{"10 a word 4\n16 b", ""},
+		{"10 a word 4\n17 b byte", "line 2: b: overlaps a"},
+		{"17 b byte\n10 a word 4", "line 2: a: overlaps b"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseSymbols(strings.NewReader(tt.input))
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q: %v", tt.input, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%q: error %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestDisassembleWithSymbols(t *testing.T) {
+	st, err := parseSymbols(strings.NewReader(symbolFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `bits 16
+call draw_pixel
+mov ax, [counter]
+ret
+draw_pixel:
+mov [counter + 2], bx
+ret
+counter:
+dw 0x1234, 0x5678
+`
+	got, err := disassembleRecursive(symbolProgram, []int{0}, nasm, cpu8086, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("recursive:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The linear sweep would decode the data as instructions without the
+	// symbols.
+	got, err = disassembleFile(symbolProgram, nasm, cpu8086, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("linear:\n%s\nwant:\n%s", got, want)
+	}
+
+	got, err = disassembleFile(symbolProgram, attPrinter{}, cpu8086, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"mov %bx, counter+2", ".word 0x1234, 0x5678"} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("att output lacks %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestSimulateLogsSymbols(t *testing.T) {
+	st, err := parseSymbols(strings.NewReader(symbolFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newCPU()
+	c.load(symbolProgram)
+	var out strings.Builder
+	if err := simulate(c, simOptions{maxSteps: 4, symbols: st}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"call draw_pixel", "mov [counter + 2], bx", "mov ax, [counter]"} {
+		if !strings.Contains(out.String(), text+" ;") {
+			t.Errorf("log lacks %q:\n%s", text, out.String())
+		}
+	}
+}
+
+func TestSymbolSkeleton(t *testing.T) {
+	var out strings.Builder
+	if err := traverse(symbolProgram, []int{0}, cpu8086, nil).skeleton().write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# address  name  [type [count]]
+0x0007  label_0007
+0x000c  data_000c  byte 4
+`
+	if out.String() != want {
+		t.Errorf("skeleton:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	// The skeleton loads back and keeps the names of an existing table.
+	st, err := parseSymbols(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := parseSymbols(strings.NewReader(symbolFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skeleton := traverse(symbolProgram, []int{0}, cpu8086, named).skeleton()
+	if len(skeleton.byAddr) != len(st.byAddr) {
+		t.Errorf("skeleton of named table has %d symbols, want %d", len(skeleton.byAddr), len(st.byAddr))
+	}
+	if s := skeleton.byAddr[12]; s.name != "counter" || s.size != 2 || s.count != 2 {
+		t.Errorf("skeleton retyped counter as %+v", s)
+	}
+}
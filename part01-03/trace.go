@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// regDelta records a register whose value was changed by an instruction.
+type regDelta struct {
+	Reg string `json:"reg"`
+	Old uint16 `json:"old"`
+	New uint16 `json:"new"`
+}
+
+// flagDelta records a change of the FLAGS register, rendered as flag letters.
+type flagDelta struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// traceRecord is the structured trace of a single executed instruction.
+// It is written as one JSON object per line.
+type traceRecord struct {
+	Step   uint64     `json:"step"`
+	Addr   uint32     `json:"addr"`
+	Bytes  string     `json:"bytes"`
+	Text   string     `json:"text"`
+	Regs   []regDelta `json:"regs,omitempty"`
+	Flags  *flagDelta `json:"flags,omitempty"`
+	Mem    []memWrite `json:"mem,omitempty"`
+	Clocks int        `json:"clocks"`
+	Total  uint64     `json:"total_clocks"`
+
+	// fromText marks records parsed from a text log, which carry no address,
+	// encoding or memory writes.
+	fromText bool
+	// hasClocks is false for text log lines without a clock count.
+	hasClocks bool
+}
+
+// regOrder is the order register deltas are reported in.
+var regOrder = []string{"ax", "bx", "cx", "dx", "sp", "bp", "si", "di", "es", "cs", "ss", "ds", "ip"}
+
+// newTraceRecord builds the trace of an instruction with the given text from
+// the register state before it ran and the CPU state after it ran.
+func newTraceRecord(c *cpu, before registers, res stepResult, text string) traceRecord {
+	rec := traceRecord{
+		Step:      c.steps,
+		Addr:      res.addr,
+		Bytes:     hex.EncodeToString(c.mem[res.addr : res.addr+uint32(res.size)]),
+		Text:      text,
+		Clocks:    res.clocks,
+		Total:     c.clocks,
+		hasClocks: true,
+	}
+
+	old, cur := before.named(), c.registers.named()
+	for _, name := range regOrder {
+		if old[name] != cur[name] {
+			rec.Regs = append(rec.Regs, regDelta{Reg: name, Old: old[name], New: cur[name]})
+		}
+	}
+
+	if before.flags != c.flags {
+		rec.Flags = &flagDelta{Old: flagString(before.flags), New: flagString(c.flags)}
+	}
+	if len(c.writes) > 0 {
+		rec.Mem = append([]memWrite(nil), c.writes...)
+	}
+	return rec
+}
+
+// String renders the record in the text log format:
+//
+//	add cx, 1 ; Clocks: +4 = 12 | cx:0x0->0x1 ip:0x3->0x6 flags:->P
+func (r traceRecord) String() string {
+	var sb strings.Builder
+	sb.WriteString(r.Text)
+	sb.WriteString(" ;")
+	if r.hasClocks {
+		fmt.Fprintf(&sb, " Clocks: +%d = %d |", r.Clocks, r.Total)
+	}
+	for _, d := range r.Regs {
+		fmt.Fprintf(&sb, " %s:%#x->%#x", d.Reg, d.Old, d.New)
+	}
+	if r.Flags != nil {
+		fmt.Fprintf(&sb, " flags:%s->%s", r.Flags.Old, r.Flags.New)
+	}
+	for _, w := range r.Mem {
+		fmt.Fprintf(&sb, " [%#05x]:%#x->%#x", w.Addr, w.Old, w.New)
+	}
+	return sb.String()
+}
+
+// traceWriter writes trace records as JSON Lines.
+type traceWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newTraceWriter(w io.Writer) *traceWriter {
+	bw := bufio.NewWriter(w)
+	return &traceWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (tw *traceWriter) write(rec traceRecord) error {
+	return tw.enc.Encode(rec)
+}
+
+func (tw *traceWriter) flush() error {
+	return tw.w.Flush()
+}
+
+// readTrace loads a trace from either a JSON Lines file or a text log. The
+// format is detected from the first non-blank character.
+func readTrace(r io.Reader) ([]traceRecord, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return readJSONTrace(data)
+	}
+	return readTextTrace(data)
+}
+
+func readJSONTrace(data []byte) ([]traceRecord, error) {
+	var records []traceRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec traceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rec.hasClocks = true
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// readTextTrace parses a text log as printed by -exec. Lines that do not
+// describe an instruction, such as headers and the final register dump, are
+// skipped.
+func readTextTrace(data []byte) ([]traceRecord, error) {
+	var records []traceRecord
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		text, changes, ok := strings.Cut(line, " ; ")
+		if !ok || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "---") {
+			continue
+		}
+
+		rec := traceRecord{Step: uint64(len(records) + 1), Text: strings.TrimSpace(text), fromText: true}
+		if clocks, rest, ok := strings.Cut(changes, "|"); ok && strings.Contains(clocks, "Clocks:") {
+			// Clocks: +<n> = <total>
+			fields := strings.Fields(clocks)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: malformed clocks %q", i+1, clocks)
+			}
+			n, err := strconv.Atoi(strings.TrimPrefix(fields[1], "+"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if len(fields) >= 4 {
+				if total, err := strconv.ParseUint(fields[3], 10, 64); err == nil {
+					rec.Total = total
+				}
+			}
+			rec.Clocks, rec.hasClocks = n, true
+			changes = rest
+		}
+
+		for _, field := range strings.Fields(changes) {
+			name, delta, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+			oldVal, newVal, ok := strings.Cut(delta, "->")
+			if !ok {
+				continue
+			}
+			if name == "flags" {
+				rec.Flags = &flagDelta{Old: oldVal, New: newVal}
+				continue
+			}
+			if strings.HasPrefix(name, "[") {
+				continue // Memory writes are only compared between JSON traces.
+			}
+			o, err := strconv.ParseUint(oldVal, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			n, err := strconv.ParseUint(newVal, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			rec.Regs = append(rec.Regs, regDelta{Reg: name, Old: uint16(o), New: uint16(n)})
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// compareRecords lists the differences between two records. Fields missing
+// from either side, such as encodings in text logs, are not compared.
+func compareRecords(a, b traceRecord) []string {
+	var diffs []string
+	if !a.fromText && !b.fromText {
+		if a.Addr != b.Addr {
+			diffs = append(diffs, fmt.Sprintf("addr: %#05x != %#05x", a.Addr, b.Addr))
+		}
+		if a.Bytes != b.Bytes {
+			diffs = append(diffs, fmt.Sprintf("bytes: %s != %s", a.Bytes, b.Bytes))
+		}
+	}
+	if normalizeText(a.Text) != normalizeText(b.Text) {
+		diffs = append(diffs, fmt.Sprintf("text: %q != %q", a.Text, b.Text))
+	}
+
+	aRegs, bRegs := regMap(a.Regs), regMap(b.Regs)
+	_, aIP := aRegs["ip"]
+	_, bIP := bRegs["ip"]
+	if !aIP || !bIP {
+		// Older text logs do not record ip.
+		delete(aRegs, "ip")
+		delete(bRegs, "ip")
+	}
+	names := make([]string, 0, len(aRegs)+len(bRegs))
+	for name := range aRegs {
+		names = append(names, name)
+	}
+	for name := range bRegs {
+		if _, ok := aRegs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ad, aok := aRegs[name]
+		bd, bok := bRegs[name]
+		switch {
+		case !aok:
+			diffs = append(diffs, fmt.Sprintf("%s: unchanged != %#x->%#x", name, bd.Old, bd.New))
+		case !bok:
+			diffs = append(diffs, fmt.Sprintf("%s: %#x->%#x != unchanged", name, ad.Old, ad.New))
+		case ad != bd:
+			diffs = append(diffs, fmt.Sprintf("%s: %#x->%#x != %#x->%#x", name, ad.Old, ad.New, bd.Old, bd.New))
+		}
+	}
+
+	if flagsAfter(a) != flagsAfter(b) {
+		diffs = append(diffs, fmt.Sprintf("flags: %s != %s", flagsAfter(a), flagsAfter(b)))
+	}
+
+	if !a.fromText && !b.fromText && !equalWrites(a.Mem, b.Mem) {
+		diffs = append(diffs, fmt.Sprintf("mem: %v != %v", a.Mem, b.Mem))
+	}
+	if a.hasClocks && b.hasClocks && a.Clocks != b.Clocks {
+		diffs = append(diffs, fmt.Sprintf("clocks: %d != %d", a.Clocks, b.Clocks))
+	}
+	return diffs
+}
+
+func normalizeText(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func regMap(deltas []regDelta) map[string]regDelta {
+	m := make(map[string]regDelta, len(deltas))
+	for _, d := range deltas {
+		m[d.Reg] = d
+	}
+	return m
+}
+
+// flagsAfter renders the flag change of a record, or "unchanged".
+func flagsAfter(r traceRecord) string {
+	if r.Flags == nil || r.Flags.Old == r.Flags.New {
+		return "unchanged"
+	}
+	return r.Flags.Old + "->" + r.Flags.New
+}
+
+func equalWrites(a, b []memWrite) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// divergence describes the first point at which two traces disagree.
+type divergence struct {
+	index int      // Index of the first differing record.
+	diffs []string // Field differences, or a note when one trace ended early.
+}
+
+// diffTraces finds the first record at which got and want diverge. It
+// returns nil if the traces are equivalent.
+func diffTraces(got, want []traceRecord) *divergence {
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if diffs := compareRecords(got[i], want[i]); len(diffs) > 0 {
+			return &divergence{index: i, diffs: diffs}
+		}
+	}
+	switch {
+	case len(got) > len(want):
+		return &divergence{index: len(want), diffs: []string{fmt.Sprintf("reference ended after %d instructions", len(want))}}
+	case len(got) < len(want):
+		return &divergence{index: len(got), diffs: []string{fmt.Sprintf("trace ended after %d instructions", len(got))}}
+	}
+	return nil
+}
+
+// runTraceDiff implements the tracediff command. It returns true if the
+// traces diverge.
+func runTraceDiff(args []string, out io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := fs.Int("context", 3, "number of matching instructions to show before the divergence")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tracediff [-context n] <trace.jsonl> <reference.jsonl|reference.txt>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return false, fmt.Errorf("expected 2 trace files, got %d", fs.NArg())
+	}
+
+	traces := make([][]traceRecord, 2)
+	for i, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return false, err
+		}
+		traces[i], err = readTrace(f)
+		f.Close()
+		if err != nil {
+			return false, fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+
+	got, want := traces[0], traces[1]
+	d := diffTraces(got, want)
+	if d == nil {
+		fmt.Fprintf(out, "traces match (%d instructions)\n", len(got))
+		return false, nil
+	}
+
+	fmt.Fprintf(out, "traces diverge at instruction %d:\n", d.index+1)
+	for i := max(0, d.index-*context); i < d.index; i++ {
+		fmt.Fprintf(out, "    %s\n", got[i])
+	}
+	if d.index < len(got) {
+		fmt.Fprintf(out, "  < %s\n", got[d.index])
+	}
+	if d.index < len(want) {
+		fmt.Fprintf(out, "  > %s\n", want[d.index])
+	}
+	for _, diff := range d.diffs {
+		fmt.Fprintf(out, "    %s\n", diff)
+	}
+	return true, nil
+}
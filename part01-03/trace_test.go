@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func traceProgram(t *testing.T, program []byte) []traceRecord {
+	t.Helper()
+
+	c := newCPU()
+	c.load(program)
+	c.trackWrites = true
+	var records []traceRecord
+	for !c.halted() {
+		before := c.registers
+		res, err := c.step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, newTraceRecord(c, before, res, res.instr.disassemble()))
+	}
+	return records
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	records := traceProgram(t, []byte{
+		0xb9, 0x02, 0x00, // mov cx, 2
+		0x89, 0x0e, 0x10, 0x00, // mov [16], cx
+		0xe2, 0xfa, // loop $+2-6
+	})
+
+	var jsonl, text bytes.Buffer
+	tw := newTraceWriter(&jsonl)
+	for _, rec := range records {
+		if err := tw.write(rec); err != nil {
+			t.Fatal(err)
+		}
+		text.WriteString(rec.String() + "\n")
+	}
+	if err := tw.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	fromJSON, err := readTrace(&jsonl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diffTraces(records, fromJSON); d != nil {
+		t.Fatalf("JSON round trip diverged at %d: %v", d.index, d.diffs)
+	}
+
+	fromText, err := readTrace(&text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diffTraces(records, fromText); d != nil {
+		t.Fatalf("text round trip diverged at %d: %v", d.index, d.diffs)
+	}
+}
+
+func TestDiffTracesReportsFirstDivergence(t *testing.T) {
+	records := traceProgram(t, []byte{
+		0xbb, 0x01, 0x00, // mov bx, 1
+		0x83, 0xc3, 0x01, // add bx, 1
+	})
+
+	reference := `--- reference execution ---
+mov bx, 1 ; bx:0x0->0x1
+add bx, 1 ; bx:0x1->0x3
+
+Final registers:
+      bx: 0x0001 (1)
+`
+	want, err := readTrace(strings.NewReader(reference))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := diffTraces(records, want)
+	if d == nil {
+		t.Fatal("expected traces to diverge")
+	}
+	if d.index != 1 {
+		t.Errorf("divergence index = %d, want 1", d.index)
+	}
+	if len(d.diffs) != 1 || !strings.HasPrefix(d.diffs[0], "bx:") {
+		t.Errorf("diffs = %v, want a single bx difference", d.diffs)
+	}
+}
+
+func TestSimulateLogsInSelectedSyntax(t *testing.T) {
+	c := newCPU()
+	c.load([]byte{0xb9, 0x03, 0x00}) // mov cx, 3
+	var out strings.Builder
+	if err := simulate(c, simOptions{printer: attPrinter{printOptions{upper: true}}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "MOV $3, %CX ;") {
+		t.Errorf("log is not in upper case AT&T syntax:\n%s", out.String())
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Test vectors are single instruction executions recorded from real CPUs,
+// in the JSON format of the SingleStepTests 8088 suite: one file per opcode,
+// or per opcode and reg field for the group opcodes, named like 01.json.gz
+// or 80.7.json, holding an array of tests. A metadata file next to them
+// (metadata.json, 8088.json or 8086.json) gives the status of every opcode
+// and the mask of the flags it defines.
+
+// vectorState is the CPU state before or after a test. Final states only
+// list the registers that changed.
+type vectorState struct {
+	Regs map[string]uint16 `json:"regs"`
+	RAM  [][2]uint32       `json:"ram"` // Linear address and byte value.
+}
+
+// vectorTest is one recorded instruction execution.
+type vectorTest struct {
+	Name    string      `json:"name"`
+	Bytes   []int       `json:"bytes"`
+	Initial vectorState `json:"initial"`
+	Final   vectorState `json:"final"`
+}
+
+// vectorOpcode is the metadata of an opcode, or of one reg field of a
+// group opcode.
+type vectorOpcode struct {
+	Status    string                  `json:"status"`
+	FlagsMask *uint16                 `json:"flags-mask"` // Defined flags, all if absent.
+	Reg       map[string]vectorOpcode `json:"reg"`
+}
+
+type vectorMetadata struct {
+	Opcodes map[string]vectorOpcode `json:"opcodes"`
+}
+
+// vectorMetadataFiles are the names the metadata of a suite is looked up by.
+var vectorMetadataFiles = []string{"metadata.json", "8088.json", "8086.json"}
+
+// vectorFileName matches the test files of a suite and captures the opcode
+// and optional reg field.
+var vectorFileName = regexp.MustCompile(`^([0-9A-Fa-f]{2})(?:\.([0-7]))?\.json(?:\.gz)?$`)
+
+// definedFlags are the FLAGS bits the simulator keeps; the others read as
+// fixed values on real CPUs and are not compared.
+const definedFlags = flagCF | flagPF | flagAF | flagZF | flagSF | flagTF | flagIF | flagDF | flagOF
+
+// readVectorMetadata loads the metadata of the suite in dir, if it has one.
+func readVectorMetadata(dir string) (*vectorMetadata, error) {
+	for _, name := range vectorMetadataFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var meta vectorMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return &meta, nil
+	}
+	return nil, nil
+}
+
+// opcode returns the metadata for the test file of opcode and reg, which is
+// "" for opcodes without a group.
+func (m *vectorMetadata) opcode(opcode, reg string) (vectorOpcode, bool) {
+	if m == nil {
+		return vectorOpcode{}, false
+	}
+	op, ok := m.Opcodes[strings.ToUpper(opcode)]
+	if !ok || reg == "" {
+		return op, ok
+	}
+	if r, ok := op.Reg[reg]; ok {
+		return r, true
+	}
+	return op, true
+}
+
+// readVectorFile reads the tests of one file, decompressing .gz files.
+func readVectorFile(path string) ([]vectorTest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var tests []vectorTest
+	if err := json.NewDecoder(r).Decode(&tests); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tests, nil
+}
+
+// vectorRegisters maps the register names of the suite to the simulator's.
+var vectorRegisters = map[string]func(r *registers) *uint16{
+	"ax": func(r *registers) *uint16 { return &r.regs[regAX] },
+	"bx": func(r *registers) *uint16 { return &r.regs[regBX] },
+	"cx": func(r *registers) *uint16 { return &r.regs[regCX] },
+	"dx": func(r *registers) *uint16 { return &r.regs[regDX] },
+	"sp": func(r *registers) *uint16 { return &r.regs[regSP] },
+	"bp": func(r *registers) *uint16 { return &r.regs[regBP] },
+	"si": func(r *registers) *uint16 { return &r.regs[regSI] },
+	"di": func(r *registers) *uint16 { return &r.regs[regDI] },
+	"es": func(r *registers) *uint16 { return &r.sregs[segES] },
+	"cs": func(r *registers) *uint16 { return &r.sregs[segCS] },
+	"ss": func(r *registers) *uint16 { return &r.sregs[segSS] },
+	"ds": func(r *registers) *uint16 { return &r.sregs[segDS] },
+	"ip": func(r *registers) *uint16 { return &r.ip },
+}
+
+// vectorOutcome is the result of running one test.
+type vectorOutcome struct {
+	regs, flags, mem bool     // Which parts of the final state differ.
+	undefined        bool     // Whether flags outside the defined mask differ.
+	diffs            []string // Descriptions of the differences.
+}
+
+func (o vectorOutcome) passed() bool {
+	return !o.regs && !o.flags && !o.mem
+}
+
+// runVector executes test on c and compares the result with the recorded
+// final state. Flags outside mask are undefined for the instruction: they
+// are compared but their differences only set undefined. c's memory is
+// cleared again afterwards so that c can be reused. An error means the
+// simulator could not decode or execute the instruction.
+func runVector(c *cpu, test vectorTest, mask uint16) (vectorOutcome, error) {
+	c.registers = registers{}
+	for name, v := range test.Initial.Regs {
+		if name == "flags" {
+			c.flags = v
+		} else if reg, ok := vectorRegisters[name]; ok {
+			*reg(&c.registers) = v
+		}
+	}
+	for _, cell := range test.Initial.RAM {
+		c.mem[cell[0]&(memorySize-1)] = byte(cell[1])
+	}
+	c.decoded.reset(c.model)
+	c.trackWrites = true
+
+	defer func() {
+		for _, cell := range test.Initial.RAM {
+			c.mem[cell[0]&(memorySize-1)] = 0
+		}
+		for _, w := range c.writes {
+			c.mem[w.Addr] = 0
+		}
+	}()
+	if _, err := c.step(); err != nil {
+		return vectorOutcome{}, err
+	}
+
+	var o vectorOutcome
+	want := registers{}
+	wantFlags := uint16(0)
+	for _, state := range []vectorState{test.Initial, test.Final} {
+		for name, v := range state.Regs {
+			if name == "flags" {
+				wantFlags = v
+			} else if reg, ok := vectorRegisters[name]; ok {
+				*reg(&want) = v
+			}
+		}
+	}
+	got, expected := c.registers.named(), want.named()
+	for _, name := range regOrder {
+		if got[name] != expected[name] {
+			o.regs = true
+			o.diffs = append(o.diffs, fmt.Sprintf("%s %#04x, want %#04x", name, got[name], expected[name]))
+		}
+	}
+
+	if diff := (c.flags ^ wantFlags) & definedFlags; diff != 0 {
+		o.flags = diff&mask != 0
+		o.undefined = diff&^mask != 0
+		kind := "flags"
+		if !o.flags {
+			kind = "undefined flags"
+		}
+		o.diffs = append(o.diffs, fmt.Sprintf("%s %s, want %s", kind, flagString(c.flags&definedFlags), flagString(wantFlags&definedFlags)))
+	}
+
+	expectedMem := make(map[uint32]byte, len(test.Final.RAM))
+	for _, cell := range test.Final.RAM {
+		addr := cell[0] & (memorySize - 1)
+		expectedMem[addr] = byte(cell[1])
+		if c.mem[addr] != byte(cell[1]) {
+			o.mem = true
+			o.diffs = append(o.diffs, fmt.Sprintf("[%#05x] %#02x, want %#02x", addr, c.mem[addr], cell[1]))
+		}
+	}
+	for _, w := range c.writes {
+		if _, ok := expectedMem[w.Addr]; !ok && w.New != w.Old {
+			o.mem = true
+			o.diffs = append(o.diffs, fmt.Sprintf("[%#05x] written with %#02x, want untouched", w.Addr, w.New))
+		}
+	}
+	return o, nil
+}
+
+// vectorResult totals the tests of one file.
+type vectorResult struct {
+	name        string // Opcode and reg field, such as 80.7.
+	tests       int
+	passed      int
+	regs        int // Tests with wrong registers.
+	flags       int // Tests with wrong defined flags.
+	mem         int // Tests with wrong memory.
+	undefined   int // Tests with differing undefined flags.
+	unsupported int // Tests the simulator could not run.
+
+	// failures describes the first failing tests.
+	failures []string
+}
+
+// runVectorFile runs up to limit tests of the file at path, or all of them
+// if limit is 0, keeping up to show failure descriptions.
+func runVectorFile(c *cpu, path string, mask uint16, limit, show int) (vectorResult, error) {
+	tests, err := readVectorFile(path)
+	if err != nil {
+		return vectorResult{}, err
+	}
+	if limit > 0 && len(tests) > limit {
+		tests = tests[:limit]
+	}
+
+	res := vectorResult{name: vectorName(filepath.Base(path))}
+	for i, test := range tests {
+		res.tests++
+		o, err := runVector(c, test, mask)
+		if err != nil {
+			res.unsupported++
+			continue
+		}
+		if o.undefined {
+			res.undefined++
+		}
+		if o.passed() {
+			res.passed++
+			continue
+		}
+		if o.regs {
+			res.regs++
+		}
+		if o.flags {
+			res.flags++
+		}
+		if o.mem {
+			res.mem++
+		}
+		if len(res.failures) < show {
+			res.failures = append(res.failures, fmt.Sprintf("#%d %s: %s", i, test.Name, strings.Join(o.diffs, "; ")))
+		}
+	}
+	return res, nil
+}
+
+// vectorName returns the opcode and reg field of a test file name, such as
+// 80.7 for 80.7.json.gz.
+func vectorName(file string) string {
+	m := vectorFileName.FindStringSubmatch(file)
+	if m[2] == "" {
+		return strings.ToUpper(m[1])
+	}
+	return strings.ToUpper(m[1]) + "." + m[2]
+}
+
+// vectorFiles returns the test files in dir ordered by name. A non-empty
+// only keeps the files of the listed opcodes, or opcodes and reg fields.
+func vectorFiles(dir string, only []string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	for _, name := range only {
+		keep[strings.ToUpper(name)] = true
+	}
+
+	var files []string
+	for _, e := range entries {
+		m := vectorFileName.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		if len(keep) > 0 && !keep[strings.ToUpper(m[1])] && !keep[vectorName(e.Name())] {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runVectorSuite runs the suite in dir, skipping opcodes whose metadata
+// marks them as prefixes, undefined or unimplemented by the recording CPU.
+func runVectorSuite(dir string, only []string, limit, show int) ([]vectorResult, error) {
+	meta, err := readVectorMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := vectorFiles(dir, only)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCPU()
+	var results []vectorResult
+	for _, path := range files {
+		m := vectorFileName.FindStringSubmatch(filepath.Base(path))
+		mask := definedFlags
+		if op, ok := meta.opcode(m[1], m[2]); ok {
+			if op.Status != "" && op.Status != "normal" && op.Status != "alias" {
+				continue
+			}
+			if op.FlagsMask != nil {
+				mask = *op.FlagsMask
+			}
+		}
+		res, err := runVectorFile(c, path, mask, limit, show)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// writeVectorReport prints a line per opcode and the totals, followed by
+// the failures kept for each opcode.
+func writeVectorReport(out io.Writer, results []vectorResult) {
+	fmt.Fprintf(out, "%-6s %8s %8s %8s %8s %8s %10s %12s\n", "opcode", "tests", "passed", "regs", "flags", "memory", "undefined", "unsupported")
+	var total vectorResult
+	total.name = "total"
+	for _, r := range results {
+		fmt.Fprintf(out, "%-6s %8d %8d %8d %8d %8d %10d %12d\n", r.name, r.tests, r.passed, r.regs, r.flags, r.mem, r.undefined, r.unsupported)
+		total.tests += r.tests
+		total.passed += r.passed
+		total.regs += r.regs
+		total.flags += r.flags
+		total.mem += r.mem
+		total.undefined += r.undefined
+		total.unsupported += r.unsupported
+	}
+	r := total
+	fmt.Fprintf(out, "%-6s %8d %8d %8d %8d %8d %10d %12d\n", r.name, r.tests, r.passed, r.regs, r.flags, r.mem, r.undefined, r.unsupported)
+	if run := r.tests - r.unsupported; run > 0 {
+		fmt.Fprintf(out, "%.2f%% of %d supported tests pass\n", 100*float64(r.passed)/float64(run), run)
+	}
+
+	for _, r := range results {
+		if len(r.failures) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s:\n", r.name)
+		for _, f := range r.failures {
+			fmt.Fprintf(out, "  %s\n", f)
+		}
+	}
+}
+
+// runVectors implements the vectors command. It returns true if any
+// supported test fails.
+func runVectors(args []string, out io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("vectors", flag.ExitOnError)
+	limit := fs.Int("limit", 0, "run at most `n` tests per opcode (0 runs them all)")
+	show := fs.Int("show", 3, "describe up to `n` failing tests per opcode")
+	opcodes := fs.String("opcodes", "", "only run the comma separated `opcodes`, such as 01,80.7")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vectors [-limit n] [-show n] [-opcodes list] <directory>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return false, fmt.Errorf("expected 1 directory, got %d", fs.NArg())
+	}
+
+	var only []string
+	if *opcodes != "" {
+		only = strings.Split(*opcodes, ",")
+	}
+	results, err := runVectorSuite(fs.Arg(0), only, *limit, *show)
+	if err != nil {
+		return false, err
+	}
+	writeVectorReport(out, results)
+	for _, r := range results {
+		if r.passed != r.tests-r.unsupported {
+			return true, nil
+		}
+	}
+	return false, nil
+}
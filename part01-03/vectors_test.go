@@ -0,0 +1,145 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// vectorsDir is where a copy of the SingleStepTests 8088 suite is looked for,
+// overridden by the VECTORS_DIR environment variable.
+const vectorsDir = "testdata/8088"
+
+// TestVectors runs the recorded test vectors when a copy of the suite is
+// present. In -short mode only the first 100 tests of each opcode run.
+func TestVectors(t *testing.T) {
+	dir := os.Getenv("VECTORS_DIR")
+	if dir == "" {
+		dir = vectorsDir
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		t.Skipf("no test vectors in %s", dir)
+	}
+
+	limit := 0
+	if testing.Short() {
+		limit = 100
+	}
+	results, err := runVectorSuite(dir, nil, limit, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report strings.Builder
+	writeVectorReport(&report, results)
+	t.Log("\n" + report.String())
+	for _, r := range results {
+		if r.passed != r.tests-r.unsupported {
+			t.Errorf("opcode %s: %d of %d supported tests fail", r.name, r.tests-r.unsupported-r.passed, r.tests-r.unsupported)
+		}
+	}
+}
+
+// addVector returns a test of add ax, bx at 1000:0100 with ax = 1 and bx = 2,
+// expecting final ax and flags.
+func addVector(ax, flags uint16) vectorTest {
+	return vectorTest{
+		Name:  "add ax, bx",
+		Bytes: []int{0x01, 0xd8},
+		Initial: vectorState{
+			Regs: map[string]uint16{"ax": 1, "bx": 2, "cs": 0x1000, "ip": 0x100, "flags": 0xf002},
+			RAM:  [][2]uint32{{0x10100, 0x01}, {0x10101, 0xd8}},
+		},
+		Final: vectorState{
+			Regs: map[string]uint16{"ax": ax, "ip": 0x102, "flags": flags},
+			RAM:  [][2]uint32{{0x10100, 0x01}, {0x10101, 0xd8}},
+		},
+	}
+}
+
+func writeVectorFile(t *testing.T, path string, tests []vectorTest) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if err := json.NewEncoder(gz).Encode(tests); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunVectorSuite(t *testing.T) {
+	dir := t.TempDir()
+
+	// 1 + 2 = 3 sets PF. AF is declared undefined so that the third test
+	// only differs in an undefined flag.
+	writeVectorFile(t, filepath.Join(dir, "01.json.gz"), []vectorTest{
+		addVector(3, 0xf006),
+		addVector(4, 0xf006),
+		addVector(3, 0xf016),
+	})
+	store := vectorTest{
+		Name:  "mov [bx], ax",
+		Bytes: []int{0x89, 0x07},
+		Initial: vectorState{
+			Regs: map[string]uint16{"ax": 0x1234, "bx": 0x10, "ds": 0x2000, "ip": 0, "flags": 0xf002},
+			RAM:  [][2]uint32{{0, 0x89}, {1, 0x07}, {0x20010, 0}, {0x20011, 0}},
+		},
+		Final: vectorState{
+			Regs: map[string]uint16{"ip": 2},
+			RAM:  [][2]uint32{{0, 0x89}, {1, 0x07}, {0x20010, 0x34}, {0x20011, 0x12}},
+		},
+	}
+	writeVectorFile(t, filepath.Join(dir, "89.json.gz"), []vectorTest{store})
+	unsupported := vectorTest{
+		Name:    "in al, dx",
+		Bytes:   []int{0xec},
+		Initial: vectorState{Regs: map[string]uint16{}, RAM: [][2]uint32{{0, 0xec}}},
+	}
+	writeVectorFile(t, filepath.Join(dir, "EC.json.gz"), []vectorTest{unsupported})
+	writeVectorFile(t, filepath.Join(dir, "F1.json.gz"), []vectorTest{unsupported})
+
+	meta := `{"opcodes": {"01": {"status": "normal", "flags-mask": 65519}, "F1": {"status": "prefix"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "8088.json"), []byte(meta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := runVectorSuite(dir, nil, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vectorResult{
+		{name: "01", tests: 3, passed: 2, regs: 1, undefined: 1},
+		{name: "89", tests: 1, passed: 1},
+		{name: "EC", tests: 1, unsupported: 1},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for i, r := range results {
+		failures := r.failures
+		r.failures = nil
+		if r.name != want[i].name || r.tests != want[i].tests || r.passed != want[i].passed || r.regs != want[i].regs ||
+			r.flags != want[i].flags || r.mem != want[i].mem || r.undefined != want[i].undefined || r.unsupported != want[i].unsupported {
+			t.Errorf("result %d = %+v, want %+v", i, r, want[i])
+		}
+		if r.name == "01" && (len(failures) != 1 || !strings.Contains(failures[0], "ax 0x0003, want 0x0004")) {
+			t.Errorf("01 failures = %q", failures)
+		}
+	}
+
+	only, err := runVectorSuite(dir, []string{"89"}, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(only) != 1 || only[0].name != "89" {
+		t.Errorf("-opcodes 89 ran %+v", only)
+	}
+}
@@ -0,0 +1,844 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// x87 is a software 8087. Registers hold big.Float values with the 64 bit
+// significand of the 8087's temporary real format, rounded to nearest even.
+//
+// The simulation is deliberately narrower than the chip:
+//   - The rounding and precision control bits are stored but ignored.
+//   - The exponent range is unbounded in registers; stores to memory
+//     overflow to infinity and truncate denormals.
+//   - Invalid operations, which would produce NaNs, and stack overflow and
+//     underflow stop the simulation instead of setting IE.
+//   - Transcendental instructions are computed with extra guard bits and
+//     rounded once, which is more accurate than the chip's own algorithms.
+//   - fprem always reduces completely, as if every partial step were run.
+//   - 80287 and 80387 additions such as fsin run only when model is the
+//     coprocessor that introduced them or a later one. The 8087's
+//     narrower operand ranges are not enforced.
+type x87 struct {
+	model   fpuModel
+	regs    [8]*big.Float // Physical registers, nil when empty.
+	top     uint8         // Physical index of st0.
+	control uint16
+	status  uint16 // Exception flags and condition codes; TOP lives in top.
+}
+
+// x87Precision is the significand size of the temporary real format.
+const x87Precision = 64
+
+// Status word bits.
+const (
+	x87ZE = 1 << 2 // Zero divide.
+	x87C0 = 1 << 8
+	x87C1 = 1 << 9
+	x87C2 = 1 << 10
+	x87C3 = 1 << 14
+
+	x87ConditionCodes = x87C0 | x87C1 | x87C2 | x87C3
+)
+
+// x87Constants are the values loaded by the D9 E8-EE instructions.
+var x87Constants = map[string]string{
+	"fld1":   "1",
+	"fldl2t": "3.32192809488736234787031942948939018",
+	"fldl2e": "1.44269504088896340735992468100189214",
+	"fldpi":  "3.14159265358979323846264338327950288",
+	"fldlg2": "0.301029995663981195213738894724493027",
+	"fldln2": "0.693147180559945309417232121458176568",
+	"fldz":   "0",
+}
+
+var errX87Invalid = errors.New("invalid operation, NaNs are not simulated")
+
+// newX87 returns a coprocessor of the given model in the state left by
+// FNINIT.
+func newX87(model fpuModel) *x87 {
+	return &x87{model: model, control: 0x03ff}
+}
+
+func newFloat() *big.Float {
+	return new(big.Float).SetPrec(x87Precision)
+}
+
+// statusWord returns the status word including TOP.
+func (f *x87) statusWord() uint16 {
+	return f.status&^(0b111<<11) | uint16(f.top)<<11
+}
+
+// setStatusWord loads the status word including TOP.
+func (f *x87) setStatusWord(sw uint16) {
+	f.status = sw &^ (0b111 << 11)
+	f.top = uint8(sw>>11) & 0b111
+}
+
+// tagWord returns the tag word: two bits per physical register, 00 valid,
+// 01 zero, 10 special and 11 empty.
+func (f *x87) tagWord() uint16 {
+	var tw uint16
+	for i, r := range f.regs {
+		var tag uint16
+		switch {
+		case r == nil:
+			tag = 0b11
+		case r.Sign() == 0:
+			tag = 0b01
+		case r.IsInf():
+			tag = 0b10
+		}
+		tw |= tag << (2 * i)
+	}
+	return tw
+}
+
+// setTagWord empties the registers the tag word marks empty. The other
+// tags follow from the register contents.
+func (f *x87) setTagWord(tw uint16) {
+	for i := range f.regs {
+		if (tw>>(2*i))&0b11 == 0b11 {
+			f.regs[i] = nil
+		} else if f.regs[i] == nil {
+			f.regs[i] = newFloat()
+		}
+	}
+}
+
+// st returns stack register i.
+func (f *x87) st(i byte) (*big.Float, error) {
+	r := f.regs[(f.top+i)&7]
+	if r == nil {
+		return nil, fmt.Errorf("stack underflow: st%d is empty", i)
+	}
+	return r, nil
+}
+
+// set replaces stack register i with v rounded to the register format.
+func (f *x87) set(i byte, v *big.Float) {
+	f.regs[(f.top+i)&7] = newFloat().Set(v)
+}
+
+func (f *x87) push(v *big.Float) error {
+	top := (f.top - 1) & 7
+	if f.regs[top] != nil {
+		return fmt.Errorf("stack overflow: pushing onto a full register stack")
+	}
+	f.top = top
+	f.set(0, v)
+	return nil
+}
+
+func (f *x87) pop() {
+	f.regs[f.top] = nil
+	f.top = (f.top + 1) & 7
+}
+
+// compare sets C3, C2 and C0 from a compared with b.
+func (f *x87) compare(a, b *big.Float) {
+	f.status &^= x87ConditionCodes
+	switch a.Cmp(b) {
+	case -1:
+		f.status |= x87C0
+	case 0:
+		f.status |= x87C3
+	}
+}
+
+// examine sets the condition codes the way FXAM classifies st0.
+func (f *x87) examine() {
+	f.status &^= x87ConditionCodes
+	r := f.regs[f.top]
+	if r == nil {
+		f.status |= x87C3 | x87C0
+		return
+	}
+	if r.Signbit() {
+		f.status |= x87C1
+	}
+	switch {
+	case r.IsInf():
+		f.status |= x87C2 | x87C0
+	case r.Sign() == 0:
+		f.status |= x87C3
+	case r.MantExp(nil)-1 < -16382:
+		f.status |= x87C3 | x87C2 // Denormal.
+	default:
+		f.status |= x87C2
+	}
+}
+
+// arith computes a op b, where op is the arithmetic part of a mnemonic
+// such as fsubr, fisubr or fsubrp.
+func (f *x87) arith(mnemonic string, a, b *big.Float) *big.Float {
+	op := strings.TrimPrefix(mnemonic, "f")
+	op = strings.TrimPrefix(op, "i")
+	op = strings.TrimSuffix(op, "p")
+	if strings.HasSuffix(op, "r") {
+		op, a, b = strings.TrimSuffix(op, "r"), b, a
+	}
+
+	res := newFloat()
+	switch op {
+	case "add":
+		res.Add(a, b)
+	case "sub":
+		res.Sub(a, b)
+	case "mul":
+		res.Mul(a, b)
+	case "div":
+		if b.Sign() == 0 && a.Sign() != 0 {
+			f.status |= x87ZE
+		}
+		res.Quo(a, b)
+	}
+	return res
+}
+
+// roundEven rounds x to an integer, halfway cases to even, keeping the
+// precision of x.
+func roundEven(x *big.Float) *big.Float {
+	if x.IsInf() || x.IsInt() {
+		return new(big.Float).Set(x)
+	}
+	t, _ := x.Int(nil)
+	frac := new(big.Float).Sub(x, new(big.Float).SetInt(t))
+	half := new(big.Float).Abs(frac).Cmp(big.NewFloat(0.5))
+	if half > 0 || (half == 0 && t.Bit(0) == 1) {
+		t.Add(t, big.NewInt(int64(frac.Sign())))
+	}
+	res := new(big.Float).SetPrec(x.Prec()).SetInt(t)
+	if x.Signbit() && res.Sign() == 0 {
+		res.Neg(res)
+	}
+	return res
+}
+
+// remainder returns a - q*b, with q = a/b truncated for fprem or rounded to
+// even for fprem1, along with the low bits of q.
+func remainder(a, b *big.Float, nearest bool) (*big.Float, uint) {
+	if b.Sign() == 0 || a.IsInf() {
+		panic(big.ErrNaN{})
+	}
+	if a.Sign() == 0 || b.IsInf() {
+		return newFloat().Set(a), 0
+	}
+
+	// Carry enough bits that neither the quotient nor the product loses any.
+	prec := uint(2*x87Precision + 2)
+	if d := a.MantExp(nil) - b.MantExp(nil); d > 0 {
+		prec += uint(d)
+	}
+	quo := new(big.Float).SetPrec(prec).Quo(a, b)
+	var q *big.Float
+	if nearest {
+		q = roundEven(quo)
+	} else {
+		qi, _ := quo.Int(nil)
+		q = new(big.Float).SetInt(qi)
+	}
+	prod := new(big.Float).SetPrec(prec).Mul(q, b)
+	r := new(big.Float).SetPrec(prec).Sub(a, prod)
+
+	qi, _ := q.Int(nil)
+	low := new(big.Int).And(new(big.Int).Abs(qi), big.NewInt(0b111)).Uint64()
+	return newFloat().Set(r), uint(low)
+}
+
+// log2Times returns y·log2(x) for FYL2X, or y·log2(1 + x) for FYL2XP1 with
+// plus1 set.
+func (f *x87) log2Times(y, x *big.Float, plus1 bool) (*big.Float, error) {
+	arg := x
+	if plus1 {
+		arg = new(big.Float).SetPrec(x87WorkPrec).Add(x, big.NewFloat(1))
+	}
+	switch {
+	case arg.Sign() < 0:
+		return nil, errX87Invalid
+	case arg.Sign() == 0:
+		// log2 0 is -∞ and raises the zero divide exception.
+		if y.Sign() == 0 {
+			return nil, errX87Invalid
+		}
+		f.status |= x87ZE
+		return newFloat().SetInf(y.Sign() > 0), nil
+	case arg.IsInf() || y.IsInf():
+		// Multiplying by an infinity keeps the product exact, NaN aside.
+		l := arg
+		if !arg.IsInf() {
+			l = x87Log2(arg, x87WorkPrec)
+		}
+		return newFloat().Mul(y, l), nil
+	}
+
+	// Near 0 log2(1 + x) is taken from x itself, which 1 + x would round
+	// away. The chip only accepts |x| < 1 − √2/2 there anyway.
+	var l *big.Float
+	if plus1 && new(big.Float).Abs(x).Cmp(big.NewFloat(0.5)) < 0 {
+		l = x87Log2p1(x, x87WorkPrec)
+	} else {
+		l = x87Log2(arg, x87WorkPrec)
+	}
+	return l.Mul(l, y), nil
+}
+
+// readTempReal decodes the 80 bit temporary real format.
+func readTempReal(b []byte) (*big.Float, error) {
+	mant := binary.LittleEndian.Uint64(b)
+	se := binary.LittleEndian.Uint16(b[8:])
+	neg := se&0x8000 != 0
+	exp := int(se & 0x7fff)
+
+	f := newFloat()
+	switch {
+	case exp == 0x7fff && mant<<1 == 0:
+		return f.SetInf(neg), nil
+	case exp == 0x7fff:
+		return nil, errX87Invalid
+	case exp == 0:
+		exp = 1 // Denormals share the smallest normal exponent.
+	}
+	f.SetMantExp(f.SetUint64(mant), exp-16383-63)
+	if neg {
+		f.Neg(f)
+	}
+	return f, nil
+}
+
+// tempReal encodes x in the 80 bit temporary real format.
+func tempReal(x *big.Float) []byte {
+	var se uint16
+	if x.Signbit() {
+		se = 0x8000
+	}
+	var mant uint64
+	switch {
+	case x.IsInf():
+		se |= 0x7fff
+		mant = 1 << 63
+	case x.Sign() != 0:
+		m := newFloat()
+		exp := x.MantExp(m) - 1 + 16383
+		sig, _ := m.SetMantExp(m.Abs(m), x87Precision).Uint64()
+		switch {
+		case exp >= 0x7fff:
+			se |= 0x7fff
+			mant = 1 << 63
+		case exp <= 0:
+			if shift := 1 - exp; shift < 64 {
+				mant = sig >> uint(shift)
+			}
+		default:
+			se |= uint16(exp)
+			mant = sig
+		}
+	}
+
+	b := make([]byte, 10)
+	binary.LittleEndian.PutUint64(b, mant)
+	binary.LittleEndian.PutUint16(b[8:], se)
+	return b
+}
+
+// readReal decodes a short (4 byte), long (8 byte) or temporary real.
+func readReal(b []byte) (*big.Float, error) {
+	var v float64
+	switch len(b) {
+	case 4:
+		v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case 8:
+		v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+	default:
+		return readTempReal(b)
+	}
+	if math.IsNaN(v) {
+		return nil, errX87Invalid
+	}
+	return newFloat().SetFloat64(v), nil
+}
+
+// realBytes encodes x as a real of size bytes, rounding to nearest even.
+func realBytes(x *big.Float, size int) []byte {
+	b := make([]byte, size)
+	switch size {
+	case 4:
+		v, _ := x.Float32()
+		binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+	case 8:
+		v, _ := x.Float64()
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	default:
+		return tempReal(x)
+	}
+	return b
+}
+
+// readInteger decodes a little-endian two's complement integer.
+func readInteger(b []byte) *big.Float {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*uint(len(b))
+	return newFloat().SetInt64(int64(v<<shift) >> shift)
+}
+
+// integerBytes rounds x to an integer of size bytes. Values out of range
+// store the integer indefinite, the most negative integer.
+func integerBytes(x *big.Float, size int) []byte {
+	bits := 8 * uint(size)
+	limit := new(big.Int).Lsh(big.NewInt(1), bits-1)
+	v := new(big.Int).Neg(limit)
+	if i, _ := roundEven(x).Int(nil); i != nil && i.Cmp(v) >= 0 && i.Cmp(limit) < 0 {
+		v = i
+	}
+
+	u := v.Int64()
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(u >> (8 * i))
+	}
+	return b
+}
+
+// readBCD decodes the 10 byte packed decimal format: 18 digits, two per
+// byte from the least significant, then a sign byte.
+func readBCD(b []byte) *big.Float {
+	var v int64
+	for i := 8; i >= 0; i-- {
+		v = v*100 + int64(b[i]>>4)*10 + int64(b[i]&0xf)
+	}
+	if b[9]&0x80 != 0 {
+		v = -v
+	}
+	return newFloat().SetInt64(v)
+}
+
+// bcdBytes rounds x to an integer in packed decimal, storing the packed
+// decimal indefinite when it needs more than 18 digits.
+func bcdBytes(x *big.Float) []byte {
+	b := make([]byte, 10)
+	i, _ := roundEven(x).Int(nil)
+	if i == nil || i.CmpAbs(big.NewInt(999999999999999999)) > 0 {
+		b[7], b[8], b[9] = 0xc0, 0xff, 0xff
+		return b
+	}
+	if i.Sign() < 0 {
+		b[9] = 0x80
+	}
+	v := new(big.Int).Abs(i).Uint64()
+	for j := 0; j < 9; j++ {
+		b[j] = byte(v%10) | byte(v/10%10)<<4
+		v /= 100
+	}
+	return b
+}
+
+// readBytes reads n bytes at seg:off, wrapping within the segment.
+func (c *cpu) readBytes(seg, off uint16, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = c.mem[linearAddress(seg, off+uint16(i))]
+	}
+	return b
+}
+
+// writeBytes writes b at seg:off, wrapping within the segment.
+func (c *cpu) writeBytes(seg, off uint16, b []byte) {
+	for i, v := range b {
+		c.writeByte(linearAddress(seg, off+uint16(i)), v)
+	}
+}
+
+// x87EnvironmentSize is the size of the real mode environment FNSTENV stores:
+// the control, status and tag words followed by the instruction and operand
+// pointers, which the simulator stores as zero.
+const x87EnvironmentSize = 14
+
+func (c *cpu) storeEnvironment(seg, off uint16) {
+	env := make([]byte, x87EnvironmentSize)
+	binary.LittleEndian.PutUint16(env[0:], c.fpu.control)
+	binary.LittleEndian.PutUint16(env[2:], c.fpu.statusWord())
+	binary.LittleEndian.PutUint16(env[4:], c.fpu.tagWord())
+	c.writeBytes(seg, off, env)
+}
+
+func (c *cpu) loadEnvironment(seg, off uint16) {
+	env := c.readBytes(seg, off, x87EnvironmentSize)
+	c.fpu.control = binary.LittleEndian.Uint16(env[0:])
+	c.fpu.setStatusWord(binary.LittleEndian.Uint16(env[2:]))
+	c.fpu.setTagWord(binary.LittleEndian.Uint16(env[4:]))
+}
+
+// executeEsc runs an 8087 instruction. Without a coprocessor ESC does
+// nothing, as on an 8086 with an empty socket.
+func (c *cpu) executeEsc(e *esc) (err error) {
+	if c.fpu == nil {
+		return nil
+	}
+
+	name := e.form().mnemonic
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(big.ErrNaN); !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s: %w", name, errX87Invalid)
+		}
+	}()
+
+	if e.mod == 0b11 {
+		err = c.executeX87Register(e, name)
+	} else {
+		err = c.executeX87Memory(e, name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *cpu) executeX87Memory(e *esc, name string) error {
+	f := c.fpu
+	op, _ := e.memoryOp()
+	seg, off := c.effectiveAddress(e.common)
+	integer := strings.HasPrefix(name, "fi")
+
+	switch name {
+	case "fld", "fild", "fbld":
+		b := c.readBytes(seg, off, op.size)
+		var v *big.Float
+		switch name {
+		case "fld":
+			var err error
+			if v, err = readReal(b); err != nil {
+				return err
+			}
+		case "fild":
+			v = readInteger(b)
+		default:
+			v = readBCD(b)
+		}
+		return f.push(v)
+	case "fst", "fstp", "fist", "fistp", "fbstp":
+		x, err := f.st(0)
+		if err != nil {
+			return err
+		}
+		switch {
+		case name == "fbstp":
+			c.writeBytes(seg, off, bcdBytes(x))
+		case integer:
+			c.writeBytes(seg, off, integerBytes(x, op.size))
+		default:
+			c.writeBytes(seg, off, realBytes(x, op.size))
+		}
+		if strings.HasSuffix(name, "p") {
+			f.pop()
+		}
+	case "fldcw":
+		f.control = c.readMem(seg, off, true)
+	case "fnstcw":
+		c.writeMem(seg, off, true, f.control)
+	case "fnstsw":
+		c.writeMem(seg, off, true, f.statusWord())
+	case "fldenv":
+		c.loadEnvironment(seg, off)
+	case "fnstenv":
+		c.storeEnvironment(seg, off)
+	case "frstor":
+		c.loadEnvironment(seg, off)
+		for i := byte(0); i < 8; i++ {
+			if f.regs[(f.top+i)&7] == nil {
+				continue
+			}
+			v, err := readTempReal(c.readBytes(seg, off+x87EnvironmentSize+10*uint16(i), 10))
+			if err != nil {
+				return err
+			}
+			f.set(i, v)
+		}
+	case "fnsave":
+		c.storeEnvironment(seg, off)
+		for i := byte(0); i < 8; i++ {
+			if r := f.regs[(f.top+i)&7]; r != nil {
+				c.writeBytes(seg, off+x87EnvironmentSize+10*uint16(i), tempReal(r))
+			}
+		}
+		*f = *newX87(f.model)
+	default:
+		// The arithmetic and compare group.
+		b := c.readBytes(seg, off, op.size)
+		var v *big.Float
+		if integer {
+			v = readInteger(b)
+		} else {
+			var err error
+			if v, err = readReal(b); err != nil {
+				return err
+			}
+		}
+		st0, err := f.st(0)
+		if err != nil {
+			return err
+		}
+		switch strings.TrimPrefix(strings.TrimPrefix(name, "fi"), "f") {
+		case "com":
+			f.compare(st0, v)
+		case "comp":
+			f.compare(st0, v)
+			f.pop()
+		default:
+			f.set(0, f.arith(name, st0, v))
+		}
+	}
+	return nil
+}
+
+func (c *cpu) executeX87Register(e *esc, name string) error {
+	f := c.fpu
+	op, _ := e.registerOp()
+	i := e.rm
+	if need, ok := minFPU[name]; ok && f.model < need {
+		return fmt.Errorf("requires the %s, simulating the %s", need, f.model)
+	}
+
+	// Instructions that work on an empty stack.
+	switch name {
+	case "fnop", "fneni", "fndisi", "fnsetpm":
+		return nil
+	case "fninit":
+		*f = *newX87(f.model)
+		return nil
+	case "fnclex":
+		f.status &^= 0x80ff
+		return nil
+	case "ffree":
+		f.regs[(f.top+i)&7] = nil
+		return nil
+	case "fdecstp":
+		f.top = (f.top - 1) & 7
+		return nil
+	case "fincstp":
+		f.top = (f.top + 1) & 7
+		return nil
+	case "fxam":
+		f.examine()
+		return nil
+	case "fnstsw":
+		c.regs[regAX] = f.statusWord()
+		return nil
+	}
+	if v, ok := x87Constants[name]; ok {
+		k, _, err := newFloat().Parse(v, 10)
+		if err != nil {
+			return err
+		}
+		return f.push(k)
+	}
+
+	st0, err := f.st(0)
+	if err != nil {
+		return err
+	}
+	// fst and fstp only write st(i), which may be empty; the other forms
+	// with a register operand read it.
+	sti, err := f.st(i)
+	if err != nil && op.layout != fpuNone && name != "fst" && name != "fstp" {
+		return err
+	}
+
+	switch name {
+	case "fld":
+		return f.push(sti)
+	case "fxch":
+		f.set(0, sti)
+		f.set(i, st0)
+	case "fst", "fstp":
+		f.set(i, st0)
+		if name == "fstp" {
+			f.pop()
+		}
+	case "fcom", "fcomp", "fucom", "fucomp":
+		f.compare(st0, sti)
+		if strings.HasSuffix(name, "p") {
+			f.pop()
+		}
+	case "fcompp", "fucompp":
+		st1, err := f.st(1)
+		if err != nil {
+			return err
+		}
+		f.compare(st0, st1)
+		f.pop()
+		f.pop()
+	case "ftst":
+		f.compare(st0, newFloat())
+	case "fchs":
+		f.set(0, newFloat().Neg(st0))
+	case "fabs":
+		f.set(0, newFloat().Abs(st0))
+	case "fsqrt":
+		if st0.Sign() < 0 {
+			return errX87Invalid
+		}
+		if !st0.IsInf() {
+			f.set(0, newFloat().Sqrt(st0))
+		}
+	case "frndint":
+		f.set(0, roundEven(st0))
+	case "fscale":
+		st1, err := f.st(1)
+		if err != nil {
+			return err
+		}
+		n, _ := st1.Int64()
+		f.set(0, newFloat().SetMantExp(st0, int(max(min(n, 1<<20), -1<<20))))
+	case "fxtract":
+		if st0.Sign() == 0 {
+			f.status |= x87ZE
+			f.set(0, newFloat().SetInf(true))
+			return f.push(st0)
+		}
+		if st0.IsInf() {
+			return errX87Invalid
+		}
+		sig := newFloat()
+		exp := st0.MantExp(sig) - 1
+		f.set(0, newFloat().SetInt64(int64(exp)))
+		return f.push(sig.SetMantExp(sig, 1))
+	case "fprem", "fprem1":
+		st1, err := f.st(1)
+		if err != nil {
+			return err
+		}
+		r, q := remainder(st0, st1, name == "fprem1")
+		f.set(0, r)
+		// C2 clear reports a complete reduction; C0, C3 and C1 receive the
+		// low three quotient bits.
+		f.status &^= x87ConditionCodes
+		if q&0b100 != 0 {
+			f.status |= x87C0
+		}
+		if q&0b010 != 0 {
+			f.status |= x87C1
+		}
+		if q&0b001 != 0 {
+			f.status |= x87C3
+		}
+	case "fptan", "fsin", "fcos", "fsincos":
+		if st0.IsInf() {
+			return errX87Invalid
+		}
+		// Beyond 2^63 the chip leaves the operand alone and sets C2 for the
+		// program to reduce it first.
+		f.status &^= x87C2
+		if st0.MantExp(nil) > 63 {
+			f.status |= x87C2
+			return nil
+		}
+		s, co := x87SinCos(st0, x87WorkPrec)
+		switch name {
+		case "fptan":
+			f.set(0, s.Quo(s, co))
+			return f.push(newFloat().SetInt64(1))
+		case "fsin":
+			f.set(0, s)
+		case "fcos":
+			f.set(0, co)
+		default:
+			f.set(0, s)
+			return f.push(co)
+		}
+	case "f2xm1":
+		if new(big.Float).Abs(st0).Cmp(big.NewFloat(1)) > 0 {
+			// Outside -1 to 1 the result is undefined.
+			return errX87Invalid
+		}
+		f.set(0, x87Exp2m1(st0, x87WorkPrec))
+	case "fpatan", "fyl2x", "fyl2xp1":
+		st1, err := f.st(1)
+		if err != nil {
+			return err
+		}
+		var r *big.Float
+		switch name {
+		case "fpatan":
+			r = x87Atan2(st1, st0, x87WorkPrec)
+		case "fyl2x":
+			if r, err = f.log2Times(st1, st0, false); err != nil {
+				return err
+			}
+		default:
+			if r, err = f.log2Times(st1, st0, true); err != nil {
+				return err
+			}
+		}
+		f.set(1, r)
+		f.pop()
+	default:
+		// The arithmetic group: dst = dst op src, popping for the p forms.
+		dst, src := byte(0), i
+		if op.layout == fpuSTiST0 {
+			dst, src = i, 0
+		}
+		a, _ := f.st(dst)
+		b, _ := f.st(src)
+		f.set(dst, f.arith(name, a, b))
+		if strings.HasSuffix(name, "p") {
+			f.pop()
+		}
+	}
+	return nil
+}
+
+// state serialises the coprocessor for snapshots: the fpuModel byte, the
+// control, status and tag words, then the physical registers in temporary
+// real format.
+func (f *x87) state() []byte {
+	b := []byte{byte(f.model)}
+	b = binary.LittleEndian.AppendUint16(b, f.control)
+	b = binary.LittleEndian.AppendUint16(b, f.statusWord())
+	b = binary.LittleEndian.AppendUint16(b, f.tagWord())
+	for _, r := range f.regs {
+		if r == nil {
+			r = newFloat()
+		}
+		b = append(b, tempReal(r)...)
+	}
+	return b
+}
+
+// x87StateSize is the length of the state written by x87.state.
+const x87StateSize = 1 + 3*2 + 8*10
+
+// restoreX87 rebuilds a coprocessor from x87.state output.
+func restoreX87(b []byte) (*x87, error) {
+	if len(b) != x87StateSize {
+		return nil, fmt.Errorf("coprocessor state of %d bytes, want %d", len(b), x87StateSize)
+	}
+	if int(b[0]) >= len(fpuModelNames) {
+		return nil, fmt.Errorf("unknown coprocessor model %d", b[0])
+	}
+	f := newX87(fpuModel(b[0]))
+	f.control = binary.LittleEndian.Uint16(b[1:])
+	f.setStatusWord(binary.LittleEndian.Uint16(b[3:]))
+	tw := binary.LittleEndian.Uint16(b[5:])
+	for i := range f.regs {
+		if (tw>>(2*i))&0b11 == 0b11 {
+			continue
+		}
+		v, err := readTempReal(b[7+10*i:])
+		if err != nil {
+			return nil, err
+		}
+		f.regs[i] = v
+	}
+	return f, nil
+}
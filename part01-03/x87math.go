@@ -0,0 +1,239 @@
+package main
+
+import (
+	"math/big"
+)
+
+// The transcendental instructions are evaluated with series in big.Float
+// arithmetic at x87WorkPrec bits, x87GuardBits more than the register format,
+// and rounded to the register format once at the end.
+const (
+	x87GuardBits = 32
+	x87WorkPrec  = x87Precision + x87GuardBits
+)
+
+// x87Float returns v at precision prec.
+func x87Float(prec uint, v float64) *big.Float {
+	return new(big.Float).SetPrec(prec).SetFloat64(v)
+}
+
+// negligible reports whether term no longer changes sum at precision prec.
+func negligible(term, sum *big.Float, prec uint) bool {
+	return term.Sign() == 0 || (sum.Sign() != 0 && term.MantExp(nil) < sum.MantExp(nil)-int(prec))
+}
+
+// oddSeries sums x + s·x³/3 + x⁵/5 + s·x⁷/7 + …, with s = -1 for atan and
+// s = 1 for atanh. It converges quickly for small |x|.
+func oddSeries(x *big.Float, alternate bool, prec uint) *big.Float {
+	x2 := new(big.Float).SetPrec(prec).Mul(x, x)
+	sum := new(big.Float).SetPrec(prec).Set(x)
+	pow := new(big.Float).SetPrec(prec).Set(x)
+	term := new(big.Float).SetPrec(prec)
+	for k := 1; ; k++ {
+		pow.Mul(pow, x2)
+		if alternate {
+			pow.Neg(pow)
+		}
+		term.Quo(pow, x87Float(prec, float64(2*k+1)))
+		if negligible(term, sum, prec) {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
+
+// x87Pi returns π at precision prec with Machin's formula,
+// 16·atan(1/5) − 4·atan(1/239).
+func x87Pi(prec uint) *big.Float {
+	prec += 8
+	a := oddSeries(new(big.Float).SetPrec(prec).Quo(x87Float(prec, 1), x87Float(prec, 5)), true, prec)
+	b := oddSeries(new(big.Float).SetPrec(prec).Quo(x87Float(prec, 1), x87Float(prec, 239)), true, prec)
+	a.Mul(a, x87Float(prec, 16))
+	b.Mul(b, x87Float(prec, 4))
+	return a.Sub(a, b)
+}
+
+// x87Ln2 returns ln 2 = 2·atanh(1/3) at precision prec.
+func x87Ln2(prec uint) *big.Float {
+	prec += 8
+	s := oddSeries(new(big.Float).SetPrec(prec).Quo(x87Float(prec, 1), x87Float(prec, 3)), false, prec)
+	return s.SetMantExp(s, 1)
+}
+
+// sinCosReduced returns sin r and cos r for |r| ≤ π/4 from their series.
+func sinCosReduced(r *big.Float, prec uint) (sin, cos *big.Float) {
+	r2 := new(big.Float).SetPrec(prec).Mul(r, r)
+	series := func(first *big.Float, k0 int) *big.Float {
+		sum := new(big.Float).SetPrec(prec).Set(first)
+		term := new(big.Float).SetPrec(prec).Set(first)
+		for k := k0; ; k += 2 {
+			term.Mul(term, r2)
+			term.Quo(term, x87Float(prec, float64(k*(k+1))))
+			term.Neg(term)
+			if negligible(term, sum, prec) {
+				return sum
+			}
+			sum.Add(sum, term)
+		}
+	}
+	return series(r, 2), series(x87Float(prec, 1), 1)
+}
+
+// x87SinCos returns sin x and cos x for finite x at precision prec. The
+// argument is reduced by multiples of π/2 with π carried to as many extra
+// bits as x has integer bits, so the reduction is exact enough for any x.
+func x87SinCos(x *big.Float, prec uint) (sin, cos *big.Float) {
+	// The extra 64 bits cover the cancellation when x lies close to a
+	// multiple of π/2.
+	rprec := prec + 64
+	if e := x.MantExp(nil); e > 0 {
+		rprec += uint(e)
+	}
+	halfPi := x87Pi(rprec)
+	halfPi.SetMantExp(halfPi, -1)
+
+	q := new(big.Float).SetPrec(rprec).Quo(x, halfPi)
+	n, _ := roundEven(q).Int(nil)
+	r := new(big.Float).SetPrec(rprec).Mul(new(big.Float).SetPrec(rprec).SetInt(n), halfPi)
+	r.Sub(x, r)
+	r.SetPrec(prec)
+
+	s, c := sinCosReduced(r, prec)
+	switch n.Bit(0) + 2*n.Bit(1) { // n mod 4, also for negative n.
+	case 1:
+		s, c = c, s.Neg(s)
+	case 2:
+		s, c = s.Neg(s), c.Neg(c)
+	case 3:
+		s, c = c.Neg(c), s
+	}
+	return s, c
+}
+
+// x87Atan returns atan x at precision prec, halving the angle with
+// atan(x) = 2·atan(x / (1 + √(1 + x²))) until |x| < 1/8.
+func x87Atan(x *big.Float, prec uint) *big.Float {
+	if x.IsInf() {
+		halfPi := x87Pi(prec)
+		halfPi.SetMantExp(halfPi, -1)
+		if x.Signbit() {
+			halfPi.Neg(halfPi)
+		}
+		return halfPi
+	}
+	x = new(big.Float).SetPrec(prec).Set(x)
+	doublings := 0
+	limit := x87Float(prec, 0.125)
+	one := x87Float(prec, 1)
+	for new(big.Float).Abs(x).Cmp(limit) >= 0 {
+		d := new(big.Float).SetPrec(prec).Mul(x, x)
+		d.Add(d, one)
+		d.Sqrt(d)
+		d.Add(d, one)
+		x.Quo(x, d)
+		doublings++
+	}
+	sum := oddSeries(x, true, prec)
+	return sum.SetMantExp(sum, doublings)
+}
+
+// x87Atan2 returns the angle of the point (x, y) as FPATAN computes it from
+// ST1 = y and ST0 = x, at precision prec.
+func x87Atan2(y, x *big.Float, prec uint) *big.Float {
+	pi := x87Pi(prec)
+	withSign := func(v *big.Float) *big.Float {
+		if y.Signbit() {
+			v.Neg(v)
+		}
+		return v
+	}
+	switch {
+	case y.Sign() == 0 && !x.Signbit():
+		return new(big.Float).SetPrec(prec).Set(y)
+	case y.Sign() == 0:
+		return withSign(pi)
+	case x.Sign() == 0 || (y.IsInf() && !x.IsInf()):
+		return withSign(pi.SetMantExp(pi, -1))
+	case x.IsInf() && y.IsInf():
+		// ±π/4 or ±3π/4.
+		v := pi.SetMantExp(pi, -2)
+		if x.Signbit() {
+			v.Mul(v, x87Float(prec, 3))
+		}
+		return withSign(v)
+	case x.IsInf() && !x.Signbit():
+		return withSign(new(big.Float).SetPrec(prec))
+	case x.IsInf():
+		return withSign(pi)
+	}
+
+	a := x87Atan(new(big.Float).SetPrec(prec).Quo(y, x), prec)
+	if x.Sign() < 0 {
+		if y.Sign() < 0 {
+			pi.Neg(pi)
+		}
+		a.Add(a, pi)
+	}
+	return a
+}
+
+// x87Exp2m1 returns 2^x − 1 at precision prec for |x| ≤ 1. It halves
+// t = x·ln 2 until the expm1 series converges quickly, then undoes each
+// halving with expm1(2u) = expm1(u)·(expm1(u) + 2), which keeps the
+// relative precision of small results.
+func x87Exp2m1(x *big.Float, prec uint) *big.Float {
+	t := new(big.Float).SetPrec(prec).Mul(x, x87Ln2(prec))
+	if t.Sign() == 0 {
+		return t
+	}
+	halvings := 0
+	if e := t.MantExp(nil); e > -4 {
+		halvings = e + 4
+		t.SetMantExp(t, -halvings)
+	}
+
+	sum := new(big.Float).SetPrec(prec).Set(t)
+	term := new(big.Float).SetPrec(prec).Set(t)
+	for k := 2; ; k++ {
+		term.Mul(term, t)
+		term.Quo(term, x87Float(prec, float64(k)))
+		if negligible(term, sum, prec) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+
+	two := x87Float(prec, 2)
+	for ; halvings > 0; halvings-- {
+		e := new(big.Float).SetPrec(prec).Add(sum, two)
+		sum.Mul(sum, e)
+	}
+	return sum
+}
+
+// x87Log2 returns log2 x for finite x > 0 at precision prec. With
+// x = m·2^e and m in [1/√2, √2), log2 x = e + 2·atanh((m − 1)/(m + 1)) / ln 2.
+func x87Log2(x *big.Float, prec uint) *big.Float {
+	m := new(big.Float).SetPrec(prec)
+	e := x.MantExp(m)
+	if m.Cmp(x87Float(prec, 0.7071067811865476)) < 0 {
+		m.SetMantExp(m, 1)
+		e--
+	}
+	one := x87Float(prec, 1)
+	num := new(big.Float).SetPrec(prec).Sub(m, one)
+	den := new(big.Float).SetPrec(prec).Add(m, one)
+	l := oddSeries(num.Quo(num, den), false, prec)
+	l.SetMantExp(l, 1)
+	l.Quo(l, x87Ln2(prec))
+	return l.Add(l, x87Float(prec, float64(e)))
+}
+
+// x87Log2p1 returns log2(1 + x) for |x| < 1/2 at precision prec, through
+// ln(1 + x) = 2·atanh(x / (2 + x)), which stays accurate for tiny x.
+func x87Log2p1(x *big.Float, prec uint) *big.Float {
+	den := new(big.Float).SetPrec(prec).Add(x, x87Float(prec, 2))
+	l := oddSeries(new(big.Float).SetPrec(prec).Quo(x, den), false, prec)
+	l.SetMantExp(l, 1)
+	return l.Quo(l, x87Ln2(prec))
+}
@@ -1,362 +1,166 @@
 package main
 
 import (
-	"encoding/binary"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
-	"math"
-	"math/rand"
 	"os"
+	"runtime"
 	"strconv"
-	"strings"
-	"sync"
-)
-
-type SampleType string
+	"time"
 
-const (
-	Uniform   SampleType = "uniform"
-	Clustered SampleType = "clustered"
+	"github.com/ahrav/perf-aware-programming/haversine"
 )
 
-func main() {
-	if len(os.Args) != 4 {
-		log.Fatal("usage requires 4 arguments: <main> <uniform/clustered> <random-seed> <num-points>")
-	}
+const usage = `usage: %s [flags] <distribution> <random-seed> <num-pairs>
 
-	spread := os.Args[1]
-	if spread != string(Uniform) && spread != string(Clustered) {
-		log.Fatal("usage requires 4 arguments: <main> <uniform/clustered> <random-seed> <num-points>")
-	}
+Generates num-pairs coordinate pairs as JSON, with the distance of every pair
+in an answer file.
 
-	seed, err := strconv.Atoi(os.Args[2])
-	if err != nil {
+Distributions:
+%s
+Formats:
+%s
+Flags:
+`
+
+func main() {
+	opts := haversine.DefaultOptions(0, 0)
+	out := flag.String("out", "data", "output path `prefix`: writes the pairs to <prefix> and the format's extension, the answers to <prefix>.bin")
+	flag.IntVar(&opts.Clusters, "clusters", opts.Clusters, "number of clusters the pairs are spread over")
+	flag.Float64Var(&opts.Radius, "radius", opts.Radius, "half the side of a clustered cluster, in `degrees`")
+	flag.Float64Var(&opts.Sigma, "sigma", opts.Sigma, "standard deviation of a gaussian cluster, in `degrees`")
+	flag.Float64Var(&opts.Delta, "delta", opts.Delta, "largest offset of a near-antipodal or near-identical point, in `degrees`")
+	formatName := flag.String("format", haversine.JSONFormat, "output `format` of the pairs")
+	quiet := flag.Bool("quiet", false, "do not print statistics")
+	showHistogram := flag.Bool("histogram", false, "print the distance histogram next to those of the uniform and sphere distributions")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0], haversine.DistributionUsage(), haversine.FormatUsage())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dist, ok := haversine.LookupDistribution(haversine.SampleType(flag.Arg(0)))
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if opts.Format, ok = haversine.LookupFormat(*formatName); !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if opts.Seed, err = strconv.Atoi(flag.Arg(1)); err != nil {
 		log.Fatal(err)
 	}
-	numPoints, err := strconv.Atoi(os.Args[3])
-	if err != nil {
+	if opts.Pairs, err = strconv.Atoi(flag.Arg(2)); err != nil {
 		log.Fatal(err)
 	}
-
-	// Generate the data.
-	if spread == string(Uniform) {
-		uniform(seed, numPoints)
-	} else {
-		clustered(seed, numPoints)
+	if err := opts.Validate(); err != nil {
+		log.Fatal(err)
 	}
-}
-
-// Point represents a point in 2D space.
-type Point struct {
-	X, Y float64
-}
-
-type result struct {
-	sum  float64
-	data string
-}
 
-const NumClusters int = 32
-
-// uniform generates a uniform distribution of haversine distances.
-func uniform(seed, numPoints int) {
-	// Set the random seed.
-	binFile, outputFile := createOutputFiles()
+	binFile, outputFile := createOutputFiles(*out, opts.Format.Ext)
 	defer binFile.Close()
 	defer outputFile.Close()
-
-	// Output file json format:
-	// { "pairs": [
-	// {"X1": <float>, "Y1": <float>, "X2": <float>, "Y2": <float>, "distance": <float>},
-	// ...
-	// ]}
-	outputFile.WriteString("{\"pairs\": [\n")
-
-	ptsPerCluster := numPoints / NumClusters
-
-	resultsChan := make(chan result, NumClusters)
-	wg := sync.WaitGroup{}
-
-	for idx := 0; idx < NumClusters; idx++ {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			var localSum float64
-			var dataBuilder strings.Builder
-
-			// Unique random generator for goroutine
-			src := rand.NewSource(int64(seed) + int64(idx))
-			lr := rand.New(src)
-
-			for j := 0; j < ptsPerCluster; j++ {
-				p1 := Point{lr.Float64()*360 - 180, lr.Float64()*180 - 90}
-				p2 := Point{lr.Float64()*360 - 180, lr.Float64()*180 - 90}
-				dist := Haversine(p1.Y, p1.X, p2.Y, p2.X)
-				localSum += dist
-
-				dataString := pointToJSONString(p1, p2, dist)
-				dataBuilder.WriteString(dataString)
-
-				if j != ptsPerCluster-1 {
-					dataBuilder.WriteString(",\n")
-				}
-			}
-
-			resultsChan <- result{sum: localSum, data: dataBuilder.String()}
-		}(idx)
+	pairsCount, binCount := &countingWriter{w: outputFile}, &countingWriter{w: binFile}
+	pairsBuf, binOut := bufio.NewWriterSize(pairsCount, outputBufferSize), bufio.NewWriterSize(binCount, outputBufferSize)
+	// The binary format buffers its arrays itself and writes them at their
+	// offsets in the file.
+	var pairsOut io.Writer = pairsBuf
+	if opts.Format.SoA {
+		pairsOut = pairsCount
 	}
 
-	// Close resultsChan after all goroutines are done.
-	go func() {
-		wg.Wait()
-		close(resultsChan)
-	}()
-
-	outputFile.WriteString("{\"pairs\": [\n")
-	var globalSum float64
-	isFirstResult := true
-	for res := range resultsChan {
-		if err := binary.Write(binFile, binary.LittleEndian, res.sum); err != nil {
-			log.Fatal(err)
-		}
-		globalSum += res.sum
-		writeJSONToFile(outputFile, isFirstResult, res.data)
-		isFirstResult = false
+	// Generate the data.
+	start := time.Now()
+	if *showHistogram {
+		opts.Histogram = haversine.NewHistogram()
 	}
-	outputFile.WriteString("\n]}\n")
-
-	printStats(globalSum, numPoints, seed)
-}
-
-// ClusterSize is the size of the cluster in degrees.
-// The cluster will be a square with sides of length ClusterSize.
-const ClusterSize float64 = 32
-
-type Cluster struct {
-	Xmin, Xmax float64
-	Ymin, Ymax float64
-}
-
-// clustered generates a clustered distribution of haversine distances.
-func clustered(seed, numPoints int) {
-	r := rand.New(rand.NewSource(int64(seed)))
-
-	// Generate a clustered distribution of points.
-	ptsPerCluster := numPoints / NumClusters
-	clusters := make([]Cluster, NumClusters)
-	for i := 0; i < NumClusters; i++ {
-		// Random center for each cluster
-		centerX := r.Float64()*360 - 180 // Longitude between -180 and 180
-		centerY := r.Float64()*180 - 90  // Latitude between -90 and 90
-
-		// Create a bounding box around the center.
-		clusters[i] = Cluster{
-			Xmin: math.Max(centerX-ClusterSize, -180),
-			Xmax: math.Min(centerX+ClusterSize, 180),
-			Ymin: math.Max(centerY-ClusterSize, -90),
-			Ymax: math.Min(centerY+ClusterSize, 90),
-		}
+	sum, err := dist.Generate(pairsOut, binOut, opts)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	binFile, outputFile := createOutputFiles()
-	defer binFile.Close()
-	defer outputFile.Close()
-	// Output file json format:
-	// { "pairs": [
-	// {"X1": <float>, "Y1": <float>, "X2": <float>, "Y2": <float>, "distance": <float>},
-	// ...
-	// ]}
-	outputFile.WriteString("{\"pairs\": [\n")
-
-	resultsChan := make(chan result, len(clusters))
-	wg := sync.WaitGroup{}
-
-	for idx, c := range clusters {
-		wg.Add(1)
-		go func(idx int, c Cluster) {
-			defer wg.Done()
-			var localSum float64
-			var dataBuilder strings.Builder
-
-			// Create a local unique random generator for this goroutine since rand is not thread-safe.
-			src := rand.NewSource(int64(seed) + int64(idx))
-			lr := rand.New(src)
-
-			for j := 0; j < ptsPerCluster; j++ {
-				p1 := Point{X: lr.Float64()*(c.Xmax-c.Xmin) + c.Xmin, Y: lr.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
-				p2 := Point{X: lr.Float64()*(c.Xmax-c.Xmin) + c.Xmin, Y: lr.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
-				dist := Haversine(p1.Y, p1.X, p2.Y, p2.X)
-				localSum += dist
-
-				dataString := pointToJSONString(p1, p2, dist)
-				dataBuilder.WriteString(dataString)
-				// Add comma if not last cluster or not last point within cluster.
-				// Only add a comma if it's not the last point within the cluster
-				if j != ptsPerCluster-1 {
-					dataBuilder.WriteString(",\n")
-				}
-			}
-
-			resultsChan <- result{sum: localSum, data: dataBuilder.String()}
-		}(idx, c)
+	if err := pairsBuf.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := binOut.Flush(); err != nil {
+		log.Fatal(err)
 	}
 
-	// Close resultsChan once all goroutines are done.
-	go func() {
-		wg.Wait()
-		close(resultsChan)
-	}()
-
-	// Read from resultsChan.
-	var globalSum float64
-	isFirstResult := true
-	for res := range resultsChan {
-		if err := binary.Write(binFile, binary.LittleEndian, res.sum); err != nil {
+	if !*quiet {
+		printStats(sum, opts)
+		printThroughput(pairsCount.n+binCount.n, opts.Pairs, time.Since(start))
+	}
+	if *showHistogram {
+		if err := haversine.CompareHistograms(os.Stdout, dist, opts); err != nil {
 			log.Fatal(err)
 		}
-		globalSum += res.sum
-		writeJSONToFile(outputFile, isFirstResult, res.data)
-		isFirstResult = false
 	}
-	outputFile.WriteString("\n]}\n")
-
-	// Print the average distance.
-	printStats(globalSum, numPoints, seed)
-	return
 }
 
-func createOutputFiles() (*os.File, *os.File) {
-	binFile, err := os.Create("data.bin")
-	if err != nil {
-		log.Fatal(err)
-	}
+// outputBufferSize is the size of the buffers in front of the output files.
+const outputBufferSize = 1 << 20
 
-	outputFile, err := os.Create("data.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-	return binFile, outputFile
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
 }
 
-// Assuming Point struct has X and Y fields.
-func pointToJSONString(p1, p2 Point, distance float64) string {
-	return "{\"X1\": " + strconv.FormatFloat(p1.X, 'f', -1, 64) +
-		", \"Y1\": " + strconv.FormatFloat(p1.Y, 'f', -1, 64) +
-		", \"X2\": " + strconv.FormatFloat(p2.X, 'f', -1, 64) +
-		", \"Y2\": " + strconv.FormatFloat(p2.Y, 'f', -1, 64) + "}"
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
 
-func writeJSONToFile(outputFile *os.File, isFirstData bool, data string) {
-	if !isFirstData {
-		outputFile.WriteString(",\n")
+// WriteAt writes p at off if the underlying writer is an io.WriterAt.
+func (cw *countingWriter) WriteAt(p []byte, off int64) (int, error) {
+	wa, ok := cw.w.(io.WriterAt)
+	if !ok {
+		return 0, errors.New("output is not seekable")
 	}
-	if _, err := outputFile.WriteString(data); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func printStats(sum float64, numPoints, seed int) {
-	log.Printf("Average distance: %f", sum/float64(numPoints))
-	log.Println("Number of points:", numPoints)
-	log.Println("Random seed:", seed)
+	n, err := wa.WriteAt(p, off)
+	cw.n += int64(n)
+	return n, err
 }
 
-func clusteredSerial(seed, numPoints int) {
-	// Generate a clustered distribution of points.
-	ptsPerCluster := numPoints / NumClusters
-	var clusters []Cluster
-	stepX := 360.0 / float64(NumClusters) // Longitude steps
-	stepY := 180.0 / float64(NumClusters) // Latitude steps
-
-	for i := 0; i < NumClusters; i++ {
-		// Each cluster will only contain points within a certain range of latitudes and longitudes.
-		// The range of the lattiudes and longitudes will be different for each cluster depending on the cluster number.
-		clusters = append(clusters, Cluster{
-			Xmin: -180 + stepX*float64(i),
-			Xmax: -180 + stepX*float64(i+1),
-			Ymin: -90 + stepY*float64(i),
-			Ymax: -90 + stepY*float64(i+1),
-		})
-	}
-
-	r := rand.New(rand.NewSource(int64(seed)))
-	binFile, err := os.Create("data.bin")
+// createOutputFiles creates the answer file <prefix>.bin and the pairs file
+// <prefix><ext>.
+func createOutputFiles(prefix, ext string) (*os.File, *os.File) {
+	binFile, err := os.Create(prefix + ".bin")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer binFile.Close()
 
-	outputFile, err := os.Create("data.json")
+	outputFile, err := os.Create(prefix + ext)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer outputFile.Close()
-	// Output file json format:
-	// { "pairs": [
-	// {"X1": <float>, "Y1": <float>, "X2": <float>, "Y2": <float>, "distance": <float>},
-	// ...
-	// ]}
-	outputFile.WriteString("{\"pairs\": [\n")
-
-	sum := 0.0
-	totalClusters := len(clusters)
-	for i, c := range clusters {
-		// Generate a uniform distribution of haversine distances.
-		for j := 0; j < ptsPerCluster; j++ {
-			p1 := Point{X: r.Float64()*(c.Xmax-c.Xmin) + c.Xmin, Y: r.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
-			p2 := Point{X: r.Float64()*(c.Xmax-c.Xmin) + c.Xmin, Y: r.Float64()*(c.Ymax-c.Ymin) + c.Ymin}
-			dist := Haversine(p1.Y, p1.X, p2.Y, p2.X)
-			if err := binary.Write(binFile, binary.LittleEndian, dist); err != nil {
-				log.Fatal(err)
-			}
-			sum += dist
-
-			// Write the data (without a comma at the end)
-			dataString := "{\"X1\": " + strconv.FormatFloat(p1.X, 'f', -1, 64) +
-				", \"Y1\": " + strconv.FormatFloat(p1.Y, 'f', -1, 64) +
-				", \"X2\": " + strconv.FormatFloat(p2.X, 'f', -1, 64) +
-				", \"Y2\": " + strconv.FormatFloat(p2.Y, 'f', -1, 64) + "}"
-
-			if _, err := outputFile.WriteString(dataString); err != nil {
-				log.Fatal(err)
-			}
-
-			// If it's not the last iteration of both loops, append a comma.
-			if i != totalClusters-1 || j != ptsPerCluster-1 {
-				outputFile.WriteString(",\n")
-			}
-		}
-	}
-	outputFile.WriteString("\n]}\n")
-
-	// Print the average distance.
-	log.Printf("Average distance: %f", sum/float64(numPoints))
-	log.Println("Number of points:", numPoints)
-	log.Println("Random seed:", seed)
-
-	return
-}
-
-// Square returns the square of the input.
-func Square(x float64) float64 {
-	return math.Pow(x, 2)
+	return binFile, outputFile
 }
 
-// Radians converts degrees to radians.
-func Radians(d float64) float64 {
-	return d * math.Pi / 180
+func printStats(sum float64, opts haversine.Options) {
+	log.Printf("Average distance: %f", sum/float64(opts.Pairs))
+	log.Println("Number of pairs:", opts.Pairs)
+	log.Println("Random seed:", opts.Seed)
 }
 
-// EarthRadius is the radius of the earth in kilometers.
-const EarthRadius = 6372.8 // km
-
-// Haversine computes the great circle distance between two points on the Earth.
-func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
-	dLat := Radians(lat2 - lat1)
-	dLon := Radians(lon2 - lon1)
-	lat1 = Radians(lat1)
-	lat2 = Radians(lat2)
-
-	a := Square(math.Sin(dLat/2)) + math.Cos(lat1)*math.Cos(lat2)*Square(math.Sin(dLon/2))
-	c := 2 * math.Asin(math.Sqrt(a))
-	return EarthRadius * c
+// printThroughput reports how fast the output was generated and how much
+// memory the generator held on to while doing it.
+func printThroughput(bytes int64, pairs int, dur time.Duration) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	secs := dur.Seconds()
+	log.Printf("Wrote %.1f MB in %s: %.1f MB/s, %.0f pairs/s", float64(bytes)/(1<<20), dur.Round(time.Millisecond), float64(bytes)/(1<<20)/secs, float64(pairs)/secs)
+	log.Printf("Memory obtained from the OS: %.1f MB", float64(m.Sys)/(1<<20))
 }
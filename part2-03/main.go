@@ -3,8 +3,8 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"os/exec"
 	"strconv"
@@ -12,28 +12,48 @@ import (
 	"time"
 
 	"github.com/goccy/go-json"
+
+	"github.com/ahrav/perf-aware-programming/haversine"
 )
 
 const mb = 1024 * 1024
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("usage requires at least 2 arguments: <main> <json-file> <optional: profile>")
+		log.Fatal("usage requires at least 2 arguments: <main> <json-file> <optional: profile | test | validate <answers.bin> [tolerance-km]>")
 	}
 
 	var (
-		shouldProfile bool
-		shouldTest    bool
+		shouldProfile  bool
+		shouldTest     bool
+		shouldValidate bool
 	)
 	if len(os.Args) > 2 {
 		shouldProfile = os.Args[2] == "profile"
 		shouldTest = os.Args[2] == "test"
+		shouldValidate = os.Args[2] == "validate"
 	}
 
 	if shouldTest {
 		repetitionTester(os.Args[1])
 		return
 	}
+	if shouldValidate {
+		if len(os.Args) < 4 {
+			log.Fatal("usage: <main> <pairs-file> validate <answers.bin> [tolerance-km], with the pairs in any generator format, chosen by extension")
+		}
+		tolerance := defaultTolerance
+		if len(os.Args) > 4 {
+			var err error
+			if tolerance, err = strconv.ParseFloat(os.Args[4], 64); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if !validateFile(os.Args[1], os.Args[3], tolerance, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 
 	filename := os.Args[1]
 	file, err := os.Open(filename)
@@ -159,26 +179,18 @@ func getPageFaults(pid int) (int, error) {
 	return pageFaults, nil
 }
 
-// GeoPair represents a pair of points in 2D space.
-type GeoPair struct {
-	X1 float64 `json:"X1"`
-	Y1 float64 `json:"Y1"`
-	X2 float64 `json:"X2"`
-	Y2 float64 `json:"Y2"`
-}
-
-// GeoPairsContainer represents a container for a slice of GeoPairs.
+// GeoPairsContainer represents a container for a slice of pairs.
 type GeoPairsContainer struct {
-	Pairs []GeoPair `json:"pairs"`
+	Pairs []haversine.Pair `json:"pairs"`
 }
 
 const expectedGeoPairs = 10_000_000 // Expected number of GeoPairs
 
-func readGeoPairsFromFile(file *os.File) ([]GeoPair, error) {
+func readGeoPairsFromFile(r io.Reader) ([]haversine.Pair, error) {
 	var container GeoPairsContainer
-	container.Pairs = make([]GeoPair, 0, expectedGeoPairs)
+	container.Pairs = make([]haversine.Pair, 0, expectedGeoPairs)
 
-	decoder := json.NewDecoder(file)
+	decoder := json.NewDecoder(r)
 	if err := decoder.Decode(&container); err != nil {
 		return nil, err
 	}
@@ -186,37 +198,22 @@ func readGeoPairsFromFile(file *os.File) ([]GeoPair, error) {
 	return container.Pairs, nil
 }
 
-func calcHaversineDistanceAvg(pairs []GeoPair) float64 {
-	var sum float64
-	for _, pair := range pairs {
-		sum += Haversine(pair.Y1, pair.X1, pair.Y2, pair.X2)
-	}
-	return sum / float64(len(pairs))
-}
-
-// EarthRadius is the radius of the earth in kilometers.
-const EarthRadius = 6372.8 // km
-
-// Haversine computes the great circle distance between two points on the Earth.
-func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
-	dLat := Radians(lat2 - lat1)
-	dLon := Radians(lon2 - lon1)
-	lat1 = Radians(lat1)
-	lat2 = Radians(lat2)
-
-	a := Square(math.Sin(dLat/2)) + math.Cos(lat1)*math.Cos(lat2)*Square(math.Sin(dLon/2))
-	c := 2 * math.Asin(math.Sqrt(a))
-	return EarthRadius * c
+// pairDistance is the processor's distance for one pair. Validation checks
+// it against the generator's reference answers, so an optimised formula goes
+// here.
+func pairDistance(p haversine.Pair) float64 {
+	return haversine.Haversine(p.Y1, p.X1, p.Y2, p.X2)
 }
 
-// Square returns the square of the input.
-func Square(x float64) float64 {
-	return x * x
+func calcHaversineDistanceAvg(pairs []haversine.Pair) float64 {
+	return averageDistance(pairs, pairDistance)
 }
 
-const reciprocal180 = 1.0 / 180
-
-// Radians converts degrees to radians.
-func Radians(d float64) float64 {
-	return d * math.Pi * reciprocal180
+// averageDistance returns the mean of distance over pairs.
+func averageDistance(pairs []haversine.Pair, distance func(haversine.Pair) float64) float64 {
+	var sum float64
+	for _, pair := range pairs {
+		sum += distance(pair)
+	}
+	return sum / float64(len(pairs))
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/ahrav/perf-aware-programming/haversine"
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+// defaultTolerance is the largest absolute error in kilometres a distance
+// may have and still match its reference: a millimetre.
+const defaultTolerance = 1e-6
+
+// maxReportedMismatches is the number of mismatching pair indices a
+// validation keeps.
+const maxReportedMismatches = 10
+
+// validation compares the distances computed for a set of pairs with the
+// generator's reference answers.
+type validation struct {
+	pairs, expectedPairs int
+	tolerance            float64
+
+	maxAbsErr   float64 // Largest absolute error of a pair, in km.
+	maxErrIndex int
+	meanAbsErr  float64
+	mismatches  int   // Pairs whose error exceeds the tolerance.
+	first       []int // Indices of the first mismatches.
+
+	average, expectedAverage float64
+}
+
+// validate computes the distance of every pair with distance, the
+// processor's pairDistance outside tests, and compares it with the reference
+// distance of the same index, streamed from ref. It fails if the answer file
+// is damaged.
+func validate(pairs []haversine.Pair, ref *answers.Reader, distance func(haversine.Pair) float64, tolerance float64) (validation, error) {
+	v := validation{
+		pairs:       len(pairs),
+		tolerance:   tolerance,
+		maxErrIndex: -1,
+		average:     averageDistance(pairs, distance),
+	}
+
+	var errSum float64
+	for i := 0; ref.Next(); i++ {
+		v.expectedPairs++
+		if i >= len(pairs) {
+			continue
+		}
+		err := math.Abs(distance(pairs[i]) - ref.Distance())
+		if math.IsNaN(err) {
+			err = math.Inf(1)
+		}
+		errSum += err
+		if err > v.maxAbsErr || v.maxErrIndex < 0 {
+			v.maxAbsErr, v.maxErrIndex = err, i
+		}
+		if err > tolerance {
+			v.mismatches++
+			if len(v.first) < maxReportedMismatches {
+				v.first = append(v.first, i)
+			}
+		}
+	}
+	if err := ref.Err(); err != nil {
+		return v, err
+	}
+	v.expectedAverage = ref.Average()
+	if n := min(len(pairs), v.expectedPairs); n > 0 {
+		v.meanAbsErr = errSum / float64(n)
+	}
+	return v, nil
+}
+
+// averageMatches reports whether the average distance is within tolerance
+// of the reference average.
+func (v validation) averageMatches() bool {
+	return math.Abs(v.average-v.expectedAverage) <= v.tolerance
+}
+
+// ok reports whether the pair count, every pair and the average match.
+func (v validation) ok() bool {
+	return v.pairs == v.expectedPairs && v.mismatches == 0 && v.averageMatches()
+}
+
+// write prints the result of the validation.
+func (v validation) write(w io.Writer) {
+	status := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+		return "MISMATCH"
+	}
+
+	fmt.Fprintf(w, "Pairs: %d, expected %d: %s\n", v.pairs, v.expectedPairs, status(v.pairs == v.expectedPairs))
+	fmt.Fprintf(w, "Average distance: %f, expected %f (error %g): %s\n", v.average, v.expectedAverage, math.Abs(v.average-v.expectedAverage), status(v.averageMatches()))
+	if v.maxErrIndex >= 0 {
+		fmt.Fprintf(w, "Max absolute error: %g km at pair %d\n", v.maxAbsErr, v.maxErrIndex)
+		fmt.Fprintf(w, "Mean absolute error: %g km\n", v.meanAbsErr)
+	}
+	fmt.Fprintf(w, "Pairs over the %g km tolerance: %d\n", v.tolerance, v.mismatches)
+	if len(v.first) > 0 {
+		fmt.Fprintf(w, "First mismatches: %v\n", v.first)
+	}
+	fmt.Fprintf(w, "Validation: %s\n", map[bool]string{true: "passed", false: "FAILED"}[v.ok()])
+}
+
+// readPairs reads the pairs in the file at path, in whichever of the
+// generator's formats its extension names. JSON goes through the processor's
+// own parser, readGeoPairsFromFile, so that validation covers it. The other
+// formats, which the processor does not parse, use the shared reader.
+func readPairs(path string) ([]haversine.Pair, error) {
+	if f, ok := haversine.FormatForPath(path); !ok || f.Name != haversine.JSONFormat {
+		return haversine.ReadPairsFile(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readGeoPairsFromFile(file)
+}
+
+// validateFile validates the pairs in the file at pairsPath against the
+// answer file at answersPath, printing the result to w. It returns whether
+// they match.
+func validateFile(pairsPath, answersPath string, tolerance float64, w io.Writer) bool {
+	pairs, err := readPairs(pairsPath)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading pairs: %s\n", err)
+		return false
+	}
+
+	file, err := os.Open(answersPath)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading answers: %s\n", err)
+		return false
+	}
+	defer file.Close()
+	ref, err := answers.NewReader(file)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading answers: %s\n", err)
+		return false
+	}
+
+	v, err := validate(pairs, ref, pairDistance, tolerance)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading answers: %s\n", err)
+		return false
+	}
+	v.write(w)
+	return v.ok()
+}
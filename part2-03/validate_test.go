@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ahrav/perf-aware-programming/haversine"
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+var testPairs = []haversine.Pair{
+	{X1: -0.1278, Y1: 51.5074, X2: 2.3522, Y2: 48.8566},
+	{X1: -74.006, Y1: 40.7128, X2: 139.6503, Y2: 35.6762},
+	{X1: 10, Y1: -80, X2: -170, Y2: 80},
+	{X1: 0, Y1: 0, X2: 0, Y2: 0},
+}
+
+// answersFile returns the bytes of an answer file holding distances.
+func answersFile(t *testing.T, distances []float64) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := answers.NewWriter(&buf, uint64(len(distances)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range distances {
+		if err := aw.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeAnswers writes an answer file holding distances and returns a reader
+// of it.
+func writeAnswers(t *testing.T, distances []float64) *answers.Reader {
+	t.Helper()
+	ar, err := answers.NewReader(bytes.NewReader(answersFile(t, distances)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ar
+}
+
+// mustValidate validates pairs against an answer file holding distances.
+func mustValidate(t *testing.T, pairs []haversine.Pair, distances []float64) validation {
+	t.Helper()
+	v, err := validate(pairs, writeAnswers(t, distances), pairDistance, defaultTolerance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func distances(pairs []haversine.Pair) []float64 {
+	ds := make([]float64, len(pairs))
+	for i, p := range pairs {
+		ds[i] = p.Distance()
+	}
+	return ds
+}
+
+func TestValidate(t *testing.T) {
+	ref := distances(testPairs)
+
+	v := mustValidate(t, testPairs, ref)
+	if !v.ok() || v.maxAbsErr != 0 || v.mismatches != 0 {
+		t.Errorf("exact answers: %+v", v)
+	}
+
+	// Small errors within the tolerance still pass.
+	near := append([]float64(nil), ref...)
+	near[1] += defaultTolerance / 2
+	v = mustValidate(t, testPairs, near)
+	if !v.ok() || v.maxErrIndex != 1 {
+		t.Errorf("answers within tolerance: %+v", v)
+	}
+
+	off := append([]float64(nil), ref...)
+	off[2] += 1
+	off[3] += 0.5
+	v = mustValidate(t, testPairs, off)
+	if v.ok() || v.mismatches != 2 || v.maxErrIndex != 2 || v.maxAbsErr != 1 {
+		t.Errorf("wrong answers: %+v", v)
+	}
+	if len(v.first) != 2 || v.first[0] != 2 || v.first[1] != 3 {
+		t.Errorf("first mismatches = %v, want [2 3]", v.first)
+	}
+	if v.averageMatches() {
+		t.Error("average matches despite wrong answers")
+	}
+
+	v = mustValidate(t, testPairs, ref[:3])
+	if v.ok() || v.pairs != 4 || v.expectedPairs != 3 {
+		t.Errorf("missing answer: %+v", v)
+	}
+
+	v = mustValidate(t, testPairs[:3], ref)
+	if v.ok() || v.pairs != 3 || v.expectedPairs != 4 {
+		t.Errorf("extra answer: %+v", v)
+	}
+}
+
+// TestValidateCatchesProcessorErrors checks that validation runs the
+// distance it is given, not the reference the answers came from, so a wrong
+// processor formula fails.
+func TestValidateCatchesProcessorErrors(t *testing.T) {
+	wrong := func(p haversine.Pair) float64 {
+		// Radians from a truncated π, as a careless optimisation might.
+		return pairDistance(p) * 3.1415 / math.Pi
+	}
+	v, err := validate(testPairs, writeAnswers(t, distances(testPairs)), wrong, defaultTolerance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ok() || v.mismatches != 3 || v.averageMatches() {
+		t.Errorf("wrong distances validated: %+v", v)
+	}
+}
+
+func TestValidateRejectsDamagedAnswers(t *testing.T) {
+	b := answersFile(t, distances(testPairs))
+	b[answers.HeaderSize] ^= 1
+
+	ref, err := answers.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validate(testPairs, ref, pairDistance, defaultTolerance); !errors.Is(err, answers.ErrChecksum) {
+		t.Errorf("err = %v, want %v", err, answers.ErrChecksum)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "data.json")
+	data := `{"pairs": [
+		{"X1": -0.1278, "Y1": 51.5074, "X2": 2.3522, "Y2": 48.8566},
+		{"X1": -74.006, "Y1": 40.7128, "X2": 139.6503, "Y2": 35.6762}
+	]}`
+	if err := os.WriteFile(jsonPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := readGeoPairsFromFile(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 {
+		t.Fatalf("read %d pairs, want 2", len(pairs))
+	}
+
+	answersPath := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(answersPath, answersFile(t, distances(pairs)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if !validateFile(jsonPath, answersPath, defaultTolerance, &out) {
+		t.Fatalf("validation failed:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Validation: passed") {
+		t.Errorf("output does not report success:\n%s", out.String())
+	}
+
+	out.Reset()
+	if validateFile(jsonPath, filepath.Join(dir, "missing.bin"), defaultTolerance, &out) {
+		t.Error("validation passed without an answer file")
+	}
+
+	out.Reset()
+	txtPath := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(txtPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if validateFile(txtPath, answersPath, defaultTolerance, &out) || !strings.Contains(out.String(), "unknown pairs format") {
+		t.Errorf("validation of an unknown format:\n%s", out.String())
+	}
+}
+
+// TestValidateFileFormats validates generator output in every format against
+// its answer file.
+func TestValidateFileFormats(t *testing.T) {
+	dist, _ := haversine.LookupDistribution(haversine.Uniform)
+	for i := range haversine.Formats {
+		f := &haversine.Formats[i]
+		dir := t.TempDir()
+		pairsPath := filepath.Join(dir, "data"+f.Ext)
+		answersPath := filepath.Join(dir, "data.bin")
+
+		pairsFile, err := os.Create(pairsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var answersOut bytes.Buffer
+		opts := haversine.DefaultOptions(7, 100)
+		opts.Format = f
+		_, err = dist.Generate(pairsFile, &answersOut, opts)
+		if err := errors.Join(err, pairsFile.Close()); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if err := os.WriteFile(answersPath, answersOut.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var out strings.Builder
+		if !validateFile(pairsPath, answersPath, defaultTolerance, &out) {
+			t.Errorf("%s: validation failed:\n%s", f.Name, out.String())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ahrav/perf-aware-programming/haversine"
+)
+
+// candidate is a haversine implementation the accuracy command can measure.
+type candidate struct {
+	name string
+	fn   haversine.DistanceFunc
+	doc  string
+}
+
+// candidates lists the implementations to measure. Add a faster or
+// approximated haversine here to see what it costs in accuracy.
+var candidates = []candidate{
+	{"haversine", haversine.Haversine, "the float64 reference formula"},
+	{"reciprocal", haversineReciprocal, "radians as d·π·(1/180), as the processor once computed them"},
+	{"atan2", haversineAtan2, "2·atan2(√a, √(1−a)) instead of 2·asin(√a)"},
+}
+
+// haversineReciprocal converts degrees by multiplying with π and the
+// reciprocal of 180 instead of dividing by 180.
+func haversineReciprocal(lat1, lon1, lat2, lon2 float64) float64 {
+	const reciprocal180 = 1.0 / 180
+	radians := func(d float64) float64 { return d * math.Pi * reciprocal180 }
+	dLat := radians(lat2 - lat1)
+	dLon := radians(lon2 - lon1)
+	lat1 = radians(lat1)
+	lat2 = radians(lat2)
+
+	a := haversine.Square(math.Sin(dLat/2)) + math.Cos(lat1)*math.Cos(lat2)*haversine.Square(math.Sin(dLon/2))
+	c := 2 * math.Asin(math.Sqrt(a))
+	return haversine.EarthRadius * c
+}
+
+// haversineAtan2 finishes with atan2, which is better conditioned than asin
+// for nearly antipodal points, where asin's argument is close to 1.
+func haversineAtan2(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := haversine.Radians(lat2 - lat1)
+	dLon := haversine.Radians(lon2 - lon1)
+	lat1 = haversine.Radians(lat1)
+	lat2 = haversine.Radians(lat2)
+
+	a := haversine.Square(math.Sin(dLat/2)) + math.Cos(lat1)*math.Cos(lat2)*haversine.Square(math.Sin(dLon/2))
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return haversine.EarthRadius * c
+}
+
+// runAccuracy measures the error of haversine implementations over the pairs
+// of a generated data file in any format, against a big.Float reference.
+func runAccuracy(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("accuracy", flag.ExitOnError)
+	prec := fs.Uint("prec", 256, "precision of the reference in `bits`")
+	worst := fs.Int("worst", 5, "show the `n` pairs with the largest error of each implementation")
+	limit := fs.Int("limit", 0, "measure only the first `n` pairs (0 measures all)")
+	var names []string
+	for _, c := range candidates {
+		names = append(names, c.name)
+	}
+	impl := fs.String("impl", strings.Join(names, ","), "comma-separated `list` of implementations to measure")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: util accuracy [-prec bits] [-worst n] [-limit n] [-impl list] <pairs file>\n\nimplementations:\n")
+		for _, c := range candidates {
+			fmt.Fprintf(fs.Output(), "  %-10s %s\n", c.name, c.doc)
+		}
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("accuracy: expected 1 file, got %d", fs.NArg())
+	}
+	if *worst < 0 {
+		return fmt.Errorf("accuracy: negative -worst %d", *worst)
+	}
+
+	var selected []candidate
+	for _, name := range strings.Split(*impl, ",") {
+		c, ok := lookupCandidate(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("accuracy: unknown implementation %q", name)
+		}
+		selected = append(selected, c)
+	}
+
+	pairs, err := haversine.ReadPairsFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && *limit < len(pairs) {
+		pairs = pairs[:*limit]
+	}
+
+	refs := haversine.NewPrecise(*prec).Distances(pairs)
+	reports := make([]haversine.ErrorReport, len(selected))
+	for i, c := range selected {
+		reports[i] = haversine.MeasureError(pairs, refs, c.fn, *worst)
+	}
+	return writeAccuracy(out, *prec, selected, reports)
+}
+
+func lookupCandidate(name string) (candidate, bool) {
+	for _, c := range candidates {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return candidate{}, false
+}
+
+// writeAccuracy prints a table of the error of every implementation,
+// followed by the worst pairs of each.
+func writeAccuracy(out io.Writer, prec uint, selected []candidate, reports []haversine.ErrorReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	fmt.Fprintf(out, "%d pairs against a %d-bit reference\n\n", reports[0].Pairs, prec)
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "implementation\tmax ulp\tmean ulp\tmax km\tmean km\tcorrectly rounded")
+	for i, r := range reports {
+		rounded := 0.0
+		if r.Pairs > 0 {
+			rounded = 100 * float64(r.Rounded) / float64(r.Pairs)
+		}
+		fmt.Fprintf(tw, "%s\t%.3g\t%.3g\t%.3g\t%.3g\t%.2f%%\n", selected[i].name, r.MaxULP, r.MeanULP, r.MaxAbs, r.MeanAbs, rounded)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for i, r := range reports {
+		if len(r.Worst) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\nworst pairs of %s:\n", selected[i].name)
+		for _, e := range r.Worst {
+			fmt.Fprintf(out, "  pair %d (X1 %v, Y1 %v, X2 %v, Y2 %v): %v, want %v: %.3g ulp, %.3g km\n",
+				e.Index, e.Pair.X1, e.Pair.Y1, e.Pair.X2, e.Pair.Y2, e.Got, e.Want, e.ULP, e.Abs)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+// runAnswers decodes a haversine answer file as written by the part2-01
+// generator, verifying its header and checksum. It streams the distances, so
+// files of any size fit in memory.
+func runAnswers(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("answers", flag.ExitOnError)
+	list := fs.Bool("list", false, "print the distance of every pair")
+	path, err := parseCommand(fs, "[-list] <data.bin>", args)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ar, err := answers.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(out)
+	s := arrayStats{min: math.Inf(1), max: math.Inf(-1)}
+	for i := 0; ar.Next(); i++ {
+		v := ar.Distance()
+		if *list {
+			fmt.Fprintf(bw, "pair[%d] = %v\n", i, v)
+		}
+		s.count++
+		switch {
+		case math.IsNaN(v):
+			s.nans++
+		case math.IsInf(v, 0):
+			s.infs++
+		default:
+			s.min = math.Min(s.min, v)
+			s.max = math.Max(s.max, v)
+			s.sum += v
+		}
+	}
+	if err := ar.Err(); err != nil {
+		// Report a damaged file rather than a summary of it.
+		return err
+	}
+	fmt.Fprintf(bw, "version:  %d\n", ar.Version())
+	fmt.Fprintf(bw, "pairs:    %d\n", s.count)
+	fmt.Fprintf(bw, "checksum: %#08x ok\n", ar.Checksum())
+	fmt.Fprintf(bw, "average:  %v\n", ar.Average())
+	if s.count > 0 {
+		fmt.Fprintf(bw, "min:      %v\n", s.min)
+		fmt.Fprintf(bw, "max:      %v\n", s.max)
+		if avg := s.sum / float64(s.count); avg != ar.Average() {
+			fmt.Fprintf(bw, "the distances average %v, not the stored average\n", avg)
+		}
+	}
+	if s.nans > 0 || s.infs > 0 {
+		fmt.Fprintf(bw, "invalid:  %d NaN, %d infinite\n", s.nans, s.infs)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+)
+
+// elementType describes how to read one element of a typed array.
+type elementType struct {
+	size int
+	read func(order binary.ByteOrder, b []byte) float64
+}
+
+var elementTypes = map[string]elementType{
+	"u8":  {1, func(_ binary.ByteOrder, b []byte) float64 { return float64(b[0]) }},
+	"u16": {2, func(o binary.ByteOrder, b []byte) float64 { return float64(o.Uint16(b)) }},
+	"i16": {2, func(o binary.ByteOrder, b []byte) float64 { return float64(int16(o.Uint16(b))) }},
+	"u32": {4, func(o binary.ByteOrder, b []byte) float64 { return float64(o.Uint32(b)) }},
+	"f32": {4, func(o binary.ByteOrder, b []byte) float64 { return float64(math.Float32frombits(o.Uint32(b))) }},
+	"f64": {8, func(o binary.ByteOrder, b []byte) float64 { return math.Float64frombits(o.Uint64(b)) }},
+}
+
+// arrayStats summarises the elements of a typed array. NaNs and infinities
+// are counted but excluded from min, max and sum.
+type arrayStats struct {
+	count    int
+	nans     int
+	infs     int
+	min, max float64
+	sum      float64
+	trailing int // Bytes after the last whole element.
+}
+
+// readArray decodes b as elements of type typ, calling visit for each.
+func readArray(b []byte, typ elementType, order binary.ByteOrder, visit func(i int, v float64)) arrayStats {
+	s := arrayStats{min: math.Inf(1), max: math.Inf(-1)}
+	for off := 0; off+typ.size <= len(b); off += typ.size {
+		v := typ.read(order, b[off:off+typ.size])
+		if visit != nil {
+			visit(s.count, v)
+		}
+		s.count++
+		switch {
+		case math.IsNaN(v):
+			s.nans++
+		case math.IsInf(v, 0):
+			s.infs++
+		default:
+			s.min = math.Min(s.min, v)
+			s.max = math.Max(s.max, v)
+			s.sum += v
+		}
+	}
+	s.trailing = len(b) % typ.size
+	return s
+}
+
+func (s arrayStats) write(w io.Writer) {
+	fmt.Fprintf(w, "count:    %d\n", s.count)
+	if finite := s.count - s.nans - s.infs; finite > 0 {
+		fmt.Fprintf(w, "min:      %v\n", s.min)
+		fmt.Fprintf(w, "max:      %v\n", s.max)
+		fmt.Fprintf(w, "sum:      %v\n", s.sum)
+		fmt.Fprintf(w, "mean:     %v\n", s.sum/float64(finite))
+	}
+	fmt.Fprintf(w, "nan:      %d\n", s.nans)
+	fmt.Fprintf(w, "inf:      %d\n", s.infs)
+	if s.trailing > 0 {
+		fmt.Fprintf(w, "trailing: %d bytes\n", s.trailing)
+	}
+}
+
+func runArray(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("array", flag.ExitOnError)
+	var r byteRange
+	r.register(fs)
+	typeName := fs.String("type", "u8", "element `type`: u8, u16, i16, u32, f32 or f64")
+	bigEndian := fs.Bool("be", false, "read big endian elements instead of little endian")
+	values := fs.Bool("values", false, "print every element before the summary")
+	path, err := parseCommand(fs, "[-type t] [-be] [-values] [-offset n] [-length n] <file>", args)
+	if err != nil {
+		return err
+	}
+
+	typ, ok := elementTypes[*typeName]
+	if !ok {
+		return fmt.Errorf("unknown element type %q", *typeName)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if *bigEndian {
+		order = binary.BigEndian
+	}
+	b, err := r.read(path)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(out)
+	var visit func(int, float64)
+	if *values {
+		visit = func(i int, v float64) {
+			fmt.Fprintf(bw, "%08x  [%d] %v\n", r.offset+int64(i*typ.size), i, v)
+		}
+	}
+	readArray(b, typ, order, visit).write(bw)
+	return bw.Flush()
+}
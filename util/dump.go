@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// hexDumpWidth is the number of bytes per hexdump line.
+const hexDumpWidth = 16
+
+// writeHexDump writes b as a hexdump whose offsets start at base:
+//
+//	00000010  25 c0 18 ca 6c 58 bf 41  89 0e 29 1e 70 13 ca 41  |%...lX.A..).p..A|
+func writeHexDump(w io.Writer, b []byte, base int64) error {
+	bw := bufio.NewWriter(w)
+	for i := 0; i < len(b); i += hexDumpWidth {
+		line := b[i:min(i+hexDumpWidth, len(b))]
+		fmt.Fprintf(bw, "%08x  ", base+int64(i))
+		for j := 0; j < hexDumpWidth; j++ {
+			if j == hexDumpWidth/2 {
+				bw.WriteByte(' ')
+			}
+			if j < len(line) {
+				fmt.Fprintf(bw, "%02x ", line[j])
+			} else {
+				bw.WriteString("   ")
+			}
+		}
+		bw.WriteString(" |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			bw.WriteByte(c)
+		}
+		bw.WriteString("|\n")
+	}
+	return bw.Flush()
+}
+
+// writeBits writes every byte of b in binary on its own line, optionally
+// prefixed by its offset counted from base.
+func writeBits(w io.Writer, b []byte, base int64, offsets bool) error {
+	bw := bufio.NewWriter(w)
+	for i, v := range b {
+		if offsets {
+			fmt.Fprintf(bw, "%08x  ", base+int64(i))
+		}
+		fmt.Fprintf(bw, "0b%08b\n", v)
+	}
+	return bw.Flush()
+}
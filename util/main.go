@@ -1,18 +1,145 @@
+// Command util inspects the binary artifacts produced in this repository:
+// raw bytes, haversine answer files and numeric arrays, and measures the
+// accuracy of haversine implementations.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
 )
 
+const usage = `usage: util <command> [flags] <file>
+
+commands:
+  hex      hexdump with offsets and ASCII
+  bits     one byte per line in binary
+  array    interpret the file as a typed array and report min/max/NaN counts
+  answers  decode a haversine data.bin answer file
+  accuracy measure the ulp and km error of haversine implementations over a data file
+
+Run util <command> -h for the flags of a command. The 8086 encoding fields
+of a byte range are labelled by part01-03 fields, with the decoder itself.
+`
+
 func main() {
-	data, err := os.ReadFile(os.Args[1])
-	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "hex":
+		err = runHex(args, os.Stdout)
+	case "bits":
+		err = runBits(args, os.Stdout)
+	case "array":
+		err = runArray(args, os.Stdout)
+	case "answers":
+		err = runAnswers(args, os.Stdout)
+	case "accuracy":
+		err = runAccuracy(args, os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
 		return
+	default:
+		// util <file> dumps the bytes in binary, as it always has.
+		err = runBits(os.Args[1:], os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// byteRange selects part of a file through -offset and -length flags. Both
+// accept decimal, 0x hex, 0o octal or 0b binary values.
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+func (r *byteRange) register(fs *flag.FlagSet) {
+	fs.Int64Var(&r.offset, "offset", 0, "start at byte `n` of the file")
+	fs.Int64Var(&r.length, "length", 0, "inspect at most `n` bytes (0 reads to the end of the file)")
+}
+
+// read returns the selected bytes of the file at path, reading only those
+// bytes rather than the whole file.
+func (r *byteRange) read(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := fi.Size()
+	if r.offset < 0 || r.offset > size {
+		return nil, fmt.Errorf("offset %#x is outside the %d byte file", r.offset, size)
+	}
+	if r.length < 0 {
+		return nil, fmt.Errorf("negative length %d", r.length)
+	}
+	n := size - r.offset
+	if r.length > 0 && r.length < n {
+		n = r.length
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(io.NewSectionReader(f, r.offset, n), b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseCommand parses args for the named command, which takes a single file.
+func parseCommand(fs *flag.FlagSet, synopsis string, args []string) (string, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: util %s %s\n", fs.Name(), synopsis)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
 	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", fmt.Errorf("%s: expected 1 file, got %d", fs.Name(), fs.NArg())
+	}
+	return fs.Arg(0), nil
+}
 
-	for _, b := range data {
-		fmt.Printf("0b%08b\n", b)
+func runHex(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("hex", flag.ExitOnError)
+	var r byteRange
+	r.register(fs)
+	path, err := parseCommand(fs, "[-offset n] [-length n] <file>", args)
+	if err != nil {
+		return err
+	}
+	b, err := r.read(path)
+	if err != nil {
+		return err
+	}
+	return writeHexDump(out, b, r.offset)
+}
+
+func runBits(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bits", flag.ExitOnError)
+	var r byteRange
+	r.register(fs)
+	offsets := fs.Bool("offsets", false, "prefix every byte with its file offset")
+	path, err := parseCommand(fs, "[-offset n] [-length n] [-offsets] <file>", args)
+	if err != nil {
+		return err
+	}
+	b, err := r.read(path)
+	if err != nil {
+		return err
 	}
+	return writeBits(out, b, r.offset, *offsets)
 }
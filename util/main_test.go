@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ahrav/perf-aware-programming/haversine/answers"
+)
+
+func TestByteRangeRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		r    byteRange
+		want string
+	}{
+		{byteRange{}, "0123456789"},
+		{byteRange{offset: 3}, "3456789"},
+		{byteRange{offset: 3, length: 4}, "3456"},
+		{byteRange{offset: 8, length: 4}, "89"},
+		{byteRange{offset: 10}, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.r.read(path)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.r, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.r, got, tt.want)
+		}
+	}
+	for _, r := range []byteRange{{offset: 11}, {offset: -1}, {length: -1}} {
+		if _, err := r.read(path); err == nil {
+			t.Errorf("%+v: read succeeded", r)
+		}
+	}
+}
+
+func TestReadArray(t *testing.T) {
+	b := []byte{0xff, 0xfe, 0x00, 0x02, 0x7f}
+	var got []float64
+	s := readArray(b, elementTypes["i16"], binary.BigEndian, func(_ int, v float64) {
+		got = append(got, v)
+	})
+	if len(got) != 2 || got[0] != -2 || got[1] != 2 {
+		t.Errorf("Expected [-2 2], got %v", got)
+	}
+	if s.count != 2 || s.min != -2 || s.max != 2 || s.trailing != 1 {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+}
+
+func TestReadArrayCountsNaN(t *testing.T) {
+	var b []byte
+	for _, v := range []float64{1.5, math.NaN(), math.Inf(-1), -3} {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	s := readArray(b, elementTypes["f64"], binary.LittleEndian, nil)
+	if s.count != 4 || s.nans != 1 || s.infs != 1 || s.min != -3 || s.max != 1.5 || s.sum != -1.5 {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+}
+
+func TestWriteHexDump(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeHexDump(&out, []byte("0123456789abcdefXY\x00"), 0x10); err != nil {
+		t.Fatal(err)
+	}
+	expected := "00000010  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|\n" +
+		"00000020  58 59 00                                          |XY.|\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
+
+func TestRunAccuracy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	data := `{"pairs": [
+{"X1": -86.67, "Y1": 36.12, "X2": -118.4, "Y2": 33.94},
+{"X1": -4.051064203786808, "Y1": 87.2604283075207, "X2": 175.94886304828037, "Y2": -87.26043124598021}
+]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := runAccuracy([]string{"-prec", "128", "-worst", "1", "-impl", "haversine,atan2", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{"2 pairs against a 128-bit reference", "\nhaversine ", "\natan2 ", "worst pairs of haversine:\n  pair 1 "} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "reciprocal") {
+		t.Errorf("Expected only the selected implementations, got:\n%s", got)
+	}
+
+	// The same pairs as CSV give the same report.
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	csvData := "X1,Y1,X2,Y2\n-86.67,36.12,-118.4,33.94\n-4.051064203786808,87.2604283075207,175.94886304828037,-87.26043124598021\n"
+	if err := os.WriteFile(csvPath, []byte(csvData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runAccuracy([]string{"-prec", "128", "-worst", "1", "-impl", "haversine,atan2", csvPath}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != got {
+		t.Errorf("Expected the CSV report to match the JSON one, got:\n%s", out.String())
+	}
+
+	if err := runAccuracy([]string{"-impl", "nope", path}, &out); err == nil {
+		t.Error("Expected an error for an unknown implementation")
+	}
+	if err := runAccuracy([]string{"-worst", "-1", path}, &out); err == nil {
+		t.Error("Expected an error for a negative -worst")
+	}
+}
+
+func TestRunAnswers(t *testing.T) {
+	var buf bytes.Buffer
+	aw, err := answers.NewWriter(&buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []float64{1.5, 2.5} {
+		if err := aw.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runAnswers([]string{"-list", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"pair[1] = 2.5\n", "pairs:    2\n", "average:  2\n", "max:      2.5\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	damaged := buf.Bytes()
+	damaged[answers.HeaderSize] ^= 1
+	if err := os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runAnswers([]string{path}, &out); !errors.Is(err, answers.ErrChecksum) {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+}